@@ -0,0 +1,83 @@
+package ags
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clfTimeFormat is the timestamp layout of the Common Log Format
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// CombinedLog returns a PostRequestFunc that writes an access log line per
+// request to out in the NCSA Combined Log Format used by Apache and nginx:
+//
+//	127.0.0.1 - ada [10/Oct/2024:13:55:36 +0000] "GET /orders HTTP/1.1" 200 2326 "https://example.com/" "curl/8.4.0"
+//
+// It is independent of the structured logger. Lines are written with a single
+// Write call each and writes are serialized, so out may be shared.
+func CombinedLog(out io.Writer) PostRequestFunc {
+	var mu sync.Mutex
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request, duration time.Duration) {
+		line := combinedLogLine(w, r, time.Now().Add(-duration))
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = io.WriteString(out, line)
+	}
+}
+
+// combinedLogLine formats the log entry of a request received at start
+func combinedLogLine(w http.ResponseWriter, r *http.Request, start time.Time) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	}
+
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+
+	status, size := http.StatusOK, "-"
+	if rw, ok := w.(interface {
+		Status() int
+		Size() int64
+	}); ok {
+		status = rw.Status()
+		if n := rw.Size(); n > 0 {
+			size = strconv.FormatInt(n, 10)
+		}
+	}
+
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s \"%s\" \"%s\"\n",
+		host,
+		clfEscape(user),
+		start.Format(clfTimeFormat),
+		r.Method, clfEscape(uri), r.Proto,
+		status, size,
+		clfEscape(r.Referer()),
+		clfEscape(r.UserAgent()),
+	)
+}
+
+// clfEscaper escapes characters that would break a quoted log field
+var clfEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+
+// clfEscape escapes s for a log field, using "-" for empty values
+func clfEscape(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return clfEscaper.Replace(s)
+}
@@ -0,0 +1,75 @@
+package ags_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+// combinedLogPattern matches a line in the NCSA Combined Log Format
+var combinedLogPattern = regexp.MustCompile(
+	`^(\S+) (\S+) (\S+) \[(\d{2}/\w{3}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})\] "(\S+) (\S+) (\S+)" (\d{3}) (\d+|-) "((?:[^"\\]|\\.)*)" "((?:[^"\\]|\\.)*)"\n$`)
+
+func TestCombinedLog(t *testing.T) {
+	var out bytes.Buffer
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:       &mockLogger{},
+		PostPhase: []ags.PostRequestFunc{ags.CombinedLog(&out)},
+	})
+	h.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+		_ = ags.RespondJSON(w, http.StatusOK, "orders", nil)
+	})
+	h.Get("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name       string
+		target     string
+		setup      func(r *http.Request)
+		wantFields map[int]string
+	}{
+		{
+			name:   "full entry",
+			target: "/orders?page=2",
+			setup: func(r *http.Request) {
+				r.SetBasicAuth("ada", "secret")
+				r.Header.Set("Referer", "https://example.com/")
+				r.Header.Set("User-Agent", `curl/8.4.0 "quoted"`)
+			},
+			wantFields: map[int]string{
+				1: "192.0.2.1", 2: "-", 3: "ada", 5: "GET", 6: "/orders?page=2", 7: "HTTP/1.1",
+				8: "200", 9: "31", 10: "https://example.com/", 11: `curl/8.4.0 \"quoted\"`,
+			},
+		},
+		{
+			name:   "empty values",
+			target: "/empty",
+			wantFields: map[int]string{
+				3: "-", 8: "204", 9: "-", 10: "-", 11: "-",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out.Reset()
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			if tt.setup != nil {
+				tt.setup(req)
+			}
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			m := combinedLogPattern.FindStringSubmatch(out.String())
+			assert.Assert(t, m != nil, "not a Combined Log Format line: %q", out.String())
+			for i, want := range tt.wantFields {
+				assert.Equal(t, want, m[i], "field %d of %q", i, out.String())
+			}
+		})
+	}
+}
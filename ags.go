@@ -1,26 +1,34 @@
 package ags
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"os"
 	"os/signal"
 	"path"
-	"path/filepath"
 	"reflect"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
 	"google.golang.org/grpc"
 
+	"github.com/getangry/ags/internal/singleflight"
 	"github.com/getangry/ags/pkg/cache"
 	"github.com/getangry/ags/pkg/middleware"
 	"github.com/gorilla/websocket"
@@ -50,8 +58,8 @@ type Authorizer interface {
 // processing phases.
 //
 // Fields:
-// - DB: Database connection.
-// - Cache: Caching mechanism.
+// - DB: Database connection, closed by Shutdown.
+// - Cache: Caching mechanism, closed by Shutdown when it implements io.Closer.
 // - Log: Logger for logging messages.
 // - Auth: Authorization handler.
 // - PrePhase: Functions to be executed before the main request processing.
@@ -63,15 +71,96 @@ type ServerConfig struct {
 	Auth      Authorizer
 	PrePhase  []PreRequestFunc
 	PostPhase []PostRequestFunc
+	// OnError is called with every AppError rendered by Handler.Error, right
+	// before the response is written. The status and body are determined
+	// before the hook runs and cannot be changed by it.
+	OnError ErrorHook
+	// ErrorRenderer replaces the default JSON envelope for error responses.
+	// Route groups can override it with Group.OnError.
+	ErrorRenderer ErrorRenderer
+	// ErrorDetails selects which error details are included in client
+	// responses. The default sends none.
+	ErrorDetails ErrorDetailPolicy
+	// SkipPostPhaseOnCancel skips the PostPhase functions for requests whose
+	// context was canceled while the handler ran, e.g. because the client
+	// disconnected, as nobody will read what they write
+	SkipPostPhaseOnCancel bool
+	// ShutdownTimeout bounds how long Start waits for in-flight requests
+	// during a graceful shutdown. Zero uses DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
+	// PreShutdownDelay keeps the server accepting requests for this long
+	// after a shutdown signal or context cancellation, with the readiness
+	// endpoint already reporting 503, so load balancers stop routing new
+	// traffic before connections are closed. Zero shuts down immediately.
+	PreShutdownDelay time.Duration
+	// EnableH2C serves cleartext HTTP/2 (h2c) alongside HTTP/1.1 from Start
+	// and Serve, as needed by gRPC clients using plaintext connections.
+	// ServeTLS negotiates HTTP/2 through ALPN regardless of this setting.
+	EnableH2C bool
+	// GRPCServerOptions configure the gRPC server, e.g. interceptors added
+	// with grpc.ChainUnaryInterceptor. The server is created when the first
+	// service is registered or the first gRPC request arrives, so the options
+	// may be set after NewHandler but not later than that. Interceptors
+	// chained here run after the one ags adds to put DB and Cache into call
+	// contexts; one set with grpc.UnaryInterceptor runs before it.
+	GRPCServerOptions []grpc.ServerOption
+	// MaxHeaderBytes limits the size of the request line and headers read by
+	// the server started with Start or Serve; larger requests get a 431
+	// response. Zero uses http.DefaultMaxHeaderBytes (1 MB). Note the limit
+	// applies to what reaches this server: a reverse proxy in front may add
+	// headers (X-Forwarded-*, tracing) and enforces its own, possibly lower,
+	// limit, so leave headroom for both.
+	MaxHeaderBytes int
+	// Health overrides the response of the /_/health liveness endpoint. Nil
+	// serves the plain-text body "OK".
+	Health *HealthResponse
+	// WebSocket configures the WebSocket upgrader and the default deadlines,
+	// ping interval and message size limit of WebSocket connections. Routes
+	// can override the latter with WSRouteOptions. Nil uses 1 KB buffers, a
+	// 10 second handshake timeout and compression, without deadlines.
+	WebSocket *WSConfig
+	// WSShutdownMessage is sent as JSON to the WebSocket connections stored
+	// with StoreWSConnection during Shutdown, once HTTP requests have
+	// drained and the gRPC server has stopped, ahead of the close frame, so
+	// clients can reconnect to another instance. Nil sends only the close
+	// frame.
+	WSShutdownMessage interface{}
+	// BaseContext returns the context that requests served by Start or Serve
+	// derive from, e.g. one carrying app-wide values or canceled on shutdown
+	// so in-flight handlers stop. It is passed to http.Server.BaseContext;
+	// nil uses context.Background.
+	BaseContext func(net.Listener) context.Context
+	// DebugBodyLimit caps the response body bytes captured for debug dumps;
+	// the rest is left out and the dump notes how much. Zero uses
+	// DefaultDebugBodyLimit.
+	DebugBodyLimit int
+	// Cookies sets the defaults SetCookie applies, see CookieConfig. Nil uses
+	// HttpOnly, SameSite=Lax cookies for path "/", Secure over TLS.
+	Cookies *CookieConfig
 }
 
+// HealthResponse is the body the liveness endpoint answers with, e.g.
+// &HealthResponse{ContentType: "application/json", Body: []byte(`{"status":"ok"}`)}
+type HealthResponse struct {
+	ContentType string
+	Body        []byte
+}
+
+// ErrorHook observes errors rendered by Handler.Error, e.g. to report them to
+// an error tracker or to count them
+type ErrorHook func(ctx context.Context, err *AppError)
+
 // PreRequestFunc defines functions that run before request handling
 type PreRequestFunc func(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error)
 
 // PostRequestFunc defines functions that run after request handling
 type PostRequestFunc func(ctx context.Context, w http.ResponseWriter, r *http.Request, duration time.Duration)
 
-// ResponseWriter wraps http.ResponseWriter to capture response details
+// ResponseWriter wraps http.ResponseWriter to capture response details.
+// Headers, including trailers declared with a Trailer header or set with
+// http.TrailerPrefix after the body, go to the wrapped writer, and flushes
+// pass through so streaming responses such as gRPC-web and server-sent
+// events work behind it.
 type ResponseWriter struct {
 	http.ResponseWriter
 	status    int
@@ -81,10 +170,12 @@ type ResponseWriter struct {
 
 // StandardResponse represents our standard API response structure
 type StandardResponse struct {
-	OK      bool        `json:"ok"`
-	Message string      `json:"message"`
-	Results interface{} `json:"results,omitempty"`
-	Error   *ErrorInfo  `json:"error,omitempty"`
+	OK         bool          `json:"ok"`
+	Message    string        `json:"message"`
+	Results    interface{}   `json:"results,omitempty"`
+	Pagination *Pagination   `json:"pagination,omitempty"`
+	Warnings   []ErrorDetail `json:"warnings,omitempty"`
+	Error      *ErrorInfo    `json:"error,omitempty"`
 }
 
 // ValidationError represents a validation error
@@ -97,9 +188,19 @@ type ValidationError struct {
 type RouteConfig struct {
 	Methods []string
 	Handler http.HandlerFunc
+	pattern string
+	// host is the host pattern the route is bound to, "" for any host; the
+	// pattern then starts with it
+	host string
+	// handlerName is the function name of the registered handler, resolved
+	// once at registration for GetRegisteredRoutes
+	handlerName string
 }
 
-// Protocol Handler interfaces
+// ProtocolHandler serves requests of a protocol other than plain HTTP, e.g.
+// WebSocket or gRPC. Protocol handlers are consulted before routes, in the
+// order returned by Handler.Protocols; the first whose DetectProtocol
+// reports true handles the request.
 type ProtocolHandler interface {
 	DetectProtocol(r *http.Request) bool
 	Handle(w http.ResponseWriter, r *http.Request)
@@ -115,30 +216,50 @@ type ProtocolHandler interface {
 // - routes: A map of route configurations indexed by route names.
 // - middleware: A slice of middleware functions to be applied to the routes.
 // - routeOrder: A slice that tracks the order in which routes are registered.
-// - fileServer: Configuration for the file server if one is registered.
-// - staticHandler: The HTTP handler for serving static files if a file server is registered.
+// - paramRoutes: Routes whose patterns contain parameters, matched in registration order.
+// - fileServers: File server mounts ordered by descending prefix length.
 // - protocols: A slice of protocol handlers for handling different protocols.
-// - grpcServer: The gRPC server instance.
+// - grpcHandler: The gRPC handler, which creates the gRPC server on first use.
 // - wsHandler: The WebSocket handler for managing WebSocket connections.
 // - wsConnections: A concurrent map for storing active WebSocket connections.
 // - upgrader: The WebSocket upgrader for upgrading HTTP connections to WebSocket connections.
 // - logger: The logger instance for logging messages and errors.
 // - debug: Pointer to the debug configuration.
+// - inFlight, inFlightReqs: The count and paths of route handlers currently executing.
+// - draining: Set once Shutdown begins; the readiness endpoint then reports 503.
+// - onShutdown: Cleanups registered with OnShutdown, guarded by shutdownMu.
+// - srv: The server started by Serve, guarded by srvMu.
+// - disabledRoutes: Patterns switched off at runtime, mapped to the status they answer with.
 type Handler struct {
-	ctx           context.Context
-	cfg           *ServerConfig
-	routes        map[string]RouteConfig
-	middleware    []Middleware
-	routeOrder    []string          // Tracks route registration order
-	fileServer    *fileServerConfig // Store file server config if registered
-	staticHandler http.Handler      // Store file server handler if registered
-	protocols     []ProtocolHandler
-	grpcServer    *grpc.Server
-	wsHandler     *WebSocketHandler
-	wsConnections sync.Map
-	upgrader      websocket.Upgrader
-	logger        Logger
-	debug         *DebugConfig
+	ctx            context.Context
+	cfg            *ServerConfig
+	routes         map[string]RouteConfig
+	middleware     []Middleware
+	chainMu        sync.Mutex
+	chain          atomic.Pointer[http.Handler] // middleware wrapped around dispatch, nil until built
+	routeOrder     []string                     // Tracks route registration order
+	hosts          []string                     // Host patterns of host-bound routes, most specific first
+	paramRoutes    []*paramRoute                // Routes with :param segments, in registration order
+	fileServers    []*fileServerConfig          // File server mounts, longest prefix first
+	protocols      []ProtocolHandler
+	grpcHandler    *GRPCHandler
+	wsHandler      *WebSocketHandler
+	wsConnections  sync.Map
+	upgrader       websocket.Upgrader
+	logger         Logger
+	debug          *DebugConfig
+	debugBufs      *debugBufferPool
+	inFlight       atomic.Int64
+	inFlightReqs   sync.Map // *http.Request -> path
+	draining       atomic.Bool
+	shutdownMu     sync.Mutex
+	onShutdown     []ShutdownFunc
+	srvMu          sync.Mutex
+	srv            *http.Server
+	disabledRoutes sync.Map // pattern -> status code
+	fallback       http.Handler
+	maintenance    atomic.Pointer[maintenanceMode] // nil unless SetMaintenance is on
+	cacheAside     singleflight.Group              // deduplicates CacheAside loads on cfg.Cache
 }
 
 // RouteInfo represents the information about a specific route in the application.
@@ -156,11 +277,10 @@ func (h *Handler) GetRegisteredRoutes() []RouteInfo {
 	// Add regular routes first
 	for _, pattern := range h.routeOrder {
 		route := h.routes[pattern]
-		handler := runtime.FuncForPC(reflect.ValueOf(route.Handler).Pointer()).Name()
 		routes = append(routes, RouteInfo{
 			Pattern: pattern,
 			Methods: route.Methods,
-			Handler: handler,
+			Handler: route.handlerName,
 		})
 	}
 
@@ -173,12 +293,12 @@ func (h *Handler) GetRegisteredRoutes() []RouteInfo {
 		})
 	}
 
-	// Add file server if registered
-	if h.fileServer != nil {
+	// Add file server mounts
+	for _, fs := range h.fileServers {
 		routes = append(routes, RouteInfo{
-			Pattern: "/*",
+			Pattern: fs.pattern(),
 			Methods: []string{"GET"},
-			Handler: "FileServer(" + h.fileServer.indexFile + ")",
+			Handler: "FileServer(" + strings.Join(fs.indexFiles, ",") + ")",
 		})
 	}
 
@@ -216,8 +336,14 @@ const (
 // type AppOption func(*ServerConfig)
 // type HandlerOption func(*Handler)
 
-// NewHandler creates a new unified handler
+// NewHandler creates a new unified handler.
+// A nil cfg is treated as an empty ServerConfig; optional subsystems such as
+// DB, Cache and Auth may be left nil.
 func NewHandler(cfg *ServerConfig) *Handler {
+	if cfg == nil {
+		cfg = &ServerConfig{}
+	}
+
 	if cfg.Log == nil {
 		cfg.Log = NewDefaultLogger(InfoLevel)
 	}
@@ -241,7 +367,9 @@ func NewHandler(cfg *ServerConfig) *Handler {
 		logger:        cfg.Log, // Store logger reference
 		debug: &DebugConfig{
 			authKey: os.Getenv("DEBUG_AUTH_KEY"), // Get auth key from environment
-		}, upgrader: websocket.Upgrader{
+		},
+		debugBufs: newDebugBufferPool(cfg.DebugBodyLimit),
+		upgrader: websocket.Upgrader{
 			ReadBufferSize:    1024,
 			WriteBufferSize:   1024,
 			HandshakeTimeout:  10 * time.Second,
@@ -254,18 +382,38 @@ func NewHandler(cfg *ServerConfig) *Handler {
 
 	h.Post("/_/debug/toggle", h.authenticateDebug(h.handleDebugToggle))
 
-	// Health check
+	// Health check (liveness)
+	health := HealthResponse{Body: []byte("OK")}
+	if cfg.Health != nil {
+		health = *cfg.Health
+	}
 	h.Get("/_/health", func(w http.ResponseWriter, r *http.Request) {
+		if health.ContentType != "" {
+			w.Header().Set("Content-Type", health.ContentType)
+		}
 		w.WriteHeader(http.StatusOK)
-		if _, err := w.Write([]byte("OK")); err != nil {
+		if _, err := w.Write(health.Body); err != nil {
+			log.Printf("Failed to write response: %v", err)
+		}
+	})
+
+	// Readiness fails once shutdown begins so load balancers stop routing here
+	h.Get("/_/ready", func(w http.ResponseWriter, r *http.Request) {
+		status, body := http.StatusOK, "OK"
+		if h.Draining() {
+			status, body = http.StatusServiceUnavailable, "DRAINING"
+		}
+		w.WriteHeader(status)
+		if _, err := w.Write([]byte(body)); err != nil {
 			log.Printf("Failed to write response: %v", err)
 		}
 	})
 
 	// Initialize handlers and middleware as before...
-	grpcHandler := NewGRPCHandler()
-	h.grpcServer = grpcHandler.server
-	h.protocols = append(h.protocols, grpcHandler)
+	grpcHandler := &GRPCHandler{
+		options: h.grpcServerOptions,
+	}
+	h.grpcHandler = grpcHandler
 
 	wsConfig := WSConfig{
 		ReadBufferSize:    1024,
@@ -273,14 +421,18 @@ func NewHandler(cfg *ServerConfig) *Handler {
 		HandshakeTimeout:  10 * time.Second,
 		EnableCompression: true,
 	}
+	if cfg.WebSocket != nil {
+		wsConfig = *cfg.WebSocket
+	}
+	if wsConfig.Error == nil {
+		wsConfig.Error = h.wsUpgradeError
+	}
 	wsHandler := NewWebSocketHandler(wsConfig)
+	wsHandler.logger = cfg.Log
 	h.wsHandler = wsHandler
-	h.protocols = append(h.protocols, wsHandler)
 
-	h.AddPreRequestFunc(func(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
-		w.Header().Set("X-PreReq", "valid")
-		return ctx, nil
-	})
+	// WebSocket upgrades are detected first, see SetProtocols
+	h.protocols = append(h.protocols, wsHandler, grpcHandler)
 
 	return h
 }
@@ -323,51 +475,88 @@ func (h *Handler) Log(ctx context.Context) Logger {
 // ServeHTTP implements the http.Handler interface
 // ServeHTTP implements the http.Handler interface
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	var handler http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Check for protocol-specific handlers first
-		for _, ph := range h.protocols {
-			if ph.DetectProtocol(r) {
-				ph.Handle(w, r)
-				return
-			}
-		}
+	// Middleware runs before routing, so it gets a holder that is filled in
+	// once the route is resolved
+	match := &routeMatch{}
+	r = r.WithContext(context.WithValue(r.Context(), routeMatchContextKey, match))
 
-		// Try regular routes next
-		if route, exists := h.routes[r.URL.Path]; exists {
-			if !isMethodAllowed(r.Method, route.Methods) {
-				h.handleMethodNotAllowed(w, r, route.Methods)
-				return
-			}
-			route.Handler(w, r)
+	h.chainHandler().ServeHTTP(w, r)
+}
+
+// chainHandler returns the global middleware wrapped around dispatch, behind
+// the maintenance gate. The chain is built on first use and rebuilt after Use
+// adds middleware, rather than on every request.
+func (h *Handler) chainHandler() http.Handler {
+	if chain := h.chain.Load(); chain != nil {
+		return *chain
+	}
+
+	h.chainMu.Lock()
+	defer h.chainMu.Unlock()
+	if chain := h.chain.Load(); chain != nil {
+		return *chain
+	}
+
+	var handler http.Handler = http.HandlerFunc(h.dispatch)
+	// Apply global middleware in reverse order
+	for i := len(h.middleware) - 1; i >= 0; i-- {
+		handler = h.middleware[i](handler)
+	}
+	handler = h.maintenanceGate(handler)
+	h.chain.Store(&handler)
+	return handler
+}
+
+// dispatch routes a request that has passed the global middleware and
+// records the matched pattern in the holder set up by ServeHTTP
+func (h *Handler) dispatch(w http.ResponseWriter, r *http.Request) {
+	match, ok := r.Context().Value(routeMatchContextKey).(*routeMatch)
+	if !ok {
+		match = &routeMatch{}
+	}
+
+	// Check for protocol-specific handlers first
+	for _, ph := range h.protocols {
+		if ph.DetectProtocol(r) {
+			match.pattern = r.URL.Path
+			ph.Handle(w, r)
 			return
 		}
+	}
 
-		// Static file handling
-		if h.staticHandler != nil {
-			if h.fileServer.serveSPA {
-				fullPath := filepath.Join(h.fileServer.distPath, r.URL.Path)
-				if fi, err := os.Stat(fullPath); err == nil && !fi.IsDir() {
-					h.staticHandler.ServeHTTP(w, r)
-					return
-				}
-				// Serve index.html for SPA routes
-				indexPath := filepath.Join(h.fileServer.distPath, h.fileServer.indexFile)
-				http.ServeFile(w, r, indexPath)
-				return
-			}
-			h.staticHandler.ServeHTTP(w, r)
+	// Try regular routes next
+	route, params, allowed, found := h.resolveRoute(r.Method, r)
+	if found {
+		match.pattern = route.pattern
+		if status, disabled := h.disabledRoutes.Load(route.pattern); disabled {
+			respondDisabledRoute(w, r, status.(int))
 			return
 		}
+		if params != nil {
+			r = r.WithContext(withPathParams(r.Context(), params))
+		}
+		route.Handler(w, r)
+		return
+	}
+	match.pattern = RouteNotFound
+	if len(allowed) > 0 {
+		h.handleMethodNotAllowed(w, r, allowed)
+		return
+	}
 
-		http.NotFound(w, r)
-	})
+	// Static file handling
+	if fs := h.matchFileServer(r.URL.Path); fs != nil {
+		match.pattern = fs.pattern()
+		fs.ServeHTTP(w, r)
+		return
+	}
 
-	// Apply global middleware in reverse order
-	for i := len(h.middleware) - 1; i >= 0; i-- {
-		handler = h.middleware[i](handler)
+	if h.fallback != nil {
+		h.fallback.ServeHTTP(w, r)
+		return
 	}
 
-	handler.ServeHTTP(w, r)
+	http.NotFound(w, r)
 }
 
 // isMethodAllowed checks if the request method is allowed
@@ -382,16 +571,40 @@ func isMethodAllowed(method string, allowedMethods []string) bool {
 
 // Route registers a new HTTP route
 func (h *Handler) Route(pattern string, handler http.HandlerFunc, methods ...string) {
+	h.route(pattern, handler, funcName(handler), methods)
+}
+
+// route registers handler under the name of the function it was built from,
+// so routes wrapped by groups, timeouts or caching report the user's handler
+func (h *Handler) route(pattern string, handler http.HandlerFunc, name string, methods []string) {
+	h.hostRoute("", pattern, handler, name, methods)
+}
+
+// hostRoute is like route for a route bound to a host pattern, see Host. The
+// route is registered under the host followed by the pattern.
+func (h *Handler) hostRoute(host, pattern string, handler http.HandlerFunc, name string, methods []string) {
 	if len(methods) == 0 {
 		methods = []string{MethodGet}
 	}
+	if host != "" && !containsString(h.hosts, host) {
+		h.hosts = append(h.hosts, host)
+		sortHostPatterns(h.hosts)
+	}
 
+	key := host + pattern
 	wrapped := h.wrapHandler(handler)
-	h.routes[pattern] = RouteConfig{
-		Methods: methods,
-		Handler: wrapped,
+	config := RouteConfig{
+		Methods:     methods,
+		Handler:     wrapped,
+		pattern:     key,
+		host:        host,
+		handlerName: name,
+	}
+	h.routes[key] = config
+	if isParamPattern(pattern) {
+		h.addParamRoute(key, config)
 	}
-	h.routeOrder = append(h.routeOrder, pattern)
+	h.routeOrder = append(h.routeOrder, key)
 }
 
 // HTTP Method-specific routing helpers
@@ -419,9 +632,18 @@ func (h *Handler) Patch(pattern string, handler http.HandlerFunc) {
 func (h *Handler) wrapHandler(handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
-		ctx := r.Context()
+		ctx := context.WithValue(r.Context(), startContextKey, start)
 		logger := h.Log(ctx)
 
+		h.inFlight.Add(1)
+		h.inFlightReqs.Store(r, r.URL.Path)
+		defer func() {
+			h.inFlightReqs.Delete(r)
+			h.inFlight.Add(-1)
+		}()
+
+		ctx = h.withDependencies(ctx)
+
 		// Debug request dump if enabled
 		if h.isDebugEnabled() {
 			reqDump, err := httputil.DumpRequest(r, true)
@@ -441,6 +663,7 @@ func (h *Handler) wrapHandler(handler http.HandlerFunc) http.HandlerFunc {
 			handler: h,
 			request: r,
 		}
+		defer rw.dumpResponse()
 
 		// Pre-request phase
 		var err error
@@ -460,10 +683,24 @@ func (h *Handler) wrapHandler(handler http.HandlerFunc) http.HandlerFunc {
 		// Post-request phase
 		duration := time.Since(start)
 		logger.Debug("request completed",
+			"route", RoutePattern(ctx),
 			"status", rw.status,
 			"duration_ms", duration.Milliseconds(),
 			"size", rw.size)
 
+		// Surface requests whose client went away or whose deadline passed
+		// while the handler was running
+		if err := ctx.Err(); err != nil {
+			logger.Warn("request context done before handler completed",
+				"error", err,
+				"path", r.URL.Path,
+				"duration_ms", duration.Milliseconds(),
+				"committed", rw.committed)
+			if h.cfg.SkipPostPhaseOnCancel {
+				return
+			}
+		}
+
 		for _, post := range h.cfg.PostPhase {
 			post(ctx, rw, r, duration)
 		}
@@ -479,6 +716,21 @@ func (w *ResponseWriter) WriteHeader(status int) {
 	}
 }
 
+// Committed reports whether the response status and headers have been written
+func (w *ResponseWriter) Committed() bool {
+	return w.committed
+}
+
+// Status returns the response status, 200 until another one is written
+func (w *ResponseWriter) Status() int {
+	return w.status
+}
+
+// Size returns the number of body bytes written
+func (w *ResponseWriter) Size() int64 {
+	return w.size
+}
+
 func (w *ResponseWriter) Write(b []byte) (int, error) {
 	if !w.committed {
 		w.WriteHeader(http.StatusOK)
@@ -488,6 +740,25 @@ func (w *ResponseWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Flush sends buffered data to the client, see FlushError
+func (w *ResponseWriter) Flush() {
+	_ = w.FlushError()
+}
+
+// FlushError sends buffered data to the client, writing the status first
+// if needed. It is used by http.ResponseController.
+func (w *ResponseWriter) FlushError() error {
+	if !w.committed {
+		w.WriteHeader(http.StatusOK)
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *ResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func (h *Handler) handleMethodNotAllowed(w http.ResponseWriter, r *http.Request, allowedMethods []string) {
 	w.Header().Set("Allow", strings.Join(allowedMethods, ", "))
 	if err := RespondJSON(w, http.StatusMethodNotAllowed, "Method not allowed", map[string]interface{}{
@@ -498,23 +769,131 @@ func (h *Handler) handleMethodNotAllowed(w http.ResponseWriter, r *http.Request,
 	}
 }
 
-// RespondJSON sends a standardized JSON response
+// ErrResponseCommitted is returned by RespondJSON when the response has already
+// been written, e.g. because a handler responded twice
+var ErrResponseCommitted = errors.New("ags: response already committed")
+
+// RespondJSON sends a standardized JSON response. Its Content-Type is
+// application/json unless one was set on w beforehand.
 func RespondJSON(w http.ResponseWriter, status int, message string, data interface{}) error {
+	return RespondJSONWith(w, status, message, data)
+}
+
+// JSONOption configures how RespondJSONWith writes a response
+type JSONOption func(*jsonOptions)
+
+type jsonOptions struct {
+	trailingNewline bool
+	bufferLimit     int
+	pagination      *Pagination
+	warnings        []ErrorDetail
+}
+
+// DefaultBufferedBodyLimit is the largest body WithBufferedBody sends with a
+// Content-Length when given a non-positive limit
+const DefaultBufferedBodyLimit = 64 << 10
+
+// WithBufferedBody encodes the body into memory first and sets Content-Length
+// when it is at most limit bytes, so clients and caches see a sized response
+// instead of a chunked one. Larger bodies are streamed as usual. A limit of
+// zero or less uses DefaultBufferedBodyLimit.
+func WithBufferedBody(limit int) JSONOption {
+	return func(o *jsonOptions) {
+		if limit <= 0 {
+			limit = DefaultBufferedBodyLimit
+		}
+		o.bufferLimit = limit
+	}
+}
+
+// WithoutTrailingNewline omits the newline json.Encoder appends after the body.
+// The body is then marshaled in full before writing and Content-Length is set
+// to its exact size. By default the encoder streams the body, the newline is
+// part of it and Content-Length is left to net/http, which only sets it for
+// small bodies.
+func WithoutTrailingNewline() JSONOption {
+	return func(o *jsonOptions) {
+		o.trailingNewline = false
+	}
+}
+
+// WithWarnings adds non-fatal warnings to the envelope. They do not affect
+// the ok field, which only depends on the status.
+func WithWarnings(warnings ...ErrorDetail) JSONOption {
+	return func(o *jsonOptions) {
+		o.warnings = append(o.warnings, warnings...)
+	}
+}
+
+// RespondJSONWithWarnings sends a standardized JSON response carrying
+// warnings, e.g. deprecation notices built with NewWarning, next to the
+// results
+func RespondJSONWithWarnings(w http.ResponseWriter, status int, message string, data interface{}, warnings []ErrorDetail) error {
+	return RespondJSONWith(w, status, message, data, WithWarnings(warnings...))
+}
+
+// RespondJSONWith is like RespondJSON with options controlling the encoding
+func RespondJSONWith(w http.ResponseWriter, status int, message string, data interface{}, opts ...JSONOption) error {
+	o := jsonOptions{trailingNewline: true}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if c, ok := w.(interface{ Committed() bool }); ok && c.Committed() {
+		if dw := debugWriterOf(w); dw != nil {
+			dw.handler.Log(dw.request.Context()).Error("response already committed, dropping JSON response",
+				"status", status,
+				"message", message)
+		}
+		return ErrResponseCommitted
+	}
+
 	response := StandardResponse{
-		OK:      status >= 200 && status < 300,
-		Message: message,
-		Results: data,
+		OK:         status >= 200 && status < 300,
+		Message:    message,
+		Results:    data,
+		Pagination: o.pagination,
+		Warnings:   o.warnings,
+	}
+
+	// All headers are final before WriteHeader. A Content-Type set earlier,
+	// e.g. a vendor media type, is kept; Content-Length is left out when a
+	// middleware has announced an encoding that changes the body size.
+	header := w.Header()
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", "application/json")
+	}
+	if o.trailingNewline && o.bufferLimit == 0 {
+		w.WriteHeader(status)
+		return json.NewEncoder(w).Encode(response)
 	}
 
-	w.Header().Set("Content-Type", "application/json")
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if err := enc.Encode(response); err != nil {
+		return err
+	}
+	body := buf.Bytes()
+	if !o.trailingNewline {
+		body = bytes.TrimSuffix(body, []byte("\n"))
+	}
+
+	if (!o.trailingNewline || len(body) <= o.bufferLimit) && header.Get("Content-Encoding") == "" {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
 	w.WriteHeader(status)
-	return json.NewEncoder(w).Encode(response)
+	_, err := w.Write(body)
+	return err
 }
 
 func (h *Handler) AddPreRequestFunc(fn PreRequestFunc) {
 	h.cfg.PrePhase = append(h.cfg.PrePhase, fn)
 }
 
+// DefaultShutdownTimeout is how long Start waits for in-flight requests to
+// finish when ServerConfig.ShutdownTimeout is zero
+const DefaultShutdownTimeout = 5 * time.Second
+
 // Start begins serving the application.
 //
 // Usage:
@@ -524,19 +903,73 @@ func (h *Handler) AddPreRequestFunc(fn PreRequestFunc) {
 //		log.Fatalf("Failed to start server: %v", err)
 //	}
 func (a *Handler) Start() error {
+	ln, err := net.Listen("tcp", ":7841")
+	if err != nil {
+		return err
+	}
+	return a.Serve(ln)
+}
+
+// SetContext sets the context that controls the server lifetime; canceling it
+// shuts the server down gracefully
+func (a *Handler) SetContext(ctx context.Context) {
+	a.ctx = ctx
+}
+
+// Serve serves the application on ln until a shutdown signal is received or
+// the handler context is canceled, then shuts down gracefully after
+// PreShutdownDelay. If requests are still running when ShutdownTimeout
+// expires, their count and paths are logged.
+func (a *Handler) Serve(ln net.Listener) error {
+	return a.serve(ln, func(srv *http.Server) error {
+		return srv.Serve(ln)
+	})
+}
+
+// ServeTLS is like Serve but serves HTTPS with the given certificate and key
+// files. Clients supporting HTTP/2 negotiate it through ALPN.
+func (a *Handler) ServeTLS(ln net.Listener, certFile, keyFile string) error {
+	return a.serve(ln, func(srv *http.Server) error {
+		return srv.ServeTLS(ln, certFile, keyFile)
+	})
+}
+
+// serve runs the server with run until it is shut down
+func (a *Handler) serve(ln net.Listener, run func(*http.Server) error) error {
 	if a.ctx == nil {
 		a.ctx = context.Background()
 	}
 
+	h2s := &http2.Server{}
+	var handler http.Handler = middleware.RequestID(a)
+	if a.cfg.EnableH2C {
+		handler = h2c.NewHandler(handler, h2s)
+	}
 	srv := &http.Server{
-		Addr:    ":7841",
-		Handler: middleware.RequestID(a),
+		Handler:        handler,
+		MaxHeaderBytes: a.cfg.MaxHeaderBytes,
+		BaseContext:    a.cfg.BaseContext,
 		// ErrorLog: a.Logger.Logger(),
 	}
+	if err := http2.ConfigureServer(srv, h2s); err != nil {
+		return err
+	}
+	a.srvMu.Lock()
+	a.srv = srv
+	a.srvMu.Unlock()
+
+	shutdownTimeout := a.cfg.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = DefaultShutdownTimeout
+	}
 
 	shutdownSignal := make(chan os.Signal, 1)
+	shutdownStarted := make(chan struct{})
 	serverShutdown := make(chan struct{})
+	serveDone := make(chan struct{})
+	defer close(serveDone)
 	signal.Notify(shutdownSignal, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(shutdownSignal)
 
 	go func() {
 		select {
@@ -544,44 +977,153 @@ func (a *Handler) Start() error {
 			log.Println("Shutdown signal received, shutting down server...")
 		case <-a.ctx.Done():
 			log.Println("Context canceled, shutting down server...")
+		case <-serveDone:
+			return
+		}
+		close(shutdownStarted)
+
+		// Fail readiness first and keep serving while traffic drains away
+		if delay := a.cfg.PreShutdownDelay; delay > 0 {
+			a.draining.Store(true)
+			log.Printf("Draining for %s before shutdown...", delay)
+			time.Sleep(delay)
 		}
 
 		// Gracefully shutdown the server
-		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 		defer cancel()
-		if err := srv.Shutdown(shutdownCtx); err != nil {
-			log.Printf("Server shutdown error: %v", err)
+		if err := a.Shutdown(shutdownCtx); err != nil {
+			if errors.Is(err, context.DeadlineExceeded) {
+				a.logger.Error("shutdown timed out with requests still in flight",
+					"in_flight", a.InFlight(),
+					"paths", a.inFlightPaths(),
+					"timeout", shutdownTimeout.String())
+			} else {
+				log.Printf("Server shutdown error: %v", err)
+			}
 		}
 		close(serverShutdown)
 	}()
 
-	log.Printf("Server starting on %s", ":7841")
-	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+	log.Printf("Server starting on %s", ln.Addr())
+	if err := run(srv); err != http.ErrServerClosed {
 		return err
 	}
 
-	<-serverShutdown
+	// When Shutdown was called directly, its caller waits for the drain
+	select {
+	case <-shutdownStarted:
+		<-serverShutdown
+	default:
+	}
 	log.Println("Server stopped.")
 	return nil
 }
 
+// Shutdown marks the handler as draining, so the readiness endpoint reports
+// 503 Service Unavailable, and then shuts down its subsystems in order:
+//
+//  1. the server started by Start or Serve stops accepting connections and
+//     waits for in-flight requests to finish;
+//  2. the gRPC server stops gracefully, once its calls have finished;
+//  3. stored WebSocket connections are closed, after receiving
+//     WSShutdownMessage when it is set;
+//  4. the functions registered with OnShutdown run;
+//  5. the configured DB is closed, and the Cache if it implements io.Closer.
+//
+// Every step is bounded by ctx. When it is done before a step finishes, the
+// step and the ones left are logged and Shutdown returns the context error
+// without running them. Otherwise it returns the first error of a step.
+func (a *Handler) Shutdown(ctx context.Context) error {
+	a.draining.Store(true)
+
+	var first error
+	steps := a.shutdownSteps()
+	for i, step := range steps {
+		err := a.runShutdownStep(ctx, step)
+		if err != nil && ctx.Err() != nil {
+			a.logger.Error("shutdown step timed out",
+				"step", step.name,
+				"skipped", shutdownStepNames(steps[i+1:]))
+			return err
+		}
+		if err != nil {
+			a.logger.Error("shutdown step failed", "step", step.name, "error", err)
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// Draining reports whether Shutdown has begun
+func (a *Handler) Draining() bool {
+	return a.draining.Load()
+}
+
+// InFlight returns the number of route handlers currently executing
+func (a *Handler) InFlight() int64 {
+	return a.inFlight.Load()
+}
+
+// inFlightPaths returns the sorted paths of the requests currently executing
+func (a *Handler) inFlightPaths() []string {
+	var paths []string
+	a.inFlightReqs.Range(func(_, value interface{}) bool {
+		paths = append(paths, value.(string))
+		return true
+	})
+	sort.Strings(paths)
+	return paths
+}
+
 // Middleware represents a function that wraps an http.Handler
 type Middleware func(http.Handler) http.Handler
 
 // Group represents a group of routes with shared middleware and prefix
 type Group struct {
-	handler    *Handler
-	prefix     string
-	middleware []Middleware
+	handler       *Handler
+	host          string // host pattern set by Handler.Host, "" for any host
+	prefix        string
+	middleware    []Middleware
+	errorRenderer ErrorRenderer
 }
 
 // Use adds middleware to the group
 func (h *Handler) Use(middleware ...Middleware) {
+	h.chainMu.Lock()
+	defer h.chainMu.Unlock()
 	h.middleware = append(h.middleware, middleware...)
+	h.chain.Store(nil)
+}
+
+// Middleware returns the names of the global middleware in the order they
+// were registered, which is also the order requests pass through them
+func (h *Handler) Middleware() []string {
+	return middlewareNames(h.middleware)
+}
+
+// middlewareNames resolves the function names of mws for debugging
+func middlewareNames(mws []Middleware) []string {
+	names := make([]string, 0, len(mws))
+	for _, mw := range mws {
+		names = append(names, funcName(mw))
+	}
+	return names
+}
+
+// funcName resolves the name of the function f for debugging
+func funcName(f interface{}) string {
+	return runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
 }
 
-// Group creates a new route group with the given prefix
+// Group creates a new route group with the given prefix. The prefix may
+// contain path parameters, e.g. /orgs/:org, which are available to every route
+// in the group through PathParam. A parameter name used in both the prefix and
+// a route panics when the route is registered.
 func (h *Handler) Group(prefix string, mw ...Middleware) *Group {
+	validateGroupPrefix(prefix)
 	group := &Group{
 		handler:    h,
 		prefix:     prefix,
@@ -595,18 +1137,42 @@ func (h *Handler) Group(prefix string, mw ...Middleware) *Group {
 	return group
 }
 
-// Use adds middleware to the group
+// Use adds middleware to the group. Sub-groups created earlier keep the
+// middleware they were created with.
 func (g *Group) Use(middleware ...Middleware) *Group {
-	g.middleware = append(g.middleware, middleware...)
+	// Capping the capacity makes append copy, so the group never writes into
+	// an array shared with a parent or sibling
+	n := len(g.middleware)
+	g.middleware = append(g.middleware[:n:n], middleware...)
+	return g
+}
+
+// Middleware returns the names of the group middleware in the order they
+// were registered. Global middleware, reported by Handler.Middleware, runs
+// before these.
+func (g *Group) Middleware() []string {
+	return middlewareNames(g.middleware)
+}
+
+// OnError sets the renderer used for errors reported by the group's routes
+// through Handler.Error, instead of ServerConfig.ErrorRenderer. Sub-groups
+// created afterwards inherit it.
+func (g *Group) OnError(renderer ErrorRenderer) *Group {
+	g.errorRenderer = renderer
 	return g
 }
 
-// Group creates a sub-group with an additional prefix
+// Group creates a sub-group with an additional prefix. It starts with a copy
+// of the group's middleware; later Use calls on either group do not affect
+// the other.
 func (g *Group) Group(prefix string) *Group {
+	validateGroupPrefix(path.Join(g.prefix, prefix))
 	return &Group{
-		handler:    g.handler,
-		prefix:     path.Join(g.prefix, prefix),
-		middleware: append([]Middleware{}, g.middleware...), // Copy parent middleware
+		handler:       g.handler,
+		host:          g.host,
+		prefix:        path.Join(g.prefix, prefix),
+		middleware:    append([]Middleware{}, g.middleware...), // Copy parent middleware
+		errorRenderer: g.errorRenderer,
 	}
 }
 
@@ -622,8 +1188,19 @@ func (g *Group) Route(pattern string, handler http.HandlerFunc, methods ...strin
 		wrapped = g.middleware[i](http.HandlerFunc(wrapped)).ServeHTTP
 	}
 
+	// Select the group's error renderer for the request
+	inner := wrapped
+	wrapped = func(w http.ResponseWriter, r *http.Request) {
+		if g.errorRenderer != nil {
+			if dw := debugWriterOf(w); dw != nil {
+				dw.errorRenderer = g.errorRenderer
+			}
+		}
+		inner(w, r)
+	}
+
 	// Apply handler's internal wrapping last
-	g.handler.Route(fullPath, wrapped, methods...)
+	g.handler.hostRoute(g.host, fullPath, wrapped, funcName(handler), methods)
 }
 
 // Add convenience methods for HTTP verbs
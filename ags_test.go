@@ -3,40 +3,71 @@ package ags_test
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
 	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/getangry/ags"
 	"github.com/getangry/ags/pkg/middleware"
 	"github.com/gorilla/websocket"
+	"golang.org/x/net/http2"
 	"gotest.tools/assert"
 )
 
 type mockLogger struct {
-	lastError string
+	lastError      string
+	lastWarn       string
+	lastWarnFields []interface{}
+	lastFields     []interface{}
+	lastCtx        context.Context
 }
 
 func (m *mockLogger) Error(msg string, args ...interface{}) {
 	m.lastError = msg
+	m.lastFields = args
+}
+
+// field returns the value logged for key in the last error entry
+func (m *mockLogger) field(key string) interface{} {
+	for i := 0; i+1 < len(m.lastFields); i += 2 {
+		if m.lastFields[i] == key {
+			return m.lastFields[i+1]
+		}
+	}
+	return nil
+}
+
+func (m *mockLogger) Info(msg string, fields ...interface{}) {}
+
+func (m *mockLogger) Warn(msg string, fields ...interface{}) {
+	m.lastWarn = msg
+	m.lastWarnFields = fields
 }
 
-func (m *mockLogger) Info(msg string, fields ...interface{})  {}
-func (m *mockLogger) Warn(msg string, fields ...interface{})  {}
 func (m *mockLogger) Debug(msg string, fields ...interface{}) {}
+func (m *mockLogger) Fatal(msg string, fields ...interface{}) {}
+func (m *mockLogger) Panic(msg string, fields ...interface{}) { panic(msg) }
 func (m *mockLogger) GetLevel() ags.LogLevel {
 	return ags.DebugLevel
 }
 func (m *mockLogger) SetLevel(level ags.LogLevel) {}
+func (m *mockLogger) Enabled(level ags.LogLevel) bool {
+	return true
+}
 func (m *mockLogger) WithFields(fields map[string]interface{}) ags.Logger {
 	return m
 }
 func (m *mockLogger) WithContext(ctx context.Context) ags.Logger {
+	m.lastCtx = ctx
 	return m
 }
 
@@ -161,6 +192,59 @@ func TestGRPCHandler_DetectProtocol(t *testing.T) {
 	}
 }
 
+// fakeProtocol serves requests carrying an X-Protocol: fake header
+type fakeProtocol struct{}
+
+func (fakeProtocol) DetectProtocol(r *http.Request) bool { return r.Header.Get("X-Protocol") == "fake" }
+func (fakeProtocol) Handle(w http.ResponseWriter, r *http.Request) {
+	_, _ = w.Write([]byte("fake protocol"))
+}
+
+// A WebSocket upgrade with a gRPC content type must reach the WebSocket handler
+func TestHandler_ProtocolPrecedence(t *testing.T) {
+	mockLog := &mockLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{Log: mockLog})
+	h.RegisterWSRoute("/stream", func(conn *websocket.Conn) {})
+
+	req := httptest.NewRequest(http.MethodGet, "/stream", nil)
+	req.ProtoMajor = 2
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+
+	assert.Assert(t, !ags.NewGRPCHandler().DetectProtocol(req))
+
+	// The recorder cannot be hijacked, so the WebSocket handler fails the
+	// upgrade and logs it; the gRPC server would have answered on its own
+	h.ServeHTTP(httptest.NewRecorder(), req)
+	assert.Equal(t, "websocket upgrade failed", mockLog.lastWarn)
+
+	protocols := h.Protocols()
+	assert.Equal(t, 2, len(protocols))
+	_, wsFirst := protocols[0].(*ags.WebSocketHandler)
+	assert.Assert(t, wsFirst)
+}
+
+func TestHandler_SetProtocols(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("route"))
+	})
+	h.SetProtocols(append([]ags.ProtocolHandler{fakeProtocol{}}, h.Protocols()...)...)
+
+	req := httptest.NewRequest(http.MethodGet, "/hello", nil)
+	req.Header.Set("X-Protocol", "fake")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	assert.Equal(t, "fake protocol", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/hello", nil))
+	assert.Equal(t, "route", rec.Body.String())
+}
+
 // Test method not allowed handling
 func TestHandler_MethodNotAllowed(t *testing.T) {
 	h := newTestHandler()
@@ -366,6 +450,59 @@ func TestMiddlewareOrder(t *testing.T) {
 	assert.DeepEqual(t, expected, order)
 }
 
+func TestMiddlewareChain_BuiltOnce(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{})
+	built := 0
+	header := func(name string) ags.Middleware {
+		return func(next http.Handler) http.Handler {
+			built++
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Chain", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+	h.Use(header("first"))
+	h.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		ags.RespondJSON(w, http.StatusOK, "test", nil)
+	})
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+		assert.DeepEqual(t, []string{"first"}, rec.Header().Values("X-Chain"))
+	}
+	assert.Equal(t, 1, built)
+
+	// Middleware added after the first request still applies
+	h.Use(header("second"))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/test", nil))
+	assert.DeepEqual(t, []string{"first", "second"}, rec.Header().Values("X-Chain"))
+	assert.Equal(t, 3, built)
+}
+
+func BenchmarkHandler_ServeHTTP_Middleware(b *testing.B) {
+	h := ags.NewHandler(&ags.ServerConfig{})
+	for i := 0; i < 5; i++ {
+		h.Use(func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				next.ServeHTTP(w, r)
+			})
+		})
+	}
+	h.Get("/test", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/test", nil)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+}
+
 func TestMultipleGroups(t *testing.T) {
 	h := ags.NewHandler(&ags.ServerConfig{})
 
@@ -431,3 +568,775 @@ func TestMultipleGroups(t *testing.T) {
 		})
 	}
 }
+
+func TestGroup_MiddlewareIsolation(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{})
+	tag := func(name string) ags.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Chain", name)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	api := h.Group("/api", tag("api"))
+	api.Use(tag("auth"), tag("audit"))
+	v1 := api.Group("/v1")
+	v2 := api.Group("/v2")
+
+	// Adding to one child after its sibling exists must not leak into the
+	// sibling or the parent, and later parent middleware stays out of both
+	v1.Use(tag("v1"))
+	v2.Use(tag("v2"))
+	api.Use(tag("late"))
+
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+	api.Get("/ping", handler)
+	v1.Get("/ping", handler)
+	v2.Get("/ping", handler)
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{path: "/api/ping", want: []string{"api", "auth", "audit", "late"}},
+		{path: "/api/v1/ping", want: []string{"api", "auth", "audit", "v1"}},
+		{path: "/api/v2/ping", want: []string{"api", "auth", "audit", "v2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, http.StatusNoContent, rec.Code)
+			assert.DeepEqual(t, tt.want, rec.Header().Values("X-Chain"))
+		})
+	}
+}
+
+func TestGroup_OnError(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+
+	problem := func(w http.ResponseWriter, r *http.Request, err *ags.AppError) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(err.StatusCode)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"title":  err.Message,
+			"status": err.StatusCode,
+		})
+	}
+	plain := func(w http.ResponseWriter, r *http.Request, err *ags.AppError) {
+		http.Error(w, err.Message, err.StatusCode)
+	}
+
+	notFound := func(w http.ResponseWriter, r *http.Request) {
+		h.Error(w, ags.NewError(ags.ErrCodeNotFound, "no such order"))
+	}
+
+	api := h.Group("/api").OnError(problem)
+	api.Get("/orders", notFound)
+	api.Group("/v2").Get("/orders", notFound)
+	h.Group("/web").OnError(plain).Get("/orders", notFound)
+	h.Get("/orders", notFound)
+
+	tests := []struct {
+		name            string
+		path            string
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:            "group renderer",
+			path:            "/api/orders",
+			wantContentType: "application/problem+json",
+			wantBody:        `{"status":404,"title":"no such order"}` + "\n",
+		},
+		{
+			name:            "inherited by sub-group",
+			path:            "/api/v2/orders",
+			wantContentType: "application/problem+json",
+			wantBody:        `{"status":404,"title":"no such order"}` + "\n",
+		},
+		{
+			name:            "other group renderer",
+			path:            "/web/orders",
+			wantContentType: "text/plain; charset=utf-8",
+			wantBody:        "no such order\n",
+		},
+		{
+			name:            "default outside groups",
+			path:            "/orders",
+			wantContentType: "application/json",
+			wantBody:        `{"ok":false,"message":"no such order","error":{"code":"NOT_FOUND","message":"no such order"}}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Equal(t, tt.wantContentType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
+
+func TestHandler_HealthResponse(t *testing.T) {
+	tests := []struct {
+		name            string
+		health          *ags.HealthResponse
+		wantContentType string
+		wantBody        string
+	}{
+		{
+			name:     "default",
+			wantBody: "OK",
+		},
+		{
+			name:            "json",
+			health:          &ags.HealthResponse{ContentType: "application/json", Body: []byte(`{"status":"ok"}`)},
+			wantContentType: "application/json",
+			wantBody:        `{"status":"ok"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}, Health: tt.health})
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/_/health", nil))
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.wantContentType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
+
+func TestRespondJSON_HeaderMiddleware(t *testing.T) {
+	setHeader := func(key, value string) ags.Middleware {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set(key, value)
+				next.ServeHTTP(w, r)
+			})
+		}
+	}
+
+	tests := []struct {
+		name              string
+		middleware        ags.Middleware
+		wantContentType   string
+		wantContentLength string
+	}{
+		{
+			name:              "unrelated header",
+			middleware:        setHeader("X-Frame-Options", "DENY"),
+			wantContentType:   "application/json",
+			wantContentLength: "31",
+		},
+		{
+			name:              "content type set earlier",
+			middleware:        setHeader("Content-Type", "application/vnd.api+json"),
+			wantContentType:   "application/vnd.api+json",
+			wantContentLength: "31",
+		},
+		{
+			name:            "encoding middleware",
+			middleware:      setHeader("Content-Encoding", "gzip"),
+			wantContentType: "application/json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+			h.Use(tt.middleware)
+			h.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+				_ = ags.RespondJSONWith(w, http.StatusOK, "orders", nil, ags.WithBufferedBody(0))
+			})
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+			assert.Equal(t, tt.wantContentType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantContentLength, rec.Header().Get("Content-Length"))
+			assert.Equal(t, `{"ok":true,"message":"orders"}`+"\n", rec.Body.String())
+		})
+	}
+}
+
+func TestRespondJSONWithWarnings(t *testing.T) {
+	rec := httptest.NewRecorder()
+	err := ags.RespondJSONWithWarnings(rec, http.StatusOK, "orders", []int{1}, []ags.ErrorDetail{
+		ags.NewWarning("DEPRECATED", "use /v2/orders"),
+	})
+	assert.NilError(t, err)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `{"ok":true,"message":"orders","results":[1],"warnings":[{"code":"DEPRECATED","message":"use /v2/orders","severity":"warning"}]}`+"\n", rec.Body.String())
+
+	var resp ags.StandardResponse
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Assert(t, resp.OK)
+	assert.Assert(t, resp.Error == nil)
+	assert.DeepEqual(t, []ags.ErrorDetail{{Code: "DEPRECATED", Message: "use /v2/orders", Severity: ags.SeverityWarning}}, resp.Warnings)
+}
+
+func TestRespondJSON_AlreadyCommitted(t *testing.T) {
+	mockLog := &mockLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{Log: mockLog})
+
+	var firstErr, secondErr error
+	h.Get("/twice", func(w http.ResponseWriter, r *http.Request) {
+		firstErr = ags.RespondJSON(w, http.StatusCreated, "first", nil)
+		secondErr = ags.RespondJSON(w, http.StatusInternalServerError, "second", nil)
+	})
+
+	req := httptest.NewRequest("GET", "/twice", nil)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, `{"ok":true,"message":"first"}
+`, rec.Body.String())
+	assert.NilError(t, firstErr)
+	assert.Equal(t, ags.ErrResponseCommitted, secondErr)
+	assert.Equal(t, "response already committed, dropping JSON response", mockLog.lastError)
+}
+
+func TestNewHandler_EmptyConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *ags.ServerConfig
+	}{
+		{name: "nil config", cfg: nil},
+		{name: "empty config", cfg: &ags.ServerConfig{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ags.NewHandler(tt.cfg)
+
+			h.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+				ags.RespondJSON(w, http.StatusOK, "hello", nil)
+			})
+			h.GetCached("/cached", func(w http.ResponseWriter, r *http.Request) {
+				ags.RespondJSON(w, http.StatusOK, "cached", nil)
+			}, time.Minute)
+
+			for _, path := range []string{"/hello", "/cached", "/_/health"} {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+				assert.Equal(t, http.StatusOK, rec.Code, path)
+			}
+		})
+	}
+}
+
+func passThrough(next http.Handler) http.Handler { return next }
+func setHeader(next http.Handler) http.Handler   { return next }
+func logRequests(next http.Handler) http.Handler { return next }
+
+func TestHandler_Middleware(t *testing.T) {
+	h := newTestHandler()
+	h.Use(passThrough, middleware.RequestID)
+	h.Use(setHeader)
+
+	assert.DeepEqual(t, []string{
+		"github.com/getangry/ags_test.passThrough",
+		"github.com/getangry/ags/pkg/middleware.RequestID",
+		"github.com/getangry/ags_test.setHeader",
+	}, h.Middleware())
+
+	api := h.Group("/api", logRequests)
+	api.Use(passThrough)
+	v1 := api.Group("/v1").Use(setHeader)
+
+	assert.DeepEqual(t, []string{
+		"github.com/getangry/ags_test.logRequests",
+		"github.com/getangry/ags_test.passThrough",
+	}, api.Middleware())
+	assert.DeepEqual(t, []string{
+		"github.com/getangry/ags_test.logRequests",
+		"github.com/getangry/ags_test.passThrough",
+		"github.com/getangry/ags_test.setHeader",
+	}, v1.Middleware())
+}
+
+func TestHandler_Serve_ShutdownTimeoutLogsInFlight(t *testing.T) {
+	mockLog := &mockLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:             mockLog,
+		ShutdownTimeout: 50 * time.Millisecond,
+	})
+
+	release := make(chan struct{})
+	defer close(release)
+	h.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.SetContext(ctx)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ln) }()
+
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/slow")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for h.InFlight() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("slow request never started")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		assert.NilError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return after the shutdown timeout")
+	}
+
+	assert.Equal(t, "shutdown timed out with requests still in flight", mockLog.lastError)
+	assert.Equal(t, int64(1), mockLog.field("in_flight"))
+	assert.DeepEqual(t, []string{"/slow"}, mockLog.field("paths"))
+}
+
+func TestHandler_Serve_MaxHeaderBytes(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:            &mockLogger{},
+		MaxHeaderBytes: 1 << 10,
+	})
+	h.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.SetContext(ctx)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ln) }()
+	defer func() {
+		cancel()
+		<-served
+	}()
+
+	url := "http://" + ln.Addr().String() + "/ping"
+	tests := []struct {
+		name       string
+		headerSize int
+		wantStatus int
+	}{
+		{name: "within limit", headerSize: 100, wantStatus: http.StatusOK},
+		// The server allows some slack over MaxHeaderBytes, so exceed it well
+		{name: "oversized", headerSize: 16 << 10, wantStatus: http.StatusRequestHeaderFieldsTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, url, nil)
+			assert.NilError(t, err)
+			req.Header.Set("X-Padding", strings.Repeat("a", tt.headerSize))
+
+			resp, err := http.DefaultClient.Do(req)
+			assert.NilError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, tt.wantStatus, resp.StatusCode)
+		})
+	}
+}
+
+type baseContextKey struct{}
+
+func TestHandler_Serve_BaseContext(t *testing.T) {
+	base, cancelBase := context.WithCancel(context.WithValue(context.Background(), baseContextKey{}, "app"))
+	defer cancelBase()
+
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:         &mockLogger{},
+		BaseContext: func(net.Listener) context.Context { return base },
+	})
+	h.Get("/value", func(w http.ResponseWriter, r *http.Request) {
+		value, _ := r.Context().Value(baseContextKey{}).(string)
+		ags.RespondJSON(w, http.StatusOK, value, nil)
+	})
+	canceled := make(chan struct{})
+	h.Get("/wait", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		close(canceled)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.SetContext(ctx)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ln) }()
+	defer func() {
+		cancel()
+		<-served
+	}()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/value")
+	assert.NilError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.NilError(t, err)
+	assert.Equal(t, `{"ok":true,"message":"app"}`+"\n", string(body))
+
+	// Canceling the base context reaches handlers already running
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String() + "/wait")
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for h.InFlight() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("request never started")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancelBase()
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("handler context not canceled with the base context")
+	}
+}
+
+func TestNewHandler_NoDefaultPreRequestHeaders(t *testing.T) {
+	cfg := &ags.ServerConfig{Log: &mockLogger{}}
+	h := ags.NewHandler(cfg)
+	h.Get("/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	assert.Equal(t, 0, len(cfg.PrePhase))
+
+	for _, path := range []string{"/ping", "/_/health"} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, path)
+		assert.Equal(t, "", rec.Header().Get("X-PreReq"), path)
+	}
+}
+
+func TestRespondJSONWith_TrailingNewline(t *testing.T) {
+	tests := []struct {
+		name              string
+		opts              []ags.JSONOption
+		wantBody          string
+		wantContentLength string
+	}{
+		{
+			name:     "default keeps newline",
+			wantBody: `{"ok":true,"message":"hi","results":1}` + "\n",
+		},
+		{
+			name:              "without trailing newline",
+			opts:              []ags.JSONOption{ags.WithoutTrailingNewline()},
+			wantBody:          `{"ok":true,"message":"hi","results":1}`,
+			wantContentLength: "38",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			err := ags.RespondJSONWith(rec, http.StatusOK, "hi", 1, tt.opts...)
+			assert.NilError(t, err)
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+			assert.Equal(t, tt.wantContentLength, rec.Header().Get("Content-Length"))
+		})
+	}
+}
+
+func TestHandler_ReadinessDuringShutdown(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ln) }()
+
+	base := "http://" + ln.Addr().String()
+	var resp *http.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err = http.Get(base + "/_/ready")
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NilError(t, h.Shutdown(ctx))
+	assert.NilError(t, <-served)
+	assert.Assert(t, h.Draining())
+
+	tests := []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/_/ready", wantStatus: http.StatusServiceUnavailable},
+		{path: "/_/health", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		assert.Equal(t, tt.wantStatus, rec.Code, tt.path)
+	}
+}
+
+func TestHandler_PreShutdownDelay(t *testing.T) {
+	const delay = 300 * time.Millisecond
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}, PreShutdownDelay: delay})
+	h.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+		_ = ags.RespondJSON(w, http.StatusOK, "orders", nil)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	h.SetContext(ctx)
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ln) }()
+
+	base := "http://" + ln.Addr().String()
+	get := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(base + path)
+		assert.NilError(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if resp, err := http.Get(base + "/_/ready"); err == nil {
+			resp.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("server did not start")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	start := time.Now()
+	cancel()
+	for !h.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+
+	// Within the delay window readiness fails but requests are still served
+	assert.Equal(t, http.StatusServiceUnavailable, get("/_/ready"))
+	assert.Equal(t, http.StatusOK, get("/orders"))
+
+	assert.NilError(t, <-served)
+	assert.Assert(t, time.Since(start) >= delay, "shut down after %s", time.Since(start))
+}
+
+func TestHandler_Serve_H2C(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}, EnableH2C: true})
+	h.Get("/proto", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.SetContext(ctx)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ln) }()
+	defer func() {
+		cancel()
+		assert.NilError(t, <-served)
+	}()
+
+	// Prior-knowledge h2c: HTTP/2 frames over a plain TCP connection
+	client := &http.Client{Transport: &http2.Transport{
+		AllowHTTP: true,
+		DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		},
+	}}
+
+	resp, err := client.Get("http://" + ln.Addr().String() + "/proto")
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NilError(t, err)
+
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "HTTP/2.0", string(body))
+}
+
+func TestWrapHandler_ContextCanceledMidHandler(t *testing.T) {
+	for _, skip := range []bool{false, true} {
+		mockLog := &mockLogger{}
+		postRan := false
+		h := ags.NewHandler(&ags.ServerConfig{
+			Log:                   mockLog,
+			SkipPostPhaseOnCancel: skip,
+			PostPhase: []ags.PostRequestFunc{
+				func(ctx context.Context, w http.ResponseWriter, r *http.Request, d time.Duration) {
+					postRan = true
+				},
+			},
+		})
+
+		ctx, cancel := context.WithCancel(context.Background())
+		h.Get("/work", func(w http.ResponseWriter, r *http.Request) {
+			// Simulate the client disconnecting while the handler works
+			cancel()
+			if err := ags.CheckContext(r.Context()); err != nil {
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+
+		req := httptest.NewRequest(http.MethodGet, "/work", nil).WithContext(ctx)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, "request context done before handler completed", mockLog.lastWarn)
+		assert.Equal(t, !skip, postRan, "SkipPostPhaseOnCancel=%v", skip)
+	}
+}
+
+func TestRespondJSONWith_BufferedBody(t *testing.T) {
+	small := `{"ok":true,"message":"hi","results":"x"}` + "\n"
+	large := strings.Repeat("y", 200)
+
+	tests := []struct {
+		name              string
+		data              string
+		opts              []ags.JSONOption
+		wantContentLength string
+	}{
+		{name: "streaming default", data: "x"},
+		{
+			name:              "buffered small body",
+			data:              "x",
+			opts:              []ags.JSONOption{ags.WithBufferedBody(0)},
+			wantContentLength: fmt.Sprint(len(small)),
+		},
+		{
+			name: "buffered body over the limit streams",
+			data: large,
+			opts: []ags.JSONOption{ags.WithBufferedBody(100)},
+		},
+		{
+			name:              "buffered without newline",
+			data:              "x",
+			opts:              []ags.JSONOption{ags.WithBufferedBody(0), ags.WithoutTrailingNewline()},
+			wantContentLength: fmt.Sprint(len(small) - 1),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			assert.NilError(t, ags.RespondJSONWith(rec, http.StatusOK, "hi", tt.data, tt.opts...))
+			assert.Equal(t, tt.wantContentLength, rec.Header().Get("Content-Length"))
+			if tt.wantContentLength != "" {
+				assert.Equal(t, tt.wantContentLength, fmt.Sprint(rec.Body.Len()))
+			}
+		})
+	}
+
+	// Over a real connection the buffered response is not chunked
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/data", func(w http.ResponseWriter, r *http.Request) {
+		_ = ags.RespondJSONWith(w, http.StatusOK, "hi", large, ags.WithBufferedBody(0))
+	})
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/data")
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Assert(t, resp.ContentLength > 0, "content length %d", resp.ContentLength)
+	assert.Equal(t, 0, len(resp.TransferEncoding))
+}
+
+func TestResponseWriter_Trailers(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	flushed := make(chan error, 1)
+	grpcWeb := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc-web")
+		_, _ = w.Write([]byte("frame"))
+		flushed <- http.NewResponseController(w).Flush()
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set(http.TrailerPrefix+"Grpc-Message", "done")
+	}
+	h.Post("/stream", grpcWeb)
+	h.PostTimeout("/buffered", grpcWeb, time.Second)
+
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	tests := []struct {
+		name      string
+		path      string
+		wantFlush bool
+	}{
+		{name: "streamed", path: "/stream", wantFlush: true},
+		{name: "behind timeout", path: "/buffered"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(srv.URL+tt.path, "application/grpc-web", nil)
+			assert.NilError(t, err)
+			defer resp.Body.Close()
+
+			// Trailers are only known once the body has been read
+			assert.Equal(t, resp.Trailer.Get("Grpc-Status"), "")
+			body, err := io.ReadAll(resp.Body)
+			assert.NilError(t, err)
+
+			assert.Equal(t, string(body), "frame")
+			assert.Equal(t, resp.Header.Get("Grpc-Status"), "")
+			assert.Equal(t, resp.Trailer.Get("Grpc-Status"), "0")
+			assert.Equal(t, resp.Trailer.Get("Grpc-Message"), "done")
+			if flushErr := <-flushed; tt.wantFlush {
+				assert.NilError(t, flushErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package ags
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// AllOf returns an Authorizer that accepts a request only when every
+// authorizer does, e.g. a valid session AND an allowed client IP. They run in
+// order and the first failure is returned unchanged, so an AppError keeps its
+// status. With no authorizers every request is accepted.
+func AllOf(authorizers ...Authorizer) Authorizer {
+	return allOf(authorizers)
+}
+
+// AnyOf returns an Authorizer that accepts a request as soon as one of the
+// authorizers does, e.g. an API key OR a session. They run in order. When all
+// of them fail, the returned AuthErrors holds each failure. With no
+// authorizers every request is rejected.
+func AnyOf(authorizers ...Authorizer) Authorizer {
+	return anyOf(authorizers)
+}
+
+type allOf []Authorizer
+
+func (a allOf) Authorize(ctx context.Context, r *http.Request) error {
+	for _, auth := range a {
+		if err := auth.Authorize(ctx, r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type anyOf []Authorizer
+
+func (a anyOf) Authorize(ctx context.Context, r *http.Request) error {
+	errs := make(AuthErrors, 0, len(a))
+	for _, auth := range a {
+		err := auth.Authorize(ctx, r)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// AuthErrors is returned by an AnyOf authorizer when none accepted the
+// request, with the failure of each authorizer in order. errors.Is and
+// errors.As match any of them.
+type AuthErrors []error
+
+func (e AuthErrors) Error() string {
+	if len(e) == 0 {
+		return "no authorizer configured"
+	}
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return "no authorizer accepted the request: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the individual failures
+func (e AuthErrors) Unwrap() []error {
+	return e
+}
+
+// RequireAuth returns a pre-request function running auth, e.g. the
+// configured ServerConfig.Auth or an AllOf/AnyOf combination. Failures that
+// are not an AppError are reported as 401 Unauthorized.
+func RequireAuth(auth Authorizer) PreRequestFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
+		err := auth.Authorize(ctx, r)
+		if err == nil {
+			return ctx, nil
+		}
+		var appErr *AppError
+		if errors.As(err, &appErr) {
+			return ctx, appErr
+		}
+		return ctx, NewError(ErrCodeUnauthorized, "Unauthorized").WithError(err)
+	}
+}
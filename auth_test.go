@@ -0,0 +1,141 @@
+package ags_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+// authFunc is a stub Authorizer returning err and counting its calls
+type authFunc struct {
+	err   error
+	calls int
+}
+
+func (a *authFunc) Authorize(ctx context.Context, r *http.Request) error {
+	a.calls++
+	return a.err
+}
+
+var (
+	errNoKey     = errors.New("missing API key")
+	errNoSession = errors.New("no session")
+)
+
+func TestAllOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []error
+		wantErr   error
+		wantCalls []int
+	}{
+		{name: "all pass", results: []error{nil, nil}, wantCalls: []int{1, 1}},
+		{name: "first fails", results: []error{errNoKey, nil}, wantErr: errNoKey, wantCalls: []int{1, 0}},
+		{name: "last fails", results: []error{nil, errNoSession}, wantErr: errNoSession, wantCalls: []int{1, 1}},
+		{name: "none", results: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auths := make([]*authFunc, len(tt.results))
+			authorizers := make([]ags.Authorizer, len(tt.results))
+			for i, err := range tt.results {
+				auths[i] = &authFunc{err: err}
+				authorizers[i] = auths[i]
+			}
+
+			err := ags.AllOf(authorizers...).Authorize(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil))
+			assert.Equal(t, tt.wantErr, err)
+			for i, want := range tt.wantCalls {
+				assert.Equal(t, want, auths[i].calls)
+			}
+		})
+	}
+}
+
+func TestAnyOf(t *testing.T) {
+	tests := []struct {
+		name      string
+		results   []error
+		wantErr   string
+		wantCalls []int
+	}{
+		{name: "first passes", results: []error{nil, errNoSession}, wantCalls: []int{1, 0}},
+		{name: "last passes", results: []error{errNoKey, nil}, wantCalls: []int{1, 1}},
+		{
+			name:      "all fail",
+			results:   []error{errNoKey, errNoSession},
+			wantErr:   "no authorizer accepted the request: missing API key; no session",
+			wantCalls: []int{1, 1},
+		},
+		{name: "none", results: nil, wantErr: "no authorizer configured"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			auths := make([]*authFunc, len(tt.results))
+			authorizers := make([]ags.Authorizer, len(tt.results))
+			for i, err := range tt.results {
+				auths[i] = &authFunc{err: err}
+				authorizers[i] = auths[i]
+			}
+
+			err := ags.AnyOf(authorizers...).Authorize(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if tt.wantErr == "" {
+				assert.NilError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+			for i, want := range tt.wantCalls {
+				assert.Equal(t, want, auths[i].calls)
+			}
+		})
+	}
+}
+
+func TestAnyOf_ErrorsMatch(t *testing.T) {
+	err := ags.AnyOf(&authFunc{err: errNoKey}, &authFunc{err: errNoSession}).
+		Authorize(context.Background(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Assert(t, errors.Is(err, errNoKey))
+	assert.Assert(t, errors.Is(err, errNoSession))
+	var authErrs ags.AuthErrors
+	assert.Assert(t, errors.As(err, &authErrs))
+	assert.Equal(t, 2, len(authErrs))
+}
+
+func TestRequireAuth(t *testing.T) {
+	forbidden := ags.NewError(ags.ErrCodeUnauthorized, "IP not allowed")
+	forbidden.StatusCode = http.StatusForbidden
+
+	tests := []struct {
+		name       string
+		auth       ags.Authorizer
+		wantStatus int
+	}{
+		{name: "api key", auth: ags.AnyOf(&authFunc{}, &authFunc{err: errNoSession}), wantStatus: http.StatusOK},
+		{name: "no credentials", auth: ags.AnyOf(&authFunc{err: errNoKey}, &authFunc{err: errNoSession}), wantStatus: http.StatusUnauthorized},
+		{name: "ip not allowed", auth: ags.AllOf(&authFunc{}, &authFunc{err: forbidden}), wantStatus: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ags.NewHandler(&ags.ServerConfig{
+				Log:      &mockLogger{},
+				PrePhase: []ags.PreRequestFunc{ags.RequireAuth(tt.auth)},
+			})
+			h.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders", nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package ags
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BodyDecoder decodes the body of r into v, honoring the options passed to
+// Bind
+type BodyDecoder func(r *http.Request, v interface{}, opts BindOptions) error
+
+// DefaultMultipartMemory is the number of bytes of a multipart body kept in
+// memory by the multipart/form-data decoder; larger parts go to temporary files
+const DefaultMultipartMemory = 32 << 20
+
+// Validator checks a value decoded by Bind, typically using struct tags such
+// as `validate:"required,email"`. Implementations report invalid fields by
+// returning ValidationErrors; any other error is reported as a whole.
+type Validator interface {
+	Validate(v interface{}) error
+}
+
+// ValidatorFunc adapts a function to the Validator interface
+type ValidatorFunc func(v interface{}) error
+
+// Validate calls f(v)
+func (f ValidatorFunc) Validate(v interface{}) error {
+	return f(v)
+}
+
+// ValidationErrors lists the fields that failed validation
+type ValidationErrors []ValidationError
+
+func (e ValidationErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, fe := range e {
+		msgs[i] = fe.Field + ": " + fe.Message
+	}
+	return strings.Join(msgs, "; ")
+}
+
+var (
+	validatorMu sync.RWMutex
+	validator   Validator
+
+	decodersMu sync.RWMutex
+	decoders   = map[string]BodyDecoder{
+		"application/json":                  decodeJSON,
+		"application/x-www-form-urlencoded": decodeForm,
+		"multipart/form-data":               decodeMultipartForm,
+	}
+)
+
+// RegisterDecoder registers d for the media type contentType, replacing any
+// existing decoder. Use it to add formats such as YAML or CBOR:
+//
+//	ags.RegisterDecoder("application/yaml", func(r *http.Request, v interface{}, _ ags.BindOptions) error {
+//		return yaml.NewDecoder(r.Body).Decode(v)
+//	})
+func RegisterDecoder(contentType string, d BodyDecoder) {
+	decodersMu.Lock()
+	defer decodersMu.Unlock()
+	decoders[strings.ToLower(contentType)] = d
+}
+
+// SetValidator sets the validator Bind runs after decoding, or disables
+// validation when v is nil. To use go-playground/validator, convert its
+// ValidationErrors:
+//
+//	validate := validator.New()
+//	ags.SetValidator(ags.ValidatorFunc(func(v interface{}) error {
+//		var verrs validator.ValidationErrors
+//		if err := validate.Struct(v); !errors.As(err, &verrs) {
+//			return err
+//		}
+//		var out ags.ValidationErrors
+//		for _, fe := range verrs {
+//			out = append(out, ags.ValidationError{Field: fe.Field(), Message: fe.Tag()})
+//		}
+//		return out
+//	}))
+func SetValidator(v Validator) {
+	validatorMu.Lock()
+	defer validatorMu.Unlock()
+	validator = v
+}
+
+// BindOption configures a Bind call
+type BindOption func(*BindOptions)
+
+// BindOptions holds the options of a Bind call, as passed to its BodyDecoder
+type BindOptions struct {
+	// UseNumber decodes numbers into interface{} values as json.Number
+	UseNumber bool
+}
+
+// WithUseNumber makes the built-in JSON decoder decode numbers into
+// interface{} values, such as map[string]interface{} fields, as json.Number
+// instead of float64, so integers beyond 2^53 keep their precision
+func WithUseNumber() BindOption {
+	return func(o *BindOptions) {
+		o.UseNumber = true
+	}
+}
+
+// Bind decodes the request body into v using the decoder registered for the
+// request Content-Type, then runs the validator set with SetValidator. It
+// returns an AppError: 415 Unsupported Media Type when no decoder matches,
+// 400 Bad Request when decoding fails and a validation error with one field
+// detail per invalid field when validation fails.
+func Bind(r *http.Request, v interface{}, opts ...BindOption) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return unsupportedMediaType(r.Header.Get("Content-Type"))
+	}
+
+	decodersMu.RLock()
+	decode, ok := decoders[mediaType]
+	decodersMu.RUnlock()
+	if !ok {
+		return unsupportedMediaType(mediaType)
+	}
+
+	var o BindOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	// Decoders get r itself, so net/http removes the temporary files of a
+	// multipart form parsed on it
+	if err := decode(r, v, o); err != nil {
+		return NewError(ErrCodeBadRequest, "Invalid request body").
+			WithError(err).
+			WithContext(r.Context())
+	}
+
+	validatorMu.RLock()
+	val := validator
+	validatorMu.RUnlock()
+	if val == nil {
+		return nil
+	}
+	if err := val.Validate(v); err != nil {
+		return validationError(err).WithContext(r.Context())
+	}
+	return nil
+}
+
+// validationError converts a validator error into an AppError
+func validationError(err error) *AppError {
+	var verrs ValidationErrors
+	if !errors.As(err, &verrs) {
+		return NewError(ErrCodeValidation, err.Error()).WithError(err)
+	}
+	appErr := NewError(ErrCodeValidation, "Validation failed").WithError(err)
+	for _, fe := range verrs {
+		appErr.WithField(fe.Field, fe.Message)
+	}
+	return appErr
+}
+
+// unsupportedMediaType returns the error Bind reports for an unknown Content-Type
+func unsupportedMediaType(contentType string) *AppError {
+	appErr := NewError(ErrCodeBadRequest, "Unsupported Content-Type").
+		AddInternalLog("no body decoder for %q", contentType)
+	appErr.StatusCode = http.StatusUnsupportedMediaType
+	return appErr
+}
+
+func decodeJSON(r *http.Request, v interface{}, opts BindOptions) error {
+	dec := json.NewDecoder(r.Body)
+	if opts.UseNumber {
+		dec.UseNumber()
+	}
+	return dec.Decode(v)
+}
+
+func decodeForm(r *http.Request, v interface{}, _ BindOptions) error {
+	if err := r.ParseForm(); err != nil {
+		return err
+	}
+	return decodeValues(r.PostForm, v)
+}
+
+func decodeMultipartForm(r *http.Request, v interface{}, _ BindOptions) error {
+	if err := r.ParseMultipartForm(DefaultMultipartMemory); err != nil {
+		return err
+	}
+	return decodeValues(r.MultipartForm.Value, v)
+}
+
+// decodeValues copies form values into the fields of the struct v points to.
+// A field's form name comes from its `form` tag, then its `json` tag, then
+// its name. Strings, booleans, numbers and slices of them are supported.
+func decodeValues(values url.Values, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("form values can only be decoded into a pointer to a struct, got %T", v)
+	}
+	rv = rv.Elem()
+	rt := rv.Type()
+
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := formFieldName(field)
+		if name == "-" {
+			continue
+		}
+		vals, ok := values[name]
+		if !ok || len(vals) == 0 {
+			continue
+		}
+
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Slice {
+			slice := reflect.MakeSlice(fv.Type(), len(vals), len(vals))
+			for j, s := range vals {
+				if err := setFormValue(slice.Index(j), s); err != nil {
+					return fmt.Errorf("field %s: %w", name, err)
+				}
+			}
+			fv.Set(slice)
+			continue
+		}
+		if err := setFormValue(fv, vals[0]); err != nil {
+			return fmt.Errorf("field %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// formFieldName returns the form key for field
+func formFieldName(field reflect.StructField) string {
+	for _, tag := range []string{"form", "json"} {
+		if name, _, _ := strings.Cut(field.Tag.Get(tag), ","); name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+// setFormValue parses s into v according to its kind
+func setFormValue(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package ags_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+type signupRequest struct {
+	Email string   `json:"email" form:"email"`
+	Age   int      `json:"age"`
+	Admin bool     `form:"is_admin"`
+	Tags  []string `form:"tag"`
+	Skip  string   `form:"-"`
+}
+
+func TestBind(t *testing.T) {
+	form := url.Values{
+		"email":    {"ada@example.com"},
+		"age":      {"36"},
+		"is_admin": {"true"},
+		"tag":      {"a", "b"},
+		"Skip":     {"x"},
+	}
+
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	for key, vals := range form {
+		for _, v := range vals {
+			assert.NilError(t, mw.WriteField(key, v))
+		}
+	}
+	assert.NilError(t, mw.Close())
+
+	want := signupRequest{Email: "ada@example.com", Age: 36, Admin: true, Tags: []string{"a", "b"}}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		want        signupRequest
+	}{
+		{
+			name:        "json",
+			contentType: "application/json; charset=utf-8",
+			body:        `{"email":"ada@example.com","age":36}`,
+			want:        signupRequest{Email: "ada@example.com", Age: 36},
+		},
+		{
+			name:        "urlencoded form",
+			contentType: "application/x-www-form-urlencoded",
+			body:        form.Encode(),
+			want:        want,
+		},
+		{
+			name:        "multipart form",
+			contentType: mw.FormDataContentType(),
+			body:        multipartBody.String(),
+			want:        want,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", tt.contentType)
+
+			var got signupRequest
+			assert.NilError(t, ags.Bind(req, &got))
+			assert.DeepEqual(t, tt.want, got)
+		})
+	}
+}
+
+func TestBind_Errors(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{name: "unsupported type", contentType: "application/yaml", body: "email: a", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "missing type", body: "{}", wantStatus: http.StatusUnsupportedMediaType},
+		{name: "malformed json", contentType: "application/json", body: "{", wantStatus: http.StatusBadRequest},
+		{name: "bad form number", contentType: "application/x-www-form-urlencoded", body: "age=old", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+
+			var got signupRequest
+			err := ags.Bind(req, &got)
+
+			var appErr *ags.AppError
+			assert.Assert(t, errors.As(err, &appErr), "got %v", err)
+			assert.Equal(t, tt.wantStatus, appErr.StatusCode)
+		})
+	}
+}
+
+func TestRegisterDecoder(t *testing.T) {
+	ags.RegisterDecoder("text/x-email", func(r *http.Request, v interface{}, _ ags.BindOptions) error {
+		var buf bytes.Buffer
+		if _, err := buf.ReadFrom(r.Body); err != nil {
+			return err
+		}
+		v.(*signupRequest).Email = strings.TrimSpace(buf.String())
+		return nil
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/signup", strings.NewReader("grace@example.com\n"))
+	req.Header.Set("Content-Type", "text/x-email")
+
+	var got signupRequest
+	assert.NilError(t, ags.Bind(req, &got))
+	assert.Equal(t, "grace@example.com", got.Email)
+}
+
+type contactRequest struct {
+	Name  string `json:"name" validate:"required"`
+	Email string `json:"email" validate:"required,email"`
+}
+
+// tagValidator is a minimal stand-in for a tag-based validation library
+func tagValidator(v interface{}) error {
+	var verrs ags.ValidationErrors
+	rv := reflect.ValueOf(v).Elem()
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		value := rv.Field(i).String()
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			switch {
+			case rule == "required" && value == "":
+				verrs = append(verrs, ags.ValidationError{Field: name, Message: "is required"})
+			case rule == "email" && value != "" && !strings.Contains(value, "@"):
+				verrs = append(verrs, ags.ValidationError{Field: name, Message: "must be a valid email"})
+			}
+		}
+	}
+	if len(verrs) > 0 {
+		return verrs
+	}
+	return nil
+}
+
+func TestBind_Validation(t *testing.T) {
+	ags.SetValidator(ags.ValidatorFunc(tagValidator))
+	defer ags.SetValidator(nil)
+
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}, ErrorDetails: ags.ErrorDetailsFields})
+	h.Post("/contacts", func(w http.ResponseWriter, r *http.Request) {
+		var req contactRequest
+		if err := ags.Bind(r, &req); err != nil {
+			h.Error(w, err)
+			return
+		}
+		_ = ags.RespondJSON(w, http.StatusCreated, "created", req)
+	})
+
+	tests := []struct {
+		name        string
+		body        string
+		wantStatus  int
+		wantDetails []ags.ErrorDetail
+	}{
+		{
+			name:       "valid",
+			body:       `{"name":"Ada","email":"ada@example.com"}`,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "invalid email",
+			body:       `{"name":"Ada","email":"ada.example.com"}`,
+			wantStatus: http.StatusBadRequest,
+			wantDetails: []ags.ErrorDetail{
+				{Code: ags.ErrCodeValidation, Message: "must be a valid email", Field: "email"},
+			},
+		},
+		{
+			name:       "missing fields",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+			wantDetails: []ags.ErrorDetail{
+				{Code: ags.ErrCodeValidation, Message: "is required", Field: "name"},
+				{Code: ags.ErrCodeValidation, Message: "is required", Field: "email"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/contacts", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantDetails == nil {
+				return
+			}
+			var resp ags.StandardResponse
+			assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, ags.ErrCodeValidation, resp.Error.Code)
+			assert.DeepEqual(t, tt.wantDetails, resp.Error.Details)
+		})
+	}
+}
+
+func TestBind_ValidatorError(t *testing.T) {
+	ags.SetValidator(ags.ValidatorFunc(func(v interface{}) error {
+		return errors.New("payload rejected")
+	}))
+	defer ags.SetValidator(nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/contacts", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+
+	var got contactRequest
+	err := ags.Bind(req, &got)
+
+	var appErr *ags.AppError
+	assert.Assert(t, errors.As(err, &appErr), "got %v", err)
+	assert.Equal(t, ags.ErrCodeValidation, appErr.Code)
+	assert.Equal(t, "payload rejected", appErr.Message)
+	assert.Equal(t, 0, len(appErr.Details))
+}
+
+func TestBind_UseNumber(t *testing.T) {
+	// 2^53 + 1 has no exact float64 representation
+	const body = `{"id":9007199254740993}`
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		return req
+	}
+
+	var lossy map[string]interface{}
+	assert.NilError(t, ags.Bind(newRequest(), &lossy))
+	assert.Equal(t, lossy["id"], float64(9007199254740992))
+
+	var exact map[string]interface{}
+	assert.NilError(t, ags.Bind(newRequest(), &exact, ags.WithUseNumber()))
+	assert.Equal(t, exact["id"], json.Number("9007199254740993"))
+}
+
+func TestBind_OptionsReachCustomDecoders(t *testing.T) {
+	var got ags.BindOptions
+	ags.RegisterDecoder("text/x-options", func(r *http.Request, v interface{}, opts ags.BindOptions) error {
+		got = opts
+		return nil
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("ignored"))
+	req.Header.Set("Content-Type", "text/x-options")
+	assert.NilError(t, ags.Bind(req, &struct{}{}, ags.WithUseNumber()))
+	assert.Assert(t, got.UseNumber)
+}
+
+func TestBind_MultipartWithOptions(t *testing.T) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	assert.NilError(t, mw.WriteField("name", "Ada"))
+	assert.NilError(t, mw.Close())
+
+	req := httptest.NewRequest(http.MethodPost, "/", &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+
+	var got struct {
+		Name string `form:"name"`
+	}
+	assert.NilError(t, ags.Bind(req, &got, ags.WithUseNumber()))
+	assert.Equal(t, "Ada", got.Name)
+	// The form was parsed on req, where net/http cleans it up
+	assert.Assert(t, req.MultipartForm != nil)
+}
@@ -0,0 +1,253 @@
+package ags
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/getangry/ags/pkg/middleware"
+)
+
+// cachedValue is a value stored by CacheAside with its own expiry, so the TTL
+// is honored regardless of the backing cache's default
+type cachedValue struct {
+	value     interface{}
+	expiresAt time.Time
+}
+
+// CacheAside returns the value cached under key in the Cacher carried by ctx,
+// or calls loader, caches its result for ttl and returns it. Concurrent
+// misses for the same key share a single loader call when their contexts
+// come from the same WithCache call, or from the same Handler.
+// The loader runs with a context that keeps ctx's values but is not
+// cancelled with it, since its result is shared with the other waiters.
+// When ctx carries no Cacher the loader is always called. Loader errors are
+// not cached.
+func CacheAside(ctx context.Context, key string, ttl time.Duration, loader func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	cc, _ := ctx.Value(cacheContextKey).(contextCache)
+	c := cc.cache
+	if c == nil {
+		return loader(ctx)
+	}
+
+	if v, ok := c.Get(ctx, key); ok {
+		if entry, ok := v.(cachedValue); ok && time.Now().Before(entry.expiresAt) {
+			return entry.value, nil
+		}
+	}
+
+	v, err, _ := cc.group.Do(key, func() (interface{}, error) {
+		loadCtx := context.WithoutCancel(ctx)
+		value, err := loader(loadCtx)
+		if err != nil {
+			return nil, err
+		}
+		c.Set(loadCtx, key, cachedValue{value: value, expiresAt: time.Now().Add(ttl)})
+		return value, nil
+	})
+	return v, err
+}
+
+// cachedResponse is a full HTTP response stored by GetCached
+type cachedResponse struct {
+	status    int
+	header    http.Header
+	body      []byte
+	expiresAt time.Time
+}
+
+// cachedVary records which request headers a cached response varies on
+type cachedVary struct {
+	headers   []string
+	expiresAt time.Time
+}
+
+// GetCached registers a GET route whose successful responses are stored in
+// the configured cache for ttl and replayed for identical requests.
+// The cache key is derived from the path, the sorted query string and the
+// request headers named in the response's Vary header.
+// If no cache is configured the route is registered uncached.
+func (h *Handler) GetCached(pattern string, handler http.HandlerFunc, ttl time.Duration) {
+	if h.cfg.Cache == nil {
+		h.logger.Warn("no cache configured, registering route without caching", "pattern", pattern)
+		h.Get(pattern, handler)
+		return
+	}
+	h.route(pattern, h.cacheResponses(handler, ttl), funcName(handler), []string{MethodGet})
+}
+
+// cacheResponses wraps a handler with response caching
+func (h *Handler) cacheResponses(handler http.HandlerFunc, ttl time.Duration) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		baseKey := responseCacheKey(r)
+
+		if resp, ok := h.lookupCachedResponse(r, baseKey); ok {
+			for k, v := range resp.header {
+				w.Header()[k] = v
+			}
+			w.WriteHeader(resp.status)
+			w.Write(resp.body)
+			return
+		}
+
+		rec := &cacheRecorder{ResponseWriter: w, status: http.StatusOK, before: w.Header().Clone()}
+		handler(rec, r)
+
+		// responses setting cookies are specific to the client they were sent to
+		vary := parseVary(rec.Header().Get("Vary"))
+		if rec.status != http.StatusOK || containsString(vary, "*") || rec.setCookie {
+			return
+		}
+
+		expiresAt := time.Now().Add(ttl)
+		h.cfg.Cache.Set(ctx, varyCacheKey(baseKey), cachedVary{headers: vary, expiresAt: expiresAt})
+		h.cfg.Cache.Set(ctx, variantCacheKey(baseKey, vary, r), cachedResponse{
+			status:    rec.status,
+			header:    rec.header,
+			body:      rec.body.Bytes(),
+			expiresAt: expiresAt,
+		})
+	}
+}
+
+// lookupCachedResponse returns the unexpired cached response matching r, if any
+func (h *Handler) lookupCachedResponse(r *http.Request, baseKey string) (cachedResponse, bool) {
+	ctx := r.Context()
+	now := time.Now()
+
+	v, ok := h.cfg.Cache.Get(ctx, varyCacheKey(baseKey))
+	if !ok {
+		return cachedResponse{}, false
+	}
+	vary, ok := v.(cachedVary)
+	if !ok || now.After(vary.expiresAt) {
+		return cachedResponse{}, false
+	}
+
+	v, ok = h.cfg.Cache.Get(ctx, variantCacheKey(baseKey, vary.headers, r))
+	if !ok {
+		return cachedResponse{}, false
+	}
+	resp, ok := v.(cachedResponse)
+	if !ok || now.After(resp.expiresAt) {
+		return cachedResponse{}, false
+	}
+	return resp, true
+}
+
+// responseCacheKey builds the base cache key from the path and sorted query
+func responseCacheKey(r *http.Request) string {
+	// url.Values.Encode sorts by key
+	return "ags:response:" + r.URL.Path + "?" + r.URL.Query().Encode()
+}
+
+// varyCacheKey is the key of the Vary record, kept apart from the response
+// stored under baseKey when the response varies on nothing
+func varyCacheKey(baseKey string) string {
+	return baseKey + "|vary"
+}
+
+// variantCacheKey extends the base key with the values of the Vary headers
+func variantCacheKey(baseKey string, vary []string, r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(baseKey)
+	for _, name := range vary {
+		b.WriteString("|")
+		b.WriteString(name)
+		b.WriteString("=")
+		b.WriteString(strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// parseVary returns the canonical, sorted header names listed in a Vary header
+func parseVary(value string) []string {
+	var headers []string
+	for _, name := range strings.Split(value, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			headers = append(headers, http.CanonicalHeaderKey(name))
+		}
+	}
+	sort.Strings(headers)
+	return headers
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// uncachedHeaders are per-request response headers never replayed from cache
+var uncachedHeaders = []string{"Set-Cookie", middleware.RequestIDHeader}
+
+func isUncachedHeader(name string) bool {
+	for _, h := range uncachedHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheRecorder passes the response through while recording it for caching
+type cacheRecorder struct {
+	http.ResponseWriter
+	status      int
+	before      http.Header // headers set before the handler ran, e.g. by middleware
+	header      http.Header
+	body        bytes.Buffer
+	setCookie   bool
+	wroteHeader bool
+}
+
+// WriteHeader records the headers the handler itself set, leaving out those
+// already present before it ran and the per-request uncachedHeaders
+func (w *cacheRecorder) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = status
+		w.header = make(http.Header)
+		for k, v := range w.ResponseWriter.Header() {
+			if isUncachedHeader(k) {
+				continue
+			}
+			if prev, ok := w.before[k]; ok && reflect.DeepEqual(prev, v) {
+				continue
+			}
+			w.header[k] = append([]string(nil), v...)
+		}
+		w.setCookie = len(w.ResponseWriter.Header().Values("Set-Cookie")) > 0
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Committed reports whether the response has been written, consulting the
+// wrapped writer so RespondJSON's double-response guard still applies
+func (w *cacheRecorder) Committed() bool {
+	if c, ok := w.ResponseWriter.(interface{ Committed() bool }); ok && c.Committed() {
+		return true
+	}
+	return w.wroteHeader
+}
+
+// Unwrap returns the wrapped ResponseWriter
+func (w *cacheRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *cacheRecorder) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
@@ -0,0 +1,373 @@
+package ags_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"github.com/getangry/ags/pkg/cache"
+	"github.com/getangry/ags/pkg/middleware"
+	"gotest.tools/assert"
+)
+
+func TestGetCached(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:   &mockLogger{},
+		Cache: cache.NewInMemoryCache(time.Minute, time.Minute),
+	})
+
+	calls := 0
+	h.GetCached("/report", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Vary", "Accept-Language")
+		w.Header().Set("X-Generated", r.Header.Get("Accept-Language"))
+		ags.RespondJSON(w, http.StatusOK, "report "+r.URL.Query().Get("year"), nil)
+	}, 30*time.Second)
+
+	do := func(target, lang string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept-Language", lang)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := do("/report?year=2024&q=a", "en")
+	assert.Equal(t, 1, calls)
+
+	// Same path and query in a different order is a hit
+	second := do("/report?q=a&year=2024", "en")
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusOK, second.Code)
+	assert.Equal(t, first.Body.String(), second.Body.String())
+	assert.Equal(t, "en", second.Header().Get("X-Generated"))
+
+	// A different query or Vary header value is a miss
+	do("/report?year=2025&q=a", "en")
+	assert.Equal(t, 2, calls)
+
+	third := do("/report?year=2024&q=a", "de")
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, "de", third.Header().Get("X-Generated"))
+}
+
+func TestGetCached_NoVary(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:   &mockLogger{},
+		Cache: cache.NewInMemoryCache(time.Minute, time.Minute),
+	})
+
+	calls := 0
+	h.GetCached("/report", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		ags.RespondJSON(w, http.StatusOK, "report", nil)
+	}, 30*time.Second)
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/report", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestGetCached_PerRequestHeaders(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:   &mockLogger{},
+		Cache: cache.NewInMemoryCache(time.Minute, time.Minute),
+	})
+
+	reportCalls, sessionCalls := 0, 0
+	h.GetCached("/report", func(w http.ResponseWriter, r *http.Request) {
+		reportCalls++
+		w.Header().Set("X-Generated", "yes")
+		ags.RespondJSON(w, http.StatusOK, "report", nil)
+	}, 30*time.Second)
+	h.GetCached("/session", func(w http.ResponseWriter, r *http.Request) {
+		sessionCalls++
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+		ags.RespondJSON(w, http.StatusOK, "session", nil)
+	}, 30*time.Second)
+	srv := middleware.RequestID(h)
+
+	do := func(target, reqID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set(middleware.RequestIDHeader, reqID)
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+		return rec
+	}
+
+	do("/report", "first")
+	second := do("/report", "second")
+	assert.Equal(t, 1, reportCalls)
+	assert.Assert(t, strings.HasPrefix(second.Header().Get(middleware.RequestIDHeader), "second/"))
+	assert.Equal(t, "yes", second.Header().Get("X-Generated"))
+
+	// Responses setting cookies are never cached
+	do("/session", "first")
+	second = do("/session", "second")
+	assert.Equal(t, 2, sessionCalls)
+	assert.Equal(t, 1, len(second.Result().Cookies()))
+}
+
+func TestGetCached_NoCacheConfigured(t *testing.T) {
+	mockLog := &mockLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{Log: mockLog})
+
+	calls := 0
+	h.GetCached("/report", func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		ags.RespondJSON(w, http.StatusOK, "report", nil)
+	}, 30*time.Second)
+	assert.Equal(t, "no cache configured, registering route without caching", mockLog.lastWarn)
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/report", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestGetCached_KeepsResponseWriterFeatures(t *testing.T) {
+	mockLog := &mockLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:   mockLog,
+		Cache: cache.NewInMemoryCache(time.Minute, time.Minute),
+	})
+
+	var secondErr error
+	h.GetCached("/twice", func(w http.ResponseWriter, r *http.Request) {
+		ags.RespondJSON(w, http.StatusOK, "first", nil)
+		secondErr = ags.RespondJSON(w, http.StatusOK, "second", nil)
+	}, time.Minute)
+	h.GetCached("/fail", func(w http.ResponseWriter, r *http.Request) {
+		h.Error(w, ags.NewError(ags.ErrCodeNotFound, "missing"))
+	}, time.Minute)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/twice", nil))
+	assert.Equal(t, ags.ErrResponseCommitted, secondErr)
+	assert.Equal(t, "response already committed, dropping JSON response", mockLog.lastError)
+	assert.Equal(t, "{\"ok\":true,\"message\":\"first\"}\n", rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	middleware.RequestID(h).ServeHTTP(rec, httptest.NewRequest("GET", "/fail", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+	assert.Equal(t, "request error", mockLog.lastError)
+	reqID, _ := mockLog.field("request_id").(string)
+	assert.Assert(t, reqID != "", "fields: %v", mockLog.lastFields)
+	assert.Equal(t, "/fail", mockLog.field("route"))
+}
+
+func TestCacheAside(t *testing.T) {
+	ctx := ags.WithCache(context.Background(), cache.NewInMemoryCache(time.Minute, time.Minute))
+
+	calls := 0
+	loader := func(ctx context.Context) (interface{}, error) {
+		calls++
+		return "value", nil
+	}
+
+	// Miss calls the loader and stores the result
+	v, err := ags.CacheAside(ctx, "key", time.Minute, loader)
+	assert.NilError(t, err)
+	assert.Equal(t, "value", v)
+	assert.Equal(t, 1, calls)
+
+	// Hit is served from the cache
+	v, err = ags.CacheAside(ctx, "key", time.Minute, loader)
+	assert.NilError(t, err)
+	assert.Equal(t, "value", v)
+	assert.Equal(t, 1, calls)
+
+	// Loader errors are returned and not cached
+	_, err = ags.CacheAside(ctx, "failing", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("boom")
+	})
+	assert.ErrorContains(t, err, "boom")
+	_, found := ags.ContextCache(ctx).Get(ctx, "failing")
+	assert.Assert(t, !found)
+}
+
+func TestCacheAside_NoCache(t *testing.T) {
+	calls := 0
+	for i := 0; i < 3; i++ {
+		_, err := ags.CacheAside(context.Background(), "key", time.Minute, func(ctx context.Context) (interface{}, error) {
+			calls++
+			return "value", nil
+		})
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, 3, calls)
+}
+
+func TestCacheAside_StampedeProtection(t *testing.T) {
+	ctx := ags.WithCache(context.Background(), cache.NewInMemoryCache(time.Minute, time.Minute))
+
+	var calls int32
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+
+	const callers = 20
+	var started, done sync.WaitGroup
+	started.Add(callers)
+	done.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer done.Done()
+			started.Done()
+			v, err := ags.CacheAside(ctx, "hot", time.Minute, loader)
+			if err != nil || v != "value" {
+				t.Errorf("CacheAside() = %v, %v", v, err)
+			}
+		}()
+	}
+
+	started.Wait()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestCacheAside_FirstCallerCancelled(t *testing.T) {
+	otherCtx := ags.WithCache(context.Background(), cache.NewInMemoryCache(time.Minute, time.Minute))
+	firstCtx, cancel := context.WithCancel(otherCtx)
+
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		close(entered)
+		<-release
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return "value", nil
+	}
+
+	firstResult := make(chan error, 1)
+	go func() {
+		_, err := ags.CacheAside(firstCtx, "shared", time.Minute, loader)
+		firstResult <- err
+	}()
+	<-entered
+
+	otherResult := make(chan interface{}, 1)
+	go func() {
+		v, err := ags.CacheAside(otherCtx, "shared", time.Minute, loader)
+		if err != nil {
+			t.Errorf("CacheAside() error = %v", err)
+		}
+		otherResult <- v
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+	close(release)
+
+	assert.NilError(t, <-firstResult)
+	assert.Equal(t, "value", <-otherResult)
+
+	// The result is cached despite the first caller's cancellation
+	v, err := ags.CacheAside(otherCtx, "shared", time.Minute, func(ctx context.Context) (interface{}, error) {
+		return nil, errors.New("loader should not run on a hit")
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, "value", v)
+}
+
+func TestCacheAside_SeparateCachers(t *testing.T) {
+	first := cache.NewInMemoryCache(time.Minute, time.Minute)
+	second := cache.NewInMemoryCache(time.Minute, time.Minute)
+
+	release := make(chan struct{})
+	var calls int32
+	loader := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return "value", nil
+	}
+
+	var done sync.WaitGroup
+	for _, c := range []cache.Cacher{first, second} {
+		done.Add(1)
+		go func(c cache.Cacher) {
+			defer done.Done()
+			ags.CacheAside(ags.WithCache(context.Background(), c), "key", time.Minute, loader)
+		}(c)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	// Each cache ran its own load and holds its own entry
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	for _, c := range []cache.Cacher{first, second} {
+		_, found := c.Get(context.Background(), "key")
+		assert.Assert(t, found)
+	}
+}
+
+// sliceCache is a Cacher whose dynamic type is not comparable
+type sliceCache []*cache.InMemoryCache
+
+func (c sliceCache) Set(ctx context.Context, key string, value interface{}) {
+	c[0].Set(ctx, key, value)
+}
+func (c sliceCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	return c[0].Get(ctx, key)
+}
+func (c sliceCache) Delete(ctx context.Context, key string) { c[0].Delete(ctx, key) }
+
+func TestCacheAside_NonComparableCacher(t *testing.T) {
+	ctx := ags.WithCache(context.Background(), sliceCache{cache.NewInMemoryCache(time.Minute, time.Minute)})
+
+	calls := 0
+	for i := 0; i < 2; i++ {
+		v, err := ags.CacheAside(ctx, "key", time.Minute, func(ctx context.Context) (interface{}, error) {
+			calls++
+			return "value", nil
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, "value", v)
+	}
+	assert.Equal(t, 1, calls)
+}
+
+func TestCacheAside_FromHandler(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:   &mockLogger{},
+		Cache: cache.NewInMemoryCache(time.Minute, time.Minute),
+	})
+
+	calls := 0
+	h.Get("/user", func(w http.ResponseWriter, r *http.Request) {
+		v, _ := ags.CacheAside(r.Context(), "user:1", time.Minute, func(ctx context.Context) (interface{}, error) {
+			calls++
+			return "alice", nil
+		})
+		ags.RespondJSON(w, http.StatusOK, v.(string), nil)
+	})
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/user", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.Equal(t, 1, calls)
+}
@@ -0,0 +1,116 @@
+package ags
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/getangry/ags/internal/singleflight"
+	"github.com/getangry/ags/pkg/cache"
+)
+
+// contextKey is the type of context keys defined by this package. Using an
+// unexported type prevents collisions with keys from other packages.
+type contextKey struct {
+	name string
+}
+
+func (k *contextKey) String() string {
+	return "ags context key " + k.name
+}
+
+var (
+	userContextKey  = &contextKey{"user"}
+	cacheContextKey = &contextKey{"cache"}
+	dbContextKey    = &contextKey{"db"}
+	startContextKey = &contextKey{"request-start"}
+)
+
+// WithUser returns a copy of ctx carrying the authenticated user
+func WithUser(ctx context.Context, user interface{}) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// ContextUser returns the user stored in ctx by WithUser.
+// The boolean is false when no user has been stored.
+func ContextUser(ctx context.Context) (interface{}, bool) {
+	user := ctx.Value(userContextKey)
+	return user, user != nil
+}
+
+// contextCache is the value stored under cacheContextKey. The group
+// deduplicates CacheAside loads among contexts derived from the same
+// WithCache call, and is owned by whoever made that call.
+type contextCache struct {
+	cache cache.Cacher
+	group *singleflight.Group
+}
+
+// WithCache returns a copy of ctx carrying c. Request contexts created by the
+// handler, and the contexts of gRPC calls it serves, already carry the
+// configured ServerConfig.Cache.
+// CacheAside shares loads only among contexts derived from the returned one.
+func WithCache(ctx context.Context, c cache.Cacher) context.Context {
+	return withCacheGroup(ctx, c, new(singleflight.Group))
+}
+
+func withCacheGroup(ctx context.Context, c cache.Cacher, g *singleflight.Group) context.Context {
+	return context.WithValue(ctx, cacheContextKey, contextCache{cache: c, group: g})
+}
+
+// ContextCache returns the Cacher stored in ctx, or nil if there is none
+func ContextCache(ctx context.Context) cache.Cacher {
+	c, _ := ctx.Value(cacheContextKey).(contextCache)
+	return c.cache
+}
+
+// WithDB returns a copy of ctx carrying db. Request contexts created by the
+// handler, and the contexts of gRPC calls it serves, already carry the
+// configured ServerConfig.DB.
+func WithDB(ctx context.Context, db *sql.DB) context.Context {
+	return context.WithValue(ctx, dbContextKey, db)
+}
+
+// ContextDB returns the database stored in ctx, or nil if there is none
+func ContextDB(ctx context.Context) *sql.DB {
+	db, _ := ctx.Value(dbContextKey).(*sql.DB)
+	return db
+}
+
+// withDependencies returns a copy of ctx carrying the configured DB and
+// Cache
+func (h *Handler) withDependencies(ctx context.Context) context.Context {
+	if h.cfg.DB != nil {
+		ctx = WithDB(ctx, h.cfg.DB)
+	}
+	if h.cfg.Cache != nil {
+		ctx = withCacheGroup(ctx, h.cfg.Cache, &h.cacheAside)
+	}
+	return ctx
+}
+
+// RequestStart returns the time the handler started processing the request,
+// the same instant the duration passed to PostRequestFuncs is measured from.
+// It is the zero time outside route handlers.
+func RequestStart(ctx context.Context) time.Time {
+	start, _ := ctx.Value(startContextKey).(time.Time)
+	return start
+}
+
+// ResponseSize returns the number of body bytes written so far to the
+// response behind w, which may be wrapped by middleware implementing
+// Unwrap. It returns 0 for writers not created by the handler.
+func ResponseSize(w http.ResponseWriter) int64 {
+	for w != nil {
+		if rw, ok := w.(interface{ Size() int64 }); ok {
+			return rw.Size()
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return 0
+		}
+		w = u.Unwrap()
+	}
+	return 0
+}
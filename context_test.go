@@ -0,0 +1,108 @@
+package ags_test
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestContextUser(t *testing.T) {
+	type account struct {
+		Name string
+	}
+
+	ctx := ags.WithUser(context.Background(), account{Name: "alice"})
+
+	user, ok := ags.ContextUser(ctx)
+	assert.Assert(t, ok)
+	assert.Equal(t, account{Name: "alice"}, user.(account))
+
+	// A foreign key with the same name does not collide
+	type foreignKey string
+	ctx = context.WithValue(context.Background(), foreignKey("user"), "mallory")
+	_, ok = ags.ContextUser(ctx)
+	assert.Assert(t, !ok)
+}
+
+func TestContextUser_ThroughMiddleware(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+
+	api := h.Group("/api", func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r.WithContext(ags.WithUser(r.Context(), "alice")))
+		})
+	})
+
+	var got interface{}
+	api.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		got, _ = ags.ContextUser(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/me", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "alice", got)
+}
+
+func TestContextDB(t *testing.T) {
+	assert.Assert(t, ags.ContextDB(context.Background()) == nil)
+
+	db := &sql.DB{}
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}, DB: db})
+
+	var got *sql.DB
+	h.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+		got = ags.ContextDB(r.Context())
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/orders", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Assert(t, got == db, "request context does not carry ServerConfig.DB")
+}
+
+func TestRequestStartAndResponseSize(t *testing.T) {
+	var (
+		postSize  int64
+		postStart time.Time
+		postDur   time.Duration
+	)
+	before := time.Now()
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log: &mockLogger{},
+		PostPhase: []ags.PostRequestFunc{
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request, duration time.Duration) {
+				postSize, postStart, postDur = ags.ResponseSize(w), ags.RequestStart(ctx), duration
+			},
+		},
+	})
+
+	var handlerSize int64
+	var handlerStart time.Time
+	h.Get("/hello", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+		handlerSize, handlerStart = ags.ResponseSize(w), ags.RequestStart(r.Context())
+	})
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/hello", nil))
+	after := time.Now()
+
+	assert.Equal(t, int64(5), handlerSize)
+	assert.Equal(t, int64(5), postSize)
+	assert.Assert(t, !handlerStart.Before(before) && !handlerStart.After(after), handlerStart)
+	assert.Equal(t, handlerStart, postStart)
+	assert.Assert(t, !postStart.Add(postDur).After(after))
+
+	assert.Assert(t, ags.RequestStart(context.Background()).IsZero())
+	assert.Equal(t, int64(0), ags.ResponseSize(httptest.NewRecorder()))
+}
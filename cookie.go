@@ -0,0 +1,112 @@
+package ags
+
+import (
+	"net/http"
+	"time"
+)
+
+// CookieConfig holds the defaults SetCookie applies to the cookies handlers
+// set. The zero value gives HttpOnly cookies with SameSite=Lax for path "/",
+// marked Secure when the request arrived over TLS.
+type CookieConfig struct {
+	// Path defaults to "/"
+	Path string
+	// Domain is left unset by default, limiting cookies to the request host
+	Domain string
+	// SameSite defaults to http.SameSiteLaxMode
+	SameSite http.SameSite
+	// AllowScripts leaves HttpOnly unset so JavaScript can read cookies
+	AllowScripts bool
+	// AlwaysSecure marks cookies Secure even for plain HTTP requests, e.g.
+	// when TLS is terminated by a proxy in front of the server
+	AlwaysSecure bool
+}
+
+// CookieOption overrides a default of SetCookie for one cookie
+type CookieOption func(*http.Cookie)
+
+// WithCookieMaxAge makes the cookie expire after d. A negative d deletes it.
+// Without it, the cookie lasts for the browser session.
+func WithCookieMaxAge(d time.Duration) CookieOption {
+	return func(c *http.Cookie) {
+		if d < 0 {
+			c.MaxAge = -1
+			return
+		}
+		c.MaxAge = int(d / time.Second)
+	}
+}
+
+// WithCookiePath sets the path of the cookie
+func WithCookiePath(path string) CookieOption {
+	return func(c *http.Cookie) {
+		c.Path = path
+	}
+}
+
+// WithCookieDomain sets the domain of the cookie
+func WithCookieDomain(domain string) CookieOption {
+	return func(c *http.Cookie) {
+		c.Domain = domain
+	}
+}
+
+// WithCookieSameSite sets the SameSite attribute of the cookie
+func WithCookieSameSite(mode http.SameSite) CookieOption {
+	return func(c *http.Cookie) {
+		c.SameSite = mode
+	}
+}
+
+// WithCookieHTTPOnly sets whether the cookie is hidden from JavaScript
+func WithCookieHTTPOnly(httpOnly bool) CookieOption {
+	return func(c *http.Cookie) {
+		c.HttpOnly = httpOnly
+	}
+}
+
+// WithCookieSecure sets whether the cookie is only sent over HTTPS
+func WithCookieSecure(secure bool) CookieOption {
+	return func(c *http.Cookie) {
+		c.Secure = secure
+	}
+}
+
+// SetCookie adds a Set-Cookie header to the response with the defaults of
+// ServerConfig.Cookies, then applies opts:
+//
+//	ags.SetCookie(w, "session", token, ags.WithCookieMaxAge(24*time.Hour))
+//
+// Secure is set when the request arrived over TLS. Writers not passed to a
+// route registered on a Handler get the zero CookieConfig defaults and no
+// Secure flag, as the request is unknown.
+func SetCookie(w http.ResponseWriter, name, value string, opts ...CookieOption) {
+	var cfg CookieConfig
+	secure := false
+	if dw := debugWriterOf(w); dw != nil {
+		if dw.handler.cfg.Cookies != nil {
+			cfg = *dw.handler.cfg.Cookies
+		}
+		secure = dw.request.TLS != nil
+	}
+
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     cfg.Path,
+		Domain:   cfg.Domain,
+		SameSite: cfg.SameSite,
+		HttpOnly: !cfg.AllowScripts,
+		Secure:   secure || cfg.AlwaysSecure,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	for _, opt := range opts {
+		opt(cookie)
+	}
+	http.SetCookie(w, cookie)
+}
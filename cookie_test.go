@@ -0,0 +1,101 @@
+package ags_test
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestSetCookie(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *ags.CookieConfig
+		tls  bool
+		opts []ags.CookieOption
+		want http.Cookie
+	}{
+		{
+			name: "defaults",
+			want: http.Cookie{Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode},
+		},
+		{
+			name: "secure over TLS",
+			tls:  true,
+			want: http.Cookie{Path: "/", HttpOnly: true, Secure: true, SameSite: http.SameSiteLaxMode},
+		},
+		{
+			name: "config defaults",
+			cfg: &ags.CookieConfig{
+				Path:         "/app",
+				Domain:       "example.com",
+				SameSite:     http.SameSiteStrictMode,
+				AllowScripts: true,
+				AlwaysSecure: true,
+			},
+			want: http.Cookie{Path: "/app", Domain: "example.com", Secure: true, SameSite: http.SameSiteStrictMode},
+		},
+		{
+			name: "options override defaults",
+			tls:  true,
+			opts: []ags.CookieOption{
+				ags.WithCookieMaxAge(time.Hour),
+				ags.WithCookiePath("/api"),
+				ags.WithCookieDomain("api.example.com"),
+				ags.WithCookieSameSite(http.SameSiteNoneMode),
+				ags.WithCookieHTTPOnly(false),
+				ags.WithCookieSecure(false),
+			},
+			want: http.Cookie{Path: "/api", Domain: "api.example.com", MaxAge: 3600, SameSite: http.SameSiteNoneMode},
+		},
+		{
+			name: "negative max age deletes",
+			opts: []ags.CookieOption{ags.WithCookieMaxAge(-1)},
+			want: http.Cookie{Path: "/", HttpOnly: true, SameSite: http.SameSiteLaxMode, MaxAge: -1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}, Cookies: tt.cfg})
+			h.Get("/login", func(w http.ResponseWriter, r *http.Request) {
+				ags.SetCookie(w, "session", "abc", tt.opts...)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/login", nil)
+			if tt.tls {
+				req.TLS = &tls.ConnectionState{}
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			cookies := rec.Result().Cookies()
+			assert.Equal(t, len(cookies), 1)
+			got := cookies[0]
+			assert.Equal(t, got.Name, "session")
+			assert.Equal(t, got.Value, "abc")
+			assert.Equal(t, got.Path, tt.want.Path)
+			assert.Equal(t, got.Domain, tt.want.Domain)
+			assert.Equal(t, got.MaxAge, tt.want.MaxAge)
+			assert.Equal(t, got.HttpOnly, tt.want.HttpOnly)
+			assert.Equal(t, got.Secure, tt.want.Secure)
+			assert.Equal(t, got.SameSite, tt.want.SameSite)
+		})
+	}
+}
+
+func TestSetCookie_OutsideHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	ags.SetCookie(rec, "theme", "dark")
+
+	cookies := rec.Result().Cookies()
+	assert.Equal(t, len(cookies), 1)
+	assert.Equal(t, cookies[0].Path, "/")
+	assert.Assert(t, cookies[0].HttpOnly)
+	assert.Assert(t, !cookies[0].Secure)
+	assert.Equal(t, cookies[0].SameSite, http.SameSiteLaxMode)
+}
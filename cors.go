@@ -0,0 +1,178 @@
+package ags
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultCORSMaxAge is how long browsers may cache a preflight response when
+// CORSConfig.MaxAge is zero
+const DefaultCORSMaxAge = 10 * time.Minute
+
+// CORSConfig holds the configuration for the CORS middleware
+type CORSConfig struct {
+	// AllowedOrigins lists the origins allowed to make cross-origin requests.
+	// "*" allows any origin. An empty list allows any origin.
+	AllowedOrigins []string
+	// AllowedHeaders lists the request headers allowed in preflighted requests.
+	// When empty, the headers requested by the browser are echoed back.
+	AllowedHeaders []string
+	// ExposedHeaders lists response headers readable by the browser
+	ExposedHeaders []string
+	// AllowCredentials sets Access-Control-Allow-Credentials. It requires an
+	// explicit AllowedOrigins list without "*", since credentialed requests
+	// from any origin would let every site read authenticated responses.
+	AllowCredentials bool
+	// MaxAge is how long a preflight response may be cached. Zero uses
+	// DefaultCORSMaxAge and a negative value omits Access-Control-Max-Age.
+	MaxAge time.Duration
+}
+
+// CORS returns a middleware that handles cross-origin requests. Preflight
+// requests are answered using the methods actually registered for the
+// requested path, so methods a route does not implement are never advertised.
+// Preflights for unknown paths are passed through to the router.
+// CORS panics if cfg allows credentials for any origin.
+func (h *Handler) CORS(cfg CORSConfig) Middleware {
+	if cfg.AllowCredentials && (len(cfg.AllowedOrigins) == 0 || containsString(cfg.AllowedOrigins, "*")) {
+		panic("ags: CORS AllowCredentials requires an explicit AllowedOrigins list")
+	}
+	maxAge := cfg.MaxAge
+	if maxAge == 0 {
+		maxAge = DefaultCORSMaxAge
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Origin")
+			if !cfg.originAllowed(origin) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			requestMethod := r.Header.Get("Access-Control-Request-Method")
+			if r.Method != MethodOptions || requestMethod == "" {
+				cfg.setOriginHeaders(w, origin)
+				if len(cfg.ExposedHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(cfg.ExposedHeaders, ", "))
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			methods, ok := h.allowedMethods(r)
+			if !ok {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			cfg.setOriginHeaders(w, origin)
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+
+			if len(cfg.AllowedHeaders) > 0 {
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(cfg.AllowedHeaders, ", "))
+			} else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+				w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+			}
+
+			if maxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(maxAge/time.Second)))
+			}
+
+			// Browsers compare the requested method against the allowed list,
+			// so an unsupported method fails the preflight on the client side
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// AutoOptions returns a middleware answering OPTIONS requests for paths
+// that have routes but no OPTIONS route of their own: it responds with 204
+// No Content and an Allow header listing the registered methods. Other
+// requests pass through.
+func (h *Handler) AutoOptions() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			methods, ok := h.allowedMethods(r)
+			if !ok || containsString(methods, MethodOptions) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("Allow", strings.Join(append(methods, MethodOptions), ", "))
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// EnableCORS installs the CORS middleware with cfg followed by AutoOptions
+// as global middleware, so every route answers preflight and plain OPTIONS
+// requests without registering OPTIONS routes. Call it before Use to have
+// CORS run ahead of other global middleware such as authentication.
+func (h *Handler) EnableCORS(cfg CORSConfig) {
+	h.Use(h.CORS(cfg), h.AutoOptions())
+}
+
+// AllowedMethods returns the methods registered for the given path and
+// whether any route matches it. For parameterized routes the methods of every
+// route matching the path are combined.
+func (h *Handler) AllowedMethods(path string) ([]string, bool) {
+	// No route allows the empty method, so lookupRoute reports them all
+	_, _, allowed, _ := h.lookupRoute("", "", path)
+	if len(allowed) == 0 {
+		return nil, false
+	}
+	return append([]string(nil), allowed...), true
+}
+
+// allowedMethods is like AllowedMethods for the path of r, including the
+// routes bound to its host
+func (h *Handler) allowedMethods(r *http.Request) ([]string, bool) {
+	_, _, allowed, _ := h.resolveRoute("", r)
+	if len(allowed) == 0 {
+		return nil, false
+	}
+	return append([]string(nil), allowed...), true
+}
+
+// originAllowed reports whether origin may make cross-origin requests
+func (c CORSConfig) originAllowed(origin string) bool {
+	if len(c.AllowedOrigins) == 0 {
+		return true
+	}
+	for _, o := range c.AllowedOrigins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// setOriginHeaders sets the origin and credentials headers for an allowed origin
+func (c CORSConfig) setOriginHeaders(w http.ResponseWriter, origin string) {
+	if c.AllowCredentials {
+		// A wildcard is not valid together with credentials; CORS ensures
+		// origin is one of the listed ones
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		return
+	}
+	if len(c.AllowedOrigins) == 0 || containsString(c.AllowedOrigins, "*") {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		return
+	}
+	w.Header().Set("Access-Control-Allow-Origin", origin)
+}
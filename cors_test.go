@@ -0,0 +1,237 @@
+package ags_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestCORS_Preflight(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Route("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, ags.MethodGet, ags.MethodPost)
+	h.Use(h.CORS(ags.CORSConfig{AllowedOrigins: []string{"https://app.example"}}))
+
+	tests := []struct {
+		name        string
+		path        string
+		origin      string
+		method      string
+		wantStatus  int
+		wantMethods string
+		wantOrigin  string
+		wantMaxAge  string
+	}{
+		{
+			name:        "supported method",
+			path:        "/items",
+			origin:      "https://app.example",
+			method:      ags.MethodPost,
+			wantStatus:  http.StatusNoContent,
+			wantMethods: "GET, POST",
+			wantOrigin:  "https://app.example",
+			wantMaxAge:  "600",
+		},
+		{
+			name:        "unsupported method is not advertised",
+			path:        "/items",
+			origin:      "https://app.example",
+			method:      ags.MethodDelete,
+			wantStatus:  http.StatusNoContent,
+			wantMethods: "GET, POST",
+			wantOrigin:  "https://app.example",
+			wantMaxAge:  "600",
+		},
+		{
+			name:       "disallowed origin",
+			path:       "/items",
+			origin:     "https://evil.example",
+			method:     ags.MethodGet,
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "unknown path",
+			path:       "/missing",
+			origin:     "https://app.example",
+			method:     ags.MethodGet,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(ags.MethodOptions, tt.path, nil)
+			req.Header.Set("Origin", tt.origin)
+			req.Header.Set("Access-Control-Request-Method", tt.method)
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantMethods, rec.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, tt.wantOrigin, rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tt.wantMaxAge, rec.Header().Get("Access-Control-Max-Age"))
+		})
+	}
+}
+
+func TestCORS_MaxAge(t *testing.T) {
+	tests := []struct {
+		maxAge time.Duration
+		want   string
+	}{
+		{maxAge: 0, want: "600"},
+		{maxAge: time.Hour, want: "3600"},
+		{maxAge: -1, want: ""},
+	}
+
+	for _, tt := range tests {
+		h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+		h.Delete("/items", func(w http.ResponseWriter, r *http.Request) {})
+		h.Use(h.CORS(ags.CORSConfig{MaxAge: tt.maxAge}))
+
+		req := httptest.NewRequest(ags.MethodOptions, "/items", nil)
+		req.Header.Set("Origin", "https://app.example")
+		req.Header.Set("Access-Control-Request-Method", ags.MethodDelete)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		assert.Equal(t, http.StatusNoContent, rec.Code)
+		assert.Equal(t, "DELETE", rec.Header().Get("Access-Control-Allow-Methods"))
+		assert.Equal(t, "*", rec.Header().Get("Access-Control-Allow-Origin"))
+		assert.Equal(t, tt.want, rec.Header().Get("Access-Control-Max-Age"))
+	}
+}
+
+func TestCORS_SimpleRequest(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	h.Use(h.CORS(ags.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example"},
+		AllowCredentials: true,
+	}))
+
+	req := httptest.NewRequest(ags.MethodGet, "/items", nil)
+	req.Header.Set("Origin", "https://app.example")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "https://app.example", rec.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "true", rec.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "", rec.Header().Get("Access-Control-Allow-Methods"))
+}
+
+func TestCORS_CredentialsRequireExplicitOrigins(t *testing.T) {
+	origins := [][]string{
+		nil,
+		{"*"},
+		{"https://app.example", "*"},
+	}
+
+	for _, allowed := range origins {
+		func() {
+			defer func() {
+				assert.Assert(t, recover() != nil, "expected a panic for origins %v", allowed)
+			}()
+			h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+			h.EnableCORS(ags.CORSConfig{AllowedOrigins: allowed, AllowCredentials: true})
+		}()
+	}
+}
+
+func TestHandler_EnableCORS(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.EnableCORS(ags.CORSConfig{
+		AllowedOrigins: []string{"https://app.example"},
+		ExposedHeaders: []string{"X-ReqId"},
+	})
+	h.Route("/items", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}, ags.MethodGet, ags.MethodPost)
+	h.Get("/items/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name        string
+		method      string
+		path        string
+		preflight   string
+		wantStatus  int
+		wantOrigin  string
+		wantMethods string
+		wantExposed string
+		wantAllow   string
+	}{
+		{
+			name:        "preflight",
+			method:      ags.MethodOptions,
+			path:        "/items",
+			preflight:   ags.MethodPost,
+			wantStatus:  http.StatusNoContent,
+			wantOrigin:  "https://app.example",
+			wantMethods: "GET, POST",
+		},
+		{
+			name:        "preflight for a parameterized route",
+			method:      ags.MethodOptions,
+			path:        "/items/7",
+			preflight:   ags.MethodGet,
+			wantStatus:  http.StatusNoContent,
+			wantOrigin:  "https://app.example",
+			wantMethods: "GET",
+		},
+		{
+			name:        "actual request",
+			method:      ags.MethodPost,
+			path:        "/items",
+			wantStatus:  http.StatusOK,
+			wantOrigin:  "https://app.example",
+			wantExposed: "X-ReqId",
+		},
+		{
+			name:        "plain OPTIONS",
+			method:      ags.MethodOptions,
+			path:        "/items",
+			wantStatus:  http.StatusNoContent,
+			wantOrigin:  "https://app.example",
+			wantExposed: "X-ReqId",
+			wantAllow:   "GET, POST, OPTIONS",
+		},
+		{
+			name:        "unknown path",
+			method:      ags.MethodOptions,
+			path:        "/missing",
+			wantStatus:  http.StatusNotFound,
+			wantOrigin:  "https://app.example",
+			wantExposed: "X-ReqId",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Origin", "https://app.example")
+			if tt.preflight != "" {
+				req.Header.Set("Access-Control-Request-Method", tt.preflight)
+			}
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantOrigin, rec.Header().Get("Access-Control-Allow-Origin"))
+			assert.Equal(t, tt.wantMethods, rec.Header().Get("Access-Control-Allow-Methods"))
+			assert.Equal(t, tt.wantExposed, rec.Header().Get("Access-Control-Expose-Headers"))
+			assert.Equal(t, tt.wantAllow, rec.Header().Get("Allow"))
+		})
+	}
+}
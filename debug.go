@@ -2,52 +2,132 @@ package ags
 
 import (
 	"bytes"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httputil"
+	"sync"
 )
 
+// DefaultDebugBodyLimit is the number of response body bytes captured for a
+// debug dump when ServerConfig.DebugBodyLimit is zero
+const DefaultDebugBodyLimit = 64 << 10
+
+// debugBufferPool recycles the buffers capturing response bodies for debug
+// dumps, each holding at most limit bytes
+type debugBufferPool struct {
+	limit int
+	pool  sync.Pool
+}
+
+func newDebugBufferPool(limit int) *debugBufferPool {
+	if limit <= 0 {
+		limit = DefaultDebugBodyLimit
+	}
+	return &debugBufferPool{
+		limit: limit,
+		pool: sync.Pool{New: func() interface{} {
+			return new(bytes.Buffer)
+		}},
+	}
+}
+
+func (p *debugBufferPool) get() *bytes.Buffer {
+	return p.pool.Get().(*bytes.Buffer)
+}
+
+// put returns buf to the pool unless it grew well past the limit
+func (p *debugBufferPool) put(buf *bytes.Buffer) {
+	if buf.Cap() > 2*p.limit {
+		return
+	}
+	buf.Reset()
+	p.pool.Put(buf)
+}
+
 // debugResponseWriter wraps ResponseWriter to capture response for debug logging
 type debugResponseWriter struct {
 	*ResponseWriter
-	handler *Handler
-	request *http.Request
-	buf     []byte
+	handler       *Handler
+	request       *http.Request
+	buf           *bytes.Buffer // taken from the handler's pool on the first write
+	truncated     int64         // body bytes past the capture limit
+	errorRenderer ErrorRenderer // set by route groups with their own renderer
+}
+
+// debugWriterOf returns the debugResponseWriter created by wrapHandler for w.
+// Writers wrapping it are unwrapped following the http.ResponseController
+// convention. It returns nil when w was not created by wrapHandler.
+func debugWriterOf(w http.ResponseWriter) *debugResponseWriter {
+	for w != nil {
+		if dw, ok := w.(*debugResponseWriter); ok {
+			return dw
+		}
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			return nil
+		}
+		w = u.Unwrap()
+	}
+	return nil
 }
 
-// Write captures the response data for debug logging
+// Write captures the response data for debug logging, up to the handler's
+// debug body limit
 func (w *debugResponseWriter) Write(b []byte) (int, error) {
 	if w.handler.isDebugEnabled() {
-		w.buf = append(w.buf, b...)
+		if w.buf == nil {
+			w.buf = w.handler.debugBufs.get()
+		}
+		capture := b
+		if room := w.handler.debugBufs.limit - w.buf.Len(); len(capture) > room {
+			capture = capture[:room]
+		}
+		w.buf.Write(capture)
+		w.truncated += int64(len(b) - len(capture))
 	}
 	return w.ResponseWriter.Write(b)
 }
 
-// WriteHeader captures status and generates response dump for debug logging
-func (w *debugResponseWriter) WriteHeader(status int) {
-	w.ResponseWriter.WriteHeader(status)
+// dumpResponse logs the captured response for debug logging. wrapHandler
+// calls it once the request is done, so the dump holds the whole body no
+// matter whether the pre phase, group middleware or the handler wrote it.
+func (w *debugResponseWriter) dumpResponse() {
+	if w.buf != nil {
+		defer func() {
+			w.handler.debugBufs.put(w.buf)
+			w.buf = nil
+		}()
+	}
+	if !w.handler.isDebugEnabled() {
+		return
+	}
+
+	var body []byte
+	if w.buf != nil {
+		body = w.buf.Bytes()
+	}
+	if w.truncated > 0 {
+		body = append(body[:len(body):len(body)], fmt.Sprintf("\n[... %d bytes truncated]", w.truncated)...)
+	}
 
-	if w.handler.isDebugEnabled() {
-		// Create a response for dumping
-		resp := &http.Response{
-			Status:     http.StatusText(status),
-			StatusCode: status,
-			Proto:      w.request.Proto,
-			ProtoMajor: w.request.ProtoMajor,
-			ProtoMinor: w.request.ProtoMinor,
-			Header:     w.Header(),
-			Body:       io.NopCloser(bytes.NewBuffer(w.buf)),
-			Request:    w.request,
-		}
+	resp := &http.Response{
+		Status:     http.StatusText(w.status),
+		StatusCode: w.status,
+		Proto:      w.request.Proto,
+		ProtoMajor: w.request.ProtoMajor,
+		ProtoMinor: w.request.ProtoMinor,
+		Header:     w.Header(),
+		Body:       io.NopCloser(bytes.NewReader(body)),
+		Request:    w.request,
+	}
 
-		// Dump response
-		respDump, err := httputil.DumpResponse(resp, true)
-		if err != nil {
-			w.handler.Log(w.request.Context()).Error("failed to dump response", "error", err)
-		} else {
-			w.handler.Log(w.request.Context()).Debug("response dump", "dump", string(respDump))
-		}
+	respDump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		w.handler.Log(w.request.Context()).Error("failed to dump response", "error", err)
+		return
 	}
+	w.handler.Log(w.request.Context()).Debug("response dump", "dump", string(respDump))
 }
 
 // authenticateDebug middleware ensures valid authentication for debug endpoints
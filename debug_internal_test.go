@@ -0,0 +1,63 @@
+package ags
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugBufferPool_Reuse(t *testing.T) {
+	h := NewHandler(&ServerConfig{Log: NopLogger{}, DebugBodyLimit: 32})
+	h.debug.enableDebug = true
+
+	// Count the buffers the pool has to allocate
+	created := 0
+	newBuffer := h.debugBufs.pool.New
+	h.debugBufs.pool.New = func() interface{} {
+		created++
+		return newBuffer()
+	}
+
+	const requests = 20
+	for i := 0; i < requests; i++ {
+		rw := &debugResponseWriter{
+			ResponseWriter: &ResponseWriter{ResponseWriter: httptest.NewRecorder(), status: http.StatusOK},
+			handler:        h,
+			request:        httptest.NewRequest(http.MethodGet, "/", nil),
+		}
+		if _, err := rw.Write(bytes.Repeat([]byte("x"), 100)); err != nil {
+			t.Fatal(err)
+		}
+		if got := rw.buf.Len(); got != 32 {
+			t.Errorf("captured %d bytes, want the 32 byte limit", got)
+		}
+		rw.dumpResponse()
+		if rw.buf != nil {
+			t.Error("buffer not released after the dump")
+		}
+	}
+
+	if created == 0 || created >= requests {
+		t.Errorf("pool allocated %d buffers for %d sequential requests, want them reused", created, requests)
+	}
+}
+
+func TestDebugBufferPool_DropsOversized(t *testing.T) {
+	p := newDebugBufferPool(8)
+	created := 0
+	p.pool.New = func() interface{} {
+		created++
+		return new(bytes.Buffer)
+	}
+
+	buf := p.get()
+	buf.Grow(1024)
+	p.put(buf)
+	if got := p.get(); got == buf {
+		t.Error("buffer grown past twice the limit was pooled")
+	}
+	if created != 2 {
+		t.Errorf("pool allocated %d buffers, want 2", created)
+	}
+}
@@ -0,0 +1,128 @@
+package ags_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+// debugLogger records the debug entries the mock logger discards
+type debugLogger struct {
+	mockLogger
+	mu    sync.Mutex
+	dumps []string
+}
+
+func (l *debugLogger) Debug(msg string, fields ...interface{}) {
+	if msg != "response dump" {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.dumps = append(l.dumps, fields[1].(string))
+}
+
+func (l *debugLogger) WithContext(ctx context.Context) ags.Logger {
+	return l
+}
+
+func (l *debugLogger) lastDump() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.dumps) == 0 {
+		return ""
+	}
+	return l.dumps[len(l.dumps)-1]
+}
+
+func TestDebugResponseDump(t *testing.T) {
+	t.Setenv("DEBUG_AUTH_KEY", "debug-key")
+
+	logger := &debugLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log: logger,
+		PrePhase: []ags.PreRequestFunc{
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
+				if r.URL.Path == "/maintenance" {
+					_ = ags.RespondJSON(w, http.StatusServiceUnavailable, "down for maintenance", nil)
+				}
+				return ctx, nil
+			},
+		},
+	})
+	h.Get("/maintenance", func(w http.ResponseWriter, r *http.Request) {})
+	h.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("implicit 200 body"))
+	})
+
+	toggle := httptest.NewRequest(http.MethodPost, "/_/debug/toggle", strings.NewReader(`{"enable":true}`))
+	toggle.Header.Set("X-Debug-Key", "debug-key")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, toggle)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantDump []string
+	}{
+		{
+			name:     "written by pre phase",
+			path:     "/maintenance",
+			wantDump: []string{"503 Service Unavailable", `"message":"down for maintenance"`},
+		},
+		{
+			name:     "written without WriteHeader",
+			path:     "/orders",
+			wantDump: []string{"200 OK", "implicit 200 body"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			dump := logger.lastDump()
+			for _, want := range tt.wantDump {
+				assert.Assert(t, strings.Contains(dump, want), "dump %q does not contain %q", dump, want)
+			}
+		})
+	}
+}
+
+func TestDebugResponseDump_BodyLimit(t *testing.T) {
+	t.Setenv("DEBUG_AUTH_KEY", "debug-key")
+
+	logger := &debugLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{Log: logger, DebugBodyLimit: 16})
+	h.Get("/export", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(strings.Repeat("a", 10)))
+		_, _ = w.Write([]byte(strings.Repeat("b", 10) + strings.Repeat("c", 80)))
+	})
+	h.Get("/small", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("tiny"))
+	})
+
+	toggle := httptest.NewRequest(http.MethodPost, "/_/debug/toggle", strings.NewReader(`{"enable":true}`))
+	toggle.Header.Set("X-Debug-Key", "debug-key")
+	h.ServeHTTP(httptest.NewRecorder(), toggle)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/export", nil))
+	assert.Equal(t, 100, rec.Body.Len(), "the client still gets the whole body")
+
+	dump := logger.lastDump()
+	assert.Assert(t, strings.Contains(dump, strings.Repeat("a", 10)+strings.Repeat("b", 6)+"\n[... 84 bytes truncated]"), "dump %q", dump)
+	assert.Assert(t, !strings.Contains(dump, "cc"), "dump %q holds bytes past the limit", dump)
+
+	// A buffer released by the truncated request starts empty when reused
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/small", nil))
+	dump = logger.lastDump()
+	assert.Assert(t, strings.HasSuffix(dump, "\r\n\r\ntiny"), "dump %q", dump)
+}
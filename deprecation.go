@@ -0,0 +1,29 @@
+package ags
+
+import (
+	"net/http"
+	"time"
+)
+
+// Deprecated returns a middleware marking the responses of a route as
+// deprecated: it sets "Deprecation: true", a Sunset header with the date
+// after which the route may stop working (RFC 8594) and a Link header with
+// rel="deprecation" pointing to documentation about the change. A zero
+// sunset or an empty link leaves out the corresponding header. Use it on a
+// group or wrap a single handler:
+//
+//	h.Get("/v1/orders", ags.Deprecated(sunset, "https://docs.example/v2")(listOrders).ServeHTTP)
+func Deprecated(sunset time.Time, link string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Deprecation", "true")
+			if !sunset.IsZero() {
+				w.Header().Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+			}
+			if link != "" {
+				w.Header().Add("Link", "<"+link+`>; rel="deprecation"`)
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
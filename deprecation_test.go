@@ -0,0 +1,51 @@
+package ags_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestDeprecated(t *testing.T) {
+	sunset := time.Date(2027, time.March, 31, 0, 0, 0, 0, time.UTC)
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/v1/orders", ags.Deprecated(sunset, "https://docs.example/migrate-v2")(http.HandlerFunc(ok)).ServeHTTP)
+	h.Group("/v0", ags.Deprecated(time.Time{}, "")).Get("/orders", ok)
+	h.Get("/v2/orders", ok)
+
+	tests := []struct {
+		path            string
+		wantDeprecation string
+		wantSunset      string
+		wantLink        string
+	}{
+		{
+			path:            "/v1/orders",
+			wantDeprecation: "true",
+			wantSunset:      "Wed, 31 Mar 2027 00:00:00 GMT",
+			wantLink:        `<https://docs.example/migrate-v2>; rel="deprecation"`,
+		},
+		{path: "/v0/orders", wantDeprecation: "true"},
+		{path: "/v2/orders"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.wantDeprecation, rec.Header().Get("Deprecation"))
+			assert.Equal(t, tt.wantSunset, rec.Header().Get("Sunset"))
+			assert.Equal(t, tt.wantLink, rec.Header().Get("Link"))
+		})
+	}
+}
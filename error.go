@@ -6,9 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"runtime"
 	"strings"
 	"time"
+
+	"github.com/getangry/ags/pkg/middleware"
 )
 
 // ErrorCode represents a unique error identifier
@@ -16,19 +19,43 @@ type ErrorCode string
 
 // Known error codes
 const (
-	ErrCodeInternal     ErrorCode = "INTERNAL_ERROR"
-	ErrCodeValidation   ErrorCode = "VALIDATION_ERROR"
-	ErrCodeUnauthorized ErrorCode = "UNAUTHORIZED"
-	ErrCodeNotFound     ErrorCode = "NOT_FOUND"
-	ErrCodeBadRequest   ErrorCode = "BAD_REQUEST"
+	ErrCodeInternal           ErrorCode = "INTERNAL_ERROR"
+	ErrCodeValidation         ErrorCode = "VALIDATION_ERROR"
+	ErrCodeUnauthorized       ErrorCode = "UNAUTHORIZED"
+	ErrCodeNotFound           ErrorCode = "NOT_FOUND"
+	ErrCodeBadRequest         ErrorCode = "BAD_REQUEST"
+	ErrCodeTimeout            ErrorCode = "TIMEOUT"
+	ErrCodeServiceUnavailable ErrorCode = "SERVICE_UNAVAILABLE"
+)
+
+// Severity tells clients how serious an ErrorDetail is
+type Severity string
+
+const (
+	// SeverityError marks a detail that made the request fail. Details
+	// without a severity are errors too.
+	SeverityError Severity = "error"
+	// SeverityWarning marks a non-fatal notice, e.g. a deprecation, sent
+	// alongside a successful response
+	SeverityWarning Severity = "warning"
 )
 
 // ErrorDetail represents a single error detail
 type ErrorDetail struct {
-	Code    ErrorCode    `json:"code"`
-	Message string       `json:"message"`
-	Field   string       `json:"field,omitempty"`
-	Context ErrorContext `json:"-"` // Only for logging, not serialized
+	Code     ErrorCode    `json:"code"`
+	Message  string       `json:"message"`
+	Field    string       `json:"field,omitempty"`
+	Severity Severity     `json:"severity,omitempty"`
+	Context  ErrorContext `json:"-"` // Only for logging, not serialized
+}
+
+// NewWarning creates a warning detail for RespondJSONWithWarnings
+func NewWarning(code ErrorCode, message string) ErrorDetail {
+	return ErrorDetail{
+		Code:     code,
+		Message:  message,
+		Severity: SeverityWarning,
+	}
 }
 
 // ErrorContext holds additional context for logging
@@ -48,6 +75,10 @@ type AppError struct {
 	InternalLogs []string        `json:"-"` // For logging only
 	Context      context.Context `json:"-"`
 	StatusCode   int             `json:"-"`
+
+	// debugDetails are sent by the default renderer regardless of the
+	// ErrorDetailPolicy; Recover sets them only while debug mode is enabled
+	debugDetails []ErrorDetail
 }
 
 // Error implements the error interface
@@ -135,6 +166,16 @@ func (e *AppError) AddInternalLog(format string, args ...interface{}) *AppError
 	return e
 }
 
+// clone returns a copy of e that can be annotated without changing e, e.g.
+// when e is a shared sentinel
+func (e *AppError) clone() *AppError {
+	c := *e
+	c.Details = append([]ErrorDetail(nil), e.Details...)
+	c.InternalLogs = append([]string(nil), e.InternalLogs...)
+	c.debugDetails = append([]ErrorDetail(nil), e.debugDetails...)
+	return &c
+}
+
 // getHTTPStatusForErrorCode maps error codes to HTTP status codes
 func getHTTPStatusForErrorCode(code ErrorCode) int {
 	switch code {
@@ -144,6 +185,10 @@ func getHTTPStatusForErrorCode(code ErrorCode) int {
 		return http.StatusUnauthorized
 	case ErrCodeNotFound:
 		return http.StatusNotFound
+	case ErrCodeServiceUnavailable:
+		return http.StatusServiceUnavailable
+	case ErrCodeTimeout:
+		return http.StatusGatewayTimeout
 	default:
 		return http.StatusInternalServerError
 	}
@@ -151,43 +196,200 @@ func getHTTPStatusForErrorCode(code ErrorCode) int {
 
 // ErrorInfo represents the client-facing error information
 type ErrorInfo struct {
-	Code    ErrorCode `json:"code"`
-	Message string    `json:"message"`
+	Code    ErrorCode     `json:"code"`
+	Message string        `json:"message"`
+	Details []ErrorDetail `json:"details,omitempty"`
+}
+
+// StatusClientClosedRequest is the non-standard status used when the client
+// closed the connection before the response was ready
+const StatusClientClosedRequest = 499
+
+// CheckContext returns nil while ctx is still active. Once ctx is done it
+// returns an AppError describing why: a 499 for a canceled request, typically
+// a client disconnect, or a 504 when the deadline passed. Long-running
+// handlers should call it between units of work and return early on error.
+func CheckContext(ctx context.Context) error {
+	err := ctx.Err()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return NewError(ErrCodeTimeout, "Request timed out").WithError(err).WithContext(ctx)
+	}
+	appErr := NewError(ErrCodeBadRequest, "Request canceled").WithError(err).WithContext(ctx)
+	appErr.StatusCode = StatusClientClosedRequest
+	return appErr
 }
 
-// Update the error handling in the Handler struct
+// ErrorDetailPolicy controls which AppError details Handler.Error sends to
+// the client. Detail contexts (stack traces, metadata) are never sent.
+type ErrorDetailPolicy int
+
+const (
+	// ErrorDetailsNone sends only the error code and message (the default)
+	ErrorDetailsNone ErrorDetailPolicy = iota
+	// ErrorDetailsFields additionally sends validation details, such as
+	// those added with WithField, so clients can show per-field errors
+	ErrorDetailsFields
+)
+
+// clientDetails returns the details of e that policy allows to be sent to the client
+func clientDetails(e *AppError, policy ErrorDetailPolicy) []ErrorDetail {
+	if policy != ErrorDetailsFields {
+		return nil
+	}
+	var details []ErrorDetail
+	for _, d := range e.Details {
+		if d.Code == ErrCodeValidation {
+			details = append(details, ErrorDetail{
+				Code:    d.Code,
+				Message: d.Message,
+				Field:   d.Field,
+			})
+		}
+	}
+	return details
+}
+
+// requestFromWriter returns the request associated with one of our response
+// writer wrappers, or nil when w was not created by wrapHandler
+func requestFromWriter(w http.ResponseWriter) *http.Request {
+	if dw := debugWriterOf(w); dw != nil {
+		return dw.request
+	}
+	return nil
+}
+
+// Error writes err to the client as a JSON error response and logs the
+// details. The request is taken from w when it was created by wrapHandler;
+// otherwise a background request is used. Prefer ErrorCtx when the request
+// is at hand.
 func (h *Handler) Error(w http.ResponseWriter, err error) {
+	r := requestFromWriter(w)
+	if r == nil {
+		r = backgroundRequest()
+	}
+	h.ErrorCtx(w, r, err)
+}
+
+// ErrorCtx is like Error but takes the request explicitly. The request context
+// is used for the logger and to correlate the log entry with the request.
+func (h *Handler) ErrorCtx(w http.ResponseWriter, r *http.Request, err error) {
 	var appErr *AppError
 	if errors.As(err, &appErr) {
-		// Log the detailed error information
-		h.cfg.Log.Error("request error",
+		fields := []interface{}{
 			"code", appErr.Code,
 			"message", appErr.Message,
-			"details", appErr.Details,
-			"internal_logs", appErr.InternalLogs,
+			"error_details", newErrorLog(appErr),
 			"original_error", appErr.MainError,
-		)
+		}
 
-		// Send simplified error response to client
-		response := StandardResponse{
-			OK:      false,
-			Message: appErr.Message,
-			Error: &ErrorInfo{
-				Code:    appErr.Code,
-				Message: appErr.Message,
-			},
+		// Correlate with the access log using the request id and route
+		reqID := middleware.GetReqID(appErr.Context)
+		if reqID == "" {
+			reqID = middleware.GetReqID(r.Context())
+		}
+		if reqID != "" {
+			fields = append(fields, "request_id", reqID)
+		}
+		if route := RoutePattern(r.Context()); route != "" {
+			fields = append(fields, "route", route)
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(appErr.StatusCode)
-		if err := json.NewEncoder(w).Encode(response); err != nil {
-			h.cfg.Log.Error("failed to encode JSON response", "error", err)
+		// Log the detailed error information
+		h.Log(r.Context()).Error("request error", fields...)
+
+		// The response is decided before the hook runs, so it cannot alter it
+		rendered := *appErr
+		if h.cfg.OnError != nil {
+			h.cfg.OnError(r.Context(), appErr)
 		}
+
+		h.errorRendererFor(w)(w, r, &rendered)
 		return
 	}
 
 	// Handle non-AppError errors
 	defaultErr := NewError(ErrCodeInternal, "An internal error occurred")
 	defaultErr.WithError(err).AddInternalLog("Unexpected error type: %T", err)
-	h.Error(w, defaultErr)
+	h.ErrorCtx(w, r, defaultErr)
+}
+
+// errorLog is the structured error_details field of the error log entry. It
+// marshals to nested JSON, and its String form is the same JSON so text
+// loggers formatting fields with %v stay parseable.
+type errorLog struct {
+	Details      []errorLogDetail `json:"details,omitempty"`
+	InternalLogs []string         `json:"internal_logs,omitempty"`
+}
+
+// errorLogDetail is an ErrorDetail including the context kept out of client
+// responses
+type errorLogDetail struct {
+	ErrorDetail
+	Context *ErrorContext `json:"context,omitempty"`
+}
+
+func newErrorLog(appErr *AppError) errorLog {
+	el := errorLog{InternalLogs: appErr.InternalLogs}
+	for _, d := range appErr.Details {
+		ld := errorLogDetail{ErrorDetail: d}
+		if !d.Context.Time.IsZero() || len(d.Context.Stack) > 0 || d.Context.Trace != "" || len(d.Context.Metadata) > 0 {
+			ctx := d.Context
+			ld.Context = &ctx
+		}
+		el.Details = append(el.Details, ld)
+	}
+	return el
+}
+
+// String returns the JSON form of the entry
+func (e errorLog) String() string {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Sprint(e.Details, e.InternalLogs)
+	}
+	return string(data)
+}
+
+// ErrorRenderer writes an AppError to the client. Renderers decide the
+// format; logging and the OnError hook have already run when they are called.
+type ErrorRenderer func(w http.ResponseWriter, r *http.Request, err *AppError)
+
+// errorRendererFor returns the renderer for a response: the one of the route
+// group serving it, then ServerConfig.ErrorRenderer, then the JSON default
+func (h *Handler) errorRendererFor(w http.ResponseWriter) ErrorRenderer {
+	if dw := debugWriterOf(w); dw != nil && dw.errorRenderer != nil {
+		return dw.errorRenderer
+	}
+	if h.cfg.ErrorRenderer != nil {
+		return h.cfg.ErrorRenderer
+	}
+	return h.renderJSONError
+}
+
+// renderJSONError sends the simplified error in the standard JSON envelope
+func (h *Handler) renderJSONError(w http.ResponseWriter, r *http.Request, appErr *AppError) {
+	response := StandardResponse{
+		OK:      false,
+		Message: appErr.Message,
+		Error: &ErrorInfo{
+			Code:    appErr.Code,
+			Message: appErr.Message,
+			Details: append(clientDetails(appErr, h.cfg.ErrorDetails), appErr.debugDetails...),
+		},
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(appErr.StatusCode)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		h.Log(r.Context()).Error("failed to encode JSON response", "error", err)
+	}
+}
+
+// backgroundRequest returns an empty request carrying context.Background(),
+// used when an error is reported without a request at hand
+func backgroundRequest() *http.Request {
+	return (&http.Request{URL: &url.URL{}, Header: make(http.Header)}).WithContext(context.Background())
 }
@@ -1,14 +1,19 @@
 package ags_test
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/getangry/ags"
+	"github.com/getangry/ags/pkg/middleware"
 )
 
 func TestNewError(t *testing.T) {
@@ -36,6 +41,18 @@ func TestNewError(t *testing.T) {
 			message:      "unauthorized access",
 			wantHTTPCode: http.StatusUnauthorized,
 		},
+		{
+			name:         "timeout error",
+			code:         ags.ErrCodeTimeout,
+			message:      "upstream timed out",
+			wantHTTPCode: http.StatusGatewayTimeout,
+		},
+		{
+			name:         "service unavailable error",
+			code:         ags.ErrCodeServiceUnavailable,
+			message:      "try again later",
+			wantHTTPCode: http.StatusServiceUnavailable,
+		},
 	}
 
 	for _, tt := range tests {
@@ -186,3 +203,269 @@ func TestHandler_Error(t *testing.T) {
 		})
 	}
 }
+
+func TestHandler_Error_RequestCorrelation(t *testing.T) {
+	mockLog := &mockLogger{}
+	handler := ags.NewHandler(&ags.ServerConfig{
+		Log: mockLog,
+	})
+
+	handler.Get("/orders/:id", func(w http.ResponseWriter, r *http.Request) {
+		handler.Error(w, ags.NewError(ags.ErrCodeNotFound, "missing"))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/orders/42", nil)
+	rec := httptest.NewRecorder()
+	middleware.RequestID(handler).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("Error() status = %v, want %v", rec.Code, http.StatusNotFound)
+	}
+
+	reqID, _ := mockLog.field("request_id").(string)
+	if reqID == "" {
+		t.Errorf("Error() log fields = %v, want a request_id", mockLog.lastFields)
+	}
+	if route := mockLog.field("route"); route != "/orders/:id" {
+		t.Errorf("Error() logged route = %v, want /orders/:id", route)
+	}
+}
+
+func TestHandler_ErrorCtx(t *testing.T) {
+	mockLog := &mockLogger{}
+	handler := ags.NewHandler(&ags.ServerConfig{
+		Log: mockLog,
+	})
+
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-123")
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	handler.ErrorCtx(rec, req, ags.NewError(ags.ErrCodeNotFound, "missing"))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("ErrorCtx() status = %v, want %v", rec.Code, http.StatusNotFound)
+	}
+	if mockLog.lastCtx != ctx {
+		t.Errorf("ErrorCtx() logger context = %v, want the request context", mockLog.lastCtx)
+	}
+	if reqID := mockLog.field("request_id"); reqID != "req-123" {
+		t.Errorf("ErrorCtx() logged request_id = %v, want req-123", reqID)
+	}
+	// The request was not routed, so it has no route pattern
+	if route := mockLog.field("route"); route != nil {
+		t.Errorf("ErrorCtx() logged route = %v, want none", route)
+	}
+}
+
+func TestHandler_Error_WithoutRequest(t *testing.T) {
+	mockLog := &mockLogger{}
+	handler := ags.NewHandler(&ags.ServerConfig{
+		Log: mockLog,
+	})
+
+	rec := httptest.NewRecorder()
+	handler.Error(rec, errors.New("boom"))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("Error() status = %v, want %v", rec.Code, http.StatusInternalServerError)
+	}
+	if mockLog.lastCtx != context.Background() {
+		t.Errorf("Error() logger context = %v, want context.Background()", mockLog.lastCtx)
+	}
+	if route := mockLog.field("route"); route != nil {
+		t.Errorf("Error() logged route = %v, want none", route)
+	}
+}
+
+func TestHandler_Error_OnError(t *testing.T) {
+	var got *ags.AppError
+	var gotStatus int
+	var gotCtx context.Context
+	handler := ags.NewHandler(&ags.ServerConfig{
+		Log: &mockLogger{},
+		OnError: func(ctx context.Context, err *ags.AppError) {
+			gotCtx = ctx
+			got = err
+			gotStatus = err.StatusCode
+			// The response has already been decided
+			err.StatusCode = http.StatusTeapot
+			err.Message = "changed"
+		},
+	})
+
+	ctx := context.WithValue(context.Background(), middleware.RequestIDKey, "req-1")
+	req := httptest.NewRequest(http.MethodGet, "/orders", nil).WithContext(ctx)
+	rec := httptest.NewRecorder()
+
+	handler.ErrorCtx(rec, req, ags.NewError(ags.ErrCodeUnauthorized, "login required"))
+
+	if got == nil {
+		t.Fatal("OnError was not called")
+	}
+	if got.Code != ags.ErrCodeUnauthorized {
+		t.Errorf("OnError code = %v, want %v", got.Code, ags.ErrCodeUnauthorized)
+	}
+	if gotStatus != http.StatusUnauthorized {
+		t.Errorf("OnError status = %v, want %v", gotStatus, http.StatusUnauthorized)
+	}
+	if gotCtx != ctx {
+		t.Errorf("OnError context = %v, want the request context", gotCtx)
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("Error() status = %v, want %v", rec.Code, http.StatusUnauthorized)
+	}
+	if !strings.Contains(rec.Body.String(), "login required") {
+		t.Errorf("Error() body = %v, want the original message", rec.Body.String())
+	}
+}
+
+func TestHandler_Error_DetailPolicy(t *testing.T) {
+	newErr := func() *ags.AppError {
+		return ags.NewError(ags.ErrCodeValidation, "invalid input").
+			WithField("email", "must be a valid address").
+			WithDetail(ags.ErrCodeInternal, "lookup failed").
+			WithMetadata("table", "users")
+	}
+
+	tests := []struct {
+		name        string
+		policy      ags.ErrorDetailPolicy
+		wantDetails []ags.ErrorDetail
+	}{
+		{
+			name:   "default sends no details",
+			policy: ags.ErrorDetailsNone,
+		},
+		{
+			name:   "fields policy sends field errors",
+			policy: ags.ErrorDetailsFields,
+			wantDetails: []ags.ErrorDetail{
+				{Code: ags.ErrCodeValidation, Message: "must be a valid address", Field: "email"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := ags.NewHandler(&ags.ServerConfig{
+				Log:          &mockLogger{},
+				ErrorDetails: tt.policy,
+			})
+
+			rec := httptest.NewRecorder()
+			handler.Error(rec, newErr())
+
+			var resp ags.StandardResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if !reflect.DeepEqual(resp.Error.Details, tt.wantDetails) {
+				t.Errorf("Error() details = %+v, want %+v", resp.Error.Details, tt.wantDetails)
+			}
+
+			body := rec.Body.String()
+			for _, leaked := range []string{"lookup failed", "stack", "goroutine", "users", "metadata"} {
+				if strings.Contains(body, leaked) {
+					t.Errorf("Error() body = %s, must not contain %q", body, leaked)
+				}
+			}
+		})
+	}
+}
+
+func TestHandler_Error_StructuredLog(t *testing.T) {
+	var buf bytes.Buffer
+	h := ags.NewHandler(&ags.ServerConfig{Log: ags.NewJSONLogger(&buf, ags.InfoLevel)})
+	h.Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+		h.ErrorCtx(w, r, ags.NewError(ags.ErrCodeValidation, "Invalid order").
+			WithField("quantity", "must be positive").
+			WithMetadata("received", "-3").
+			AddInternalLog("rejected by %s", "validator").
+			WithError(errors.New("quantity < 1")))
+	})
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/orders", nil))
+
+	var entry struct {
+		Level         string `json:"level"`
+		Msg           string `json:"msg"`
+		OriginalError string `json:"original_error"`
+		ErrorDetails  struct {
+			Details []struct {
+				Code    string `json:"code"`
+				Field   string `json:"field"`
+				Context struct {
+					Metadata map[string]string `json:"metadata"`
+				} `json:"context"`
+			} `json:"details"`
+			InternalLogs []string `json:"internal_logs"`
+		} `json:"error_details"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("log line is not JSON: %v: %s", err, buf.String())
+	}
+	if entry.Level != "ERROR" || entry.Msg != "request error" || entry.OriginalError != "quantity < 1" {
+		t.Errorf("unexpected entry: %s", buf.String())
+	}
+	details := entry.ErrorDetails.Details
+	if len(details) != 1 || details[0].Code != "VALIDATION_ERROR" || details[0].Field != "quantity" || details[0].Context.Metadata["received"] != "-3" {
+		t.Errorf("details not logged as nested JSON: %s", buf.String())
+	}
+	if !reflect.DeepEqual(entry.ErrorDetails.InternalLogs, []string{"rejected by validator"}) {
+		t.Errorf("internal logs = %v", entry.ErrorDetails.InternalLogs)
+	}
+	if strings.Contains(buf.String(), "{VALIDATION_ERROR") {
+		t.Errorf("log holds Go %%v formatting: %s", buf.String())
+	}
+
+	// Text loggers format the field with %v, which yields the same JSON
+	var text bytes.Buffer
+	textLogger := ags.NewDefaultLogger(ags.InfoLevel)
+	textLogger.SetOutput(&text)
+	h = ags.NewHandler(&ags.ServerConfig{Log: textLogger})
+	h.Error(httptest.NewRecorder(), ags.NewError(ags.ErrCodeNotFound, "Order not found").AddInternalLog("id=%d", 7))
+	if !strings.Contains(text.String(), `error_details={"internal_logs":["id=7"]}`) {
+		t.Errorf("text log does not hold JSON error details: %s", text.String())
+	}
+}
+
+func TestCheckContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	expired, cancelExpired := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelExpired()
+
+	tests := []struct {
+		name       string
+		ctx        context.Context
+		wantStatus int
+	}{
+		{name: "active", ctx: context.Background()},
+		{name: "canceled", ctx: canceled, wantStatus: ags.StatusClientClosedRequest},
+		{name: "deadline exceeded", ctx: expired, wantStatus: http.StatusGatewayTimeout},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ags.CheckContext(tt.ctx)
+			if tt.wantStatus == 0 {
+				if err != nil {
+					t.Fatalf("CheckContext() = %v, want nil", err)
+				}
+				return
+			}
+
+			var appErr *ags.AppError
+			if !errors.As(err, &appErr) {
+				t.Fatalf("CheckContext() = %v, want an AppError", err)
+			}
+			if appErr.StatusCode != tt.wantStatus {
+				t.Errorf("CheckContext() status = %v, want %v", appErr.StatusCode, tt.wantStatus)
+			}
+			if !errors.Is(appErr.MainError, tt.ctx.Err()) {
+				t.Errorf("CheckContext() cause = %v, want %v", appErr.MainError, tt.ctx.Err())
+			}
+		})
+	}
+}
@@ -10,7 +10,6 @@ import (
 	"sync"
 
 	"github.com/getangry/ags"
-	"github.com/gorilla/websocket"
 )
 
 //go:embed dist
@@ -23,7 +22,7 @@ type Message struct {
 }
 
 type ChatServer struct {
-	clients   sync.Map // map[*websocket.Conn]string
+	clients   sync.Map // map[*ags.WSConnection]string
 	broadcast chan Message
 	userCount int
 	handler   *ags.Handler
@@ -36,7 +35,7 @@ func NewChatServer() *ChatServer {
 	}
 }
 
-func (cs *ChatServer) handleWebSocket(conn *websocket.Conn) {
+func (cs *ChatServer) handleWebSocket(conn *ags.WSConnection) {
 	// Generate user name
 	cs.mu.Lock()
 	cs.userCount++
@@ -76,7 +75,7 @@ func (cs *ChatServer) handleWebSocket(conn *websocket.Conn) {
 func (cs *ChatServer) broadcastMessages() {
 	for msg := range cs.broadcast {
 		cs.clients.Range(func(key, value interface{}) bool {
-			conn := key.(*websocket.Conn)
+			conn := key.(*ags.WSConnection)
 			err := conn.WriteJSON(msg)
 			if err != nil {
 				log.Printf("error sending message: %v", err)
@@ -104,7 +103,7 @@ func main() {
 	handler := ags.NewHandler(cfg)
 
 	// Register WebSocket route
-	handler.RegisterWSRoute("/chat", chatServer.handleWebSocket)
+	handler.RegisterWSConnRoute("/chat", chatServer.handleWebSocket)
 
 	// Register HTTP routes
 	// handler.Get("/", func(w http.ResponseWriter, r *http.Request) {
@@ -145,6 +144,12 @@ func (l *SimpleLogger) Error(msg string, fields ...interface{}) {
 func (l *SimpleLogger) Debug(msg string, fields ...interface{}) {
 	log.Printf("[DEBUG] "+msg, fields...)
 }
+func (l *SimpleLogger) Fatal(msg string, fields ...interface{}) {
+	log.Fatalf("[FATAL] "+msg, fields...)
+}
+func (l *SimpleLogger) Panic(msg string, fields ...interface{}) {
+	log.Panicf("[PANIC] "+msg, fields...)
+}
 func (l *SimpleLogger) WithFields(fields map[string]interface{}) ags.Logger {
 	l.data = fields
 	return l
@@ -159,6 +164,9 @@ func (l *SimpleLogger) SetLevel(level ags.LogLevel) {
 func (l *SimpleLogger) GetLevel() ags.LogLevel {
 	return l.level
 }
+func (l *SimpleLogger) Enabled(level ags.LogLevel) bool {
+	return level >= l.level
+}
 
 func createAuthorizer() ags.Authorizer {
 	return &SimpleAuthorizer{}
@@ -6,10 +6,7 @@ import (
 	"net/http"
 
 	"github.com/getangry/ags"
-	"github.com/getangry/ags/pkg/middleware"
 	"github.com/google/uuid"
-	"golang.org/x/net/http2"
-	"golang.org/x/net/http2/h2c"
 
 	// Import the generated protobuf code
 	pb "github.com/getangry/ags/examples/03_grpc/gen"
@@ -80,8 +77,9 @@ func main() {
 
 	// Create server configuration
 	cfg := &ags.ServerConfig{
-		Log:  logger,
-		Auth: &Authorizer{},
+		Log:       logger,
+		Auth:      &Authorizer{},
+		EnableH2C: true,
 	}
 
 	// Create new handler
@@ -102,32 +100,12 @@ func main() {
 		}
 	})
 
-	// Create the middleware chain
-	wrapped := middleware.RequestID(handler)
-
 	// Print registered routes for debugging
 	handler.PrintRoutes()
 
-	h2cHandler := allowH2C(wrapped)
-	server := &http.Server{
-		Addr:    ":7841",
-		Handler: h2cHandler,
-	}
-
-	// Enable HTTP/2 support
-	if err := http2.ConfigureServer(server, &http2.Server{}); err != nil {
-		log.Fatalf("Failed to configure HTTP/2 server: %v", err)
-	}
-
-	// Start the server
+	// Start the server; EnableH2C lets gRPC clients connect over plaintext
 	log.Println("Starting server on :7841...")
-	if err := server.ListenAndServe(); err != nil {
+	if err := handler.Start(); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
-
-// allowH2C enables H2C support
-func allowH2C(h http.Handler) http.Handler {
-	config := &http2.Server{}
-	return h2c.NewHandler(h, config)
-}
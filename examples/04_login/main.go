@@ -8,6 +8,7 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/getangry/ags"
 	_ "github.com/mattn/go-sqlite3"
@@ -40,6 +41,12 @@ func AuthMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			token := r.Header.Get("Authorization")
+			if token == "" {
+				// Browsers send the session cookie set at login instead
+				if cookie, err := r.Cookie("session"); err == nil {
+					token = cookie.Value
+				}
+			}
 			if token == "" {
 				if err := ags.RespondJSON(w, http.StatusUnauthorized, "No authorization token provided", nil); err != nil {
 					log.Printf("Failed to respond with unauthorized error: %v", err)
@@ -62,7 +69,7 @@ func AuthMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 			}
 
 			// Add username to context and continue
-			ctx := context.WithValue(r.Context(), "username", username)
+			ctx := ags.WithUser(r.Context(), username)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
@@ -71,15 +78,19 @@ func AuthMiddleware(db *sql.DB) func(http.Handler) http.Handler {
 // GetUsername retrieves the username from the context
 // Helper function to make it easier to get the username in handlers
 func GetUsername(ctx context.Context) (string, bool) {
-	username, ok := ctx.Value("username").(string)
+	user, _ := ags.ContextUser(ctx)
+	username, ok := user.(string)
 	return username, ok
 }
 
 func main() {
+	// Create a new logger
+	logger := ags.NewDefaultLogger(ags.DebugLevel)
+
 	// Initialize SQLite database
 	db, err := sql.Open("sqlite3", "./auth.db")
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("failed to open database", "error", err)
 	}
 	defer db.Close()
 
@@ -100,12 +111,9 @@ func main() {
         INSERT OR IGNORE INTO users (username, password) VALUES ('testuser', '$2a$10$qIxyTPvnSJK09QJn3kffz.xn8QwTqmVLQ9wX1qLimyQ2roHG5NagK');
 	`)
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("failed to create tables", "error", err)
 	}
 
-	// Create a new logger
-	logger := ags.NewDefaultLogger(ags.DebugLevel)
-
 	// Initialize server config
 	cfg := &ags.ServerConfig{
 		DB:  db,
@@ -118,7 +126,7 @@ func main() {
 	// Serve static files (HTML, CSS, JS)
 	err = h.RegisterFileServer("./static", ags.WithSPASupport(true))
 	if err != nil {
-		log.Fatal(err)
+		logger.Fatal("failed to register file server", "error", err)
 	}
 
 	// Login endpoint
@@ -166,6 +174,10 @@ func main() {
 			return
 		}
 
+		// The cookie gets the secure defaults: HttpOnly, SameSite=Lax and
+		// Secure over TLS
+		ags.SetCookie(w, "session", token, ags.WithCookieMaxAge(24*time.Hour))
+
 		// Send response
 		if err := ags.RespondJSON(w, http.StatusOK, "Login successful", LoginResponse{
 			Token:    token,
@@ -182,7 +194,7 @@ func main() {
 
 	// Add protected routes to the group
 	protected.Get("/me", func(w http.ResponseWriter, r *http.Request) {
-		username := r.Context().Value("username").(string)
+		username, _ := GetUsername(r.Context())
 		if err := ags.RespondJSON(w, http.StatusOK, "Profile retrieved", map[string]string{
 			"username": username,
 		}); err != nil {
@@ -191,6 +203,6 @@ func main() {
 		}
 	})
 	if err := h.Start(); err != nil {
-		log.Fatal(err)
+		logger.Fatal("server stopped", "error", err)
 	}
 }
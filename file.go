@@ -0,0 +1,97 @@
+package ags
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RespondFile sends the file at path as a download named downloadName, or
+// after the file itself when downloadName is empty. The Content-Type is
+// derived from the name's extension or sniffed from the content, and range
+// and conditional requests are served through http.ServeContent. Paths with
+// ".." elements are rejected like the file server does, so a name taken from
+// the request cannot climb out of the directory it is joined onto. When the
+// file cannot be served nothing is written and an AppError is returned for
+// Handler.Error.
+func RespondFile(w http.ResponseWriter, r *http.Request, path, downloadName string) error {
+	if c, ok := w.(interface{ Committed() bool }); ok && c.Committed() {
+		return ErrResponseCommitted
+	}
+	if hasDotDot(path) {
+		return NewError(ErrCodeBadRequest, "Invalid file path").AddInternalLog("path %q escapes its directory", path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return NewError(ErrCodeNotFound, "File not found").WithError(err)
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return NewError(ErrCodeInternal, "Failed to read file").WithError(err)
+	}
+	if fi.IsDir() {
+		return NewError(ErrCodeNotFound, "File not found").AddInternalLog("%s is a directory", path)
+	}
+
+	if downloadName == "" {
+		downloadName = filepath.Base(path)
+	}
+	w.Header().Set("Content-Disposition", contentDisposition(downloadName))
+	http.ServeContent(w, r, downloadName, fi.ModTime(), f)
+	return nil
+}
+
+// RespondAttachment streams content from reader as a download named name.
+// An empty contentType is derived from the name's extension or sniffed from
+// the first 512 bytes. Range requests need a seekable source; use
+// RespondFile or http.ServeContent for those.
+func RespondAttachment(w http.ResponseWriter, reader io.Reader, name, contentType string) error {
+	if c, ok := w.(interface{ Committed() bool }); ok && c.Committed() {
+		return ErrResponseCommitted
+	}
+
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(name))
+	}
+	if contentType == "" {
+		var sniff [512]byte
+		n, err := io.ReadFull(reader, sniff[:])
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return NewError(ErrCodeInternal, "Failed to read attachment").WithError(err)
+		}
+		contentType = http.DetectContentType(sniff[:n])
+		reader = io.MultiReader(bytes.NewReader(sniff[:n]), reader)
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", contentDisposition(name))
+	w.WriteHeader(http.StatusOK)
+	_, err := io.Copy(w, reader)
+	return err
+}
+
+// contentDisposition formats an attachment disposition for name, encoding
+// non-ASCII names as RFC 2231 requires
+func contentDisposition(name string) string {
+	if v := mime.FormatMediaType("attachment", map[string]string{"filename": name}); v != "" {
+		return v
+	}
+	return "attachment"
+}
+
+// hasDotDot reports whether any element of p is ".."
+func hasDotDot(p string) bool {
+	for _, elem := range strings.FieldsFunc(filepath.ToSlash(p), func(r rune) bool { return r == '/' }) {
+		if elem == ".." {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package ags_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestRespondFile(t *testing.T) {
+	dir := t.TempDir()
+	reportPath := filepath.Join(dir, "report-2026.csv")
+	assert.NilError(t, os.WriteFile(reportPath, []byte("id,total\n1,10\n2,20\n"), 0o644))
+
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/reports/:name", func(w http.ResponseWriter, r *http.Request) {
+		if err := ags.RespondFile(w, r, filepath.Join(dir, ags.PathParam(r, "name")), "report.csv"); err != nil {
+			h.ErrorCtx(w, r, err)
+		}
+	})
+
+	tests := []struct {
+		name            string
+		path            string
+		rangeHeader     string
+		wantStatus      int
+		wantBody        string
+		wantDisposition string
+		wantRange       string
+	}{
+		{
+			name:            "full download",
+			path:            "/reports/report-2026.csv",
+			wantStatus:      http.StatusOK,
+			wantBody:        "id,total\n1,10\n2,20\n",
+			wantDisposition: `attachment; filename=report.csv`,
+		},
+		{
+			name:            "ranged download",
+			path:            "/reports/report-2026.csv",
+			rangeHeader:     "bytes=9-13",
+			wantStatus:      http.StatusPartialContent,
+			wantBody:        "1,10\n",
+			wantDisposition: `attachment; filename=report.csv`,
+			wantRange:       "bytes 9-13/19",
+		},
+		{name: "missing file", path: "/reports/missing.csv", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.rangeHeader != "" {
+				req.Header.Set("Range", tt.rangeHeader)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantDisposition, rec.Header().Get("Content-Disposition"))
+			assert.Equal(t, tt.wantRange, rec.Header().Get("Content-Range"))
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rec.Body.String())
+				assert.Equal(t, "text/csv; charset=utf-8", rec.Header().Get("Content-Type"))
+			}
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	err := ags.RespondFile(rec, httptest.NewRequest(http.MethodGet, "/", nil), dir+"/../report-2026.csv", "")
+	var appErr *ags.AppError
+	assert.Assert(t, errors.As(err, &appErr))
+	assert.Equal(t, http.StatusBadRequest, appErr.StatusCode)
+	assert.Equal(t, "", rec.Header().Get("Content-Disposition"))
+}
+
+func TestRespondAttachment(t *testing.T) {
+	tests := []struct {
+		name            string
+		filename        string
+		contentType     string
+		body            string
+		wantType        string
+		wantDisposition string
+	}{
+		{
+			name:            "explicit type",
+			filename:        "export.json",
+			contentType:     "application/vnd.example+json",
+			body:            `{"a":1}`,
+			wantType:        "application/vnd.example+json",
+			wantDisposition: `attachment; filename=export.json`,
+		},
+		{
+			name:            "type from extension",
+			filename:        "notes.txt",
+			body:            "hello",
+			wantType:        "text/plain; charset=utf-8",
+			wantDisposition: `attachment; filename=notes.txt`,
+		},
+		{
+			name:            "sniffed type with non-ASCII name",
+			filename:        "résumé",
+			body:            "%PDF-1.7 body",
+			wantType:        "application/pdf",
+			wantDisposition: `attachment; filename*=utf-8''r%C3%A9sum%C3%A9`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			assert.NilError(t, ags.RespondAttachment(rec, strings.NewReader(tt.body), tt.filename, tt.contentType))
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.wantType, rec.Header().Get("Content-Type"))
+			assert.Equal(t, tt.wantDisposition, rec.Header().Get("Content-Disposition"))
+			assert.Equal(t, tt.body, rec.Body.String())
+		})
+	}
+}
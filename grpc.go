@@ -1,35 +1,235 @@
 package ags
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/gorilla/websocket"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
 )
 
-// gRPC Handler implementation
+// gRPC Handler implementation. The gRPC server is created on first use, so
+// options can still be changed until a service is registered or a gRPC
+// request arrives.
 type GRPCHandler struct {
-	server *grpc.Server
+	options func() []grpc.ServerOption
+	once    sync.Once
+	server  *grpc.Server
+	created atomic.Bool  // set once server is
+	active  atomic.Int64 // calls being served
 }
 
 func NewGRPCHandler(opts ...grpc.ServerOption) *GRPCHandler {
-	server := grpc.NewServer(opts...)
-	reflection.Register(server) // Enable reflection for debugging
 	return &GRPCHandler{
-		server: server,
+		options: func() []grpc.ServerOption { return opts },
 	}
 }
 
+// Server returns the gRPC server, creating it with the handler options on
+// the first call
+func (h *GRPCHandler) Server() *grpc.Server {
+	h.once.Do(func() {
+		h.server = grpc.NewServer(h.options()...)
+		reflection.Register(h.server) // Enable reflection for debugging
+		h.created.Store(true)
+	})
+	return h.server
+}
+
+// shutdown gracefully stops the gRPC server if it was created. Calls still
+// running when ctx is done are canceled.
+func (h *GRPCHandler) shutdown(ctx context.Context) error {
+	if !h.created.Load() {
+		return nil
+	}
+
+	// GracefulStop cannot drain calls served through ServeHTTP, so wait for
+	// them first, polling like http.Server.Shutdown does
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for h.active.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			h.server.Stop()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	h.server.GracefulStop()
+	return nil
+}
+
+// DetectProtocol reports whether r is a gRPC call: a request with a gRPC
+// content type. HTTP/1.x requests are detected too, so Handle can explain
+// why they fail instead of them falling through to a 404. Requests asking
+// for a WebSocket upgrade never are, so they are left to the WebSocket
+// handler whatever the protocol order. gRPC-web requests, which work over
+// HTTP/1.x, are left to the routes too, e.g. one serving a gRPC-web bridge.
 func (h *GRPCHandler) DetectProtocol(r *http.Request) bool {
-	return r.ProtoMajor == 2 && strings.Contains(r.Header.Get("Content-Type"), "application/grpc")
+	if websocket.IsWebSocketUpgrade(r) {
+		return false
+	}
+	contentType := r.Header.Get("Content-Type")
+	return strings.Contains(contentType, "application/grpc") &&
+		!strings.Contains(contentType, "application/grpc-web")
 }
 
+// Handle serves a gRPC call. gRPC requires HTTP/2, so calls arriving over
+// HTTP/1.x, e.g. through a proxy that downgraded them, get 426 Upgrade
+// Required with an Unavailable gRPC status naming the fix.
 func (h *GRPCHandler) Handle(w http.ResponseWriter, r *http.Request) {
-	h.server.ServeHTTP(w, r)
+	if r.ProtoMajor != 2 {
+		respondGRPCNeedsHTTP2(w, r)
+		return
+	}
+	h.active.Add(1)
+	defer h.active.Add(-1)
+	h.Server().ServeHTTP(w, r)
+}
+
+// respondGRPCNeedsHTTP2 answers a gRPC call made over HTTP/1.x
+func respondGRPCNeedsHTTP2(w http.ResponseWriter, r *http.Request) {
+	upgrade, msg := "h2c", "gRPC requires HTTP/2: enable ServerConfig.EnableH2C for cleartext clients, or connect over TLS, and make sure proxies forward HTTP/2"
+	if r.TLS != nil {
+		upgrade, msg = "h2", "gRPC requires HTTP/2: negotiate h2 through ALPN and make sure proxies forward HTTP/2"
+	}
+
+	w.Header().Set("Upgrade", upgrade)
+	w.Header().Set("Connection", "Upgrade")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(codes.Unavailable)))
+	w.Header().Set("Grpc-Message", msg)
+	http.Error(w, msg, http.StatusUpgradeRequired)
+}
+
+// grpcServerOptions returns the options the gRPC server is created with: the
+// interceptors putting DB and Cache into call contexts, followed by
+// ServerConfig.GRPCServerOptions
+func (h *Handler) grpcServerOptions() []grpc.ServerOption {
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(h.unaryDependencies),
+		grpc.ChainStreamInterceptor(h.streamDependencies),
+	}
+	return append(opts, h.cfg.GRPCServerOptions...)
+}
+
+// unaryDependencies gives unary calls the same DB and Cache as HTTP requests,
+// see ContextDB and ContextCache
+func (h *Handler) unaryDependencies(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	return handler(h.withDependencies(ctx), req)
+}
+
+// streamDependencies gives streaming calls the same DB and Cache as HTTP
+// requests, see ContextDB and ContextCache
+func (h *Handler) streamDependencies(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return handler(srv, &contextStream{ServerStream: ss, ctx: h.withDependencies(ss.Context())})
 }
 
-// RegisterGRPCService registers a gRPC service with the handler
+// contextStream is a grpc.ServerStream with a replaced context
+type contextStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextStream) Context() context.Context {
+	return s.ctx
+}
+
+// RegisterGRPCService registers a gRPC service with the handler. The first
+// registration creates the gRPC server with ServerConfig.GRPCServerOptions,
+// so set them before registering services.
 func (h *Handler) RegisterGRPCService(sd *grpc.ServiceDesc, ss interface{}) {
-	h.grpcServer.RegisterService(sd, ss)
+	h.grpcHandler.Server().RegisterService(sd, ss)
+}
+
+// grpcCodeMapping maps gRPC codes to error codes and HTTP statuses, following
+// the mapping used by grpc-gateway
+var grpcCodeMapping = map[codes.Code]struct {
+	code   ErrorCode
+	status int
+}{
+	codes.InvalidArgument:    {ErrCodeBadRequest, http.StatusBadRequest},
+	codes.FailedPrecondition: {ErrCodeBadRequest, http.StatusBadRequest},
+	codes.OutOfRange:         {ErrCodeBadRequest, http.StatusBadRequest},
+	codes.AlreadyExists:      {ErrCodeBadRequest, http.StatusConflict},
+	codes.Aborted:            {ErrCodeBadRequest, http.StatusConflict},
+	codes.NotFound:           {ErrCodeNotFound, http.StatusNotFound},
+	codes.Unauthenticated:    {ErrCodeUnauthorized, http.StatusUnauthorized},
+	codes.PermissionDenied:   {ErrCodeUnauthorized, http.StatusForbidden},
+	codes.Canceled:           {ErrCodeBadRequest, StatusClientClosedRequest},
+	codes.DeadlineExceeded:   {ErrCodeTimeout, http.StatusGatewayTimeout},
+	codes.Unavailable:        {ErrCodeServiceUnavailable, http.StatusServiceUnavailable},
+	codes.ResourceExhausted:  {ErrCodeInternal, http.StatusTooManyRequests},
+	codes.Unimplemented:      {ErrCodeInternal, http.StatusNotImplemented},
+}
+
+// FromGRPCError converts an error returned by a gRPC call into an AppError
+// with the matching error code and HTTP status. The gRPC status message
+// becomes the error message. AppErrors are returned unchanged, other errors
+// map to an internal error, and nil maps to nil.
+func FromGRPCError(err error) *AppError {
+	if err == nil {
+		return nil
+	}
+	var appErr *AppError
+	if errors.As(err, &appErr) {
+		return appErr
+	}
+
+	st, ok := status.FromError(err)
+	if !ok {
+		return NewError(ErrCodeInternal, "An internal error occurred").WithError(err)
+	}
+
+	m, ok := grpcCodeMapping[st.Code()]
+	if !ok {
+		return NewError(ErrCodeInternal, st.Message()).WithError(err)
+	}
+	appErr = NewError(m.code, st.Message()).WithError(err)
+	appErr.StatusCode = m.status
+	return appErr
+}
+
+// ToGRPCStatus converts an AppError into a gRPC status, for gRPC handlers
+// that return ags errors. The gRPC code is derived from the HTTP status, so
+// AppErrors produced by FromGRPCError keep their original code.
+func ToGRPCStatus(e *AppError) *status.Status {
+	if e == nil {
+		return status.New(codes.OK, "")
+	}
+
+	var code codes.Code
+	switch e.StatusCode {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusConflict:
+		code = codes.AlreadyExists
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case StatusClientClosedRequest:
+		code = codes.Canceled
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	case http.StatusGatewayTimeout:
+		code = codes.DeadlineExceeded
+	default:
+		code = codes.Internal
+	}
+	return status.New(code, e.Message)
 }
@@ -0,0 +1,198 @@
+package ags_test
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/getangry/ags"
+	"github.com/getangry/ags/pkg/cache"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gotest.tools/assert"
+)
+
+func TestFromGRPCError(t *testing.T) {
+	tests := []struct {
+		grpcCode   codes.Code
+		wantCode   ags.ErrorCode
+		wantStatus int
+	}{
+		{codes.InvalidArgument, ags.ErrCodeBadRequest, http.StatusBadRequest},
+		{codes.NotFound, ags.ErrCodeNotFound, http.StatusNotFound},
+		{codes.AlreadyExists, ags.ErrCodeBadRequest, http.StatusConflict},
+		{codes.Unauthenticated, ags.ErrCodeUnauthorized, http.StatusUnauthorized},
+		{codes.PermissionDenied, ags.ErrCodeUnauthorized, http.StatusForbidden},
+		{codes.DeadlineExceeded, ags.ErrCodeTimeout, http.StatusGatewayTimeout},
+		{codes.Unavailable, ags.ErrCodeServiceUnavailable, http.StatusServiceUnavailable},
+		{codes.Internal, ags.ErrCodeInternal, http.StatusInternalServerError},
+		{codes.DataLoss, ags.ErrCodeInternal, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		err := status.Error(tt.grpcCode, "rpc failed")
+		appErr := ags.FromGRPCError(err)
+
+		assert.Equal(t, tt.wantCode, appErr.Code, "grpc code %d", tt.grpcCode)
+		assert.Equal(t, tt.wantStatus, appErr.StatusCode, "grpc code %d", tt.grpcCode)
+		assert.Equal(t, "rpc failed", appErr.Message)
+		assert.Assert(t, errors.Is(appErr.MainError, err))
+	}
+
+	assert.Assert(t, ags.FromGRPCError(nil) == nil)
+
+	plain := ags.FromGRPCError(errors.New("boom"))
+	assert.Equal(t, ags.ErrCodeInternal, plain.Code)
+
+	original := ags.NewError(ags.ErrCodeNotFound, "missing")
+	assert.Equal(t, original, ags.FromGRPCError(original))
+}
+
+func TestToGRPCStatus(t *testing.T) {
+	tests := []struct {
+		err      *ags.AppError
+		wantCode codes.Code
+	}{
+		{ags.NewError(ags.ErrCodeValidation, "bad"), codes.InvalidArgument},
+		{ags.NewError(ags.ErrCodeBadRequest, "bad"), codes.InvalidArgument},
+		{ags.NewError(ags.ErrCodeNotFound, "missing"), codes.NotFound},
+		{ags.NewError(ags.ErrCodeUnauthorized, "who"), codes.Unauthenticated},
+		{ags.NewError(ags.ErrCodeInternal, "oops"), codes.Internal},
+	}
+
+	for _, tt := range tests {
+		st := ags.ToGRPCStatus(tt.err)
+		assert.Equal(t, tt.wantCode, st.Code(), "error code %s", tt.err.Code)
+		assert.Equal(t, tt.err.Message, st.Message())
+	}
+
+	// Round trips keep the gRPC code
+	for _, code := range []codes.Code{codes.NotFound, codes.PermissionDenied, codes.AlreadyExists, codes.DeadlineExceeded, codes.Unavailable} {
+		st := ags.ToGRPCStatus(ags.FromGRPCError(status.Error(code, "x")))
+		assert.Equal(t, code, st.Code())
+	}
+}
+
+func TestRegisterGRPCService_OptionsSetAfterNewHandler(t *testing.T) {
+	cfg := &ags.ServerConfig{Log: &mockLogger{}, EnableH2C: true}
+	h := ags.NewHandler(cfg)
+
+	var intercepted atomic.Value
+	cfg.GRPCServerOptions = []grpc.ServerOption{
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			intercepted.Store(info.FullMethod)
+			return handler(ctx, req)
+		}),
+	}
+	h.RegisterGRPCService(&userServiceDesc, testUserServer{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.SetContext(ctx)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ln) }()
+	defer func() {
+		cancel()
+		assert.NilError(t, <-served)
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///"+ln.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	req, err := structpb.NewStruct(map[string]interface{}{"name": "ada"})
+	assert.NilError(t, err)
+	resp := new(structpb.Struct)
+	assert.NilError(t, conn.Invoke(context.Background(), "/test.UserService/CreateUser", req, resp))
+
+	assert.Equal(t, "ada", resp.GetFields()["name"].GetStringValue())
+	assert.Equal(t, "/test.UserService/CreateUser", intercepted.Load())
+}
+
+func TestGRPCHandler_HTTP1(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.RegisterGRPCService(&userServiceDesc, testUserServer{})
+
+	tests := []struct {
+		name        string
+		tls         bool
+		wantUpgrade string
+		wantMessage string
+	}{
+		{name: "cleartext", wantUpgrade: "h2c", wantMessage: "enable ServerConfig.EnableH2C"},
+		{name: "tls", tls: true, wantUpgrade: "h2", wantMessage: "negotiate h2 through ALPN"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			url := "http://example.com/test.UserService/CreateUser"
+			if tt.tls {
+				url = "https://example.com/test.UserService/CreateUser"
+			}
+			req := httptest.NewRequest(http.MethodPost, url, strings.NewReader("\x00\x00\x00\x00\x00"))
+			req.Header.Set("Content-Type", "application/grpc")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, http.StatusUpgradeRequired, rec.Code)
+			assert.Equal(t, tt.wantUpgrade, rec.Header().Get("Upgrade"))
+			assert.Equal(t, "14", rec.Header().Get("Grpc-Status"))
+			assert.Assert(t, strings.Contains(rec.Header().Get("Grpc-Message"), tt.wantMessage))
+			assert.Assert(t, strings.HasPrefix(rec.Body.String(), "gRPC requires HTTP/2"), "body %q", rec.Body.String())
+		})
+	}
+}
+
+// dbUserServer reports the dependencies found in the call context
+type dbUserServer struct {
+	db    chan *sql.DB
+	cache chan cache.Cacher
+}
+
+func (s dbUserServer) CreateUser(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	s.db <- ags.ContextDB(ctx)
+	s.cache <- ags.ContextCache(ctx)
+	return structpb.NewStruct(map[string]interface{}{"id": "u1"})
+}
+
+func TestRegisterGRPCService_ContextDependencies(t *testing.T) {
+	// Shutdown closes the DB, which a zero sql.DB does not support
+	db, c := sql.OpenDB(closeConnector{rec: &shutdownRecorder{}}), &mockCache{}
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}, DB: db, Cache: c, EnableH2C: true})
+	srv := dbUserServer{db: make(chan *sql.DB, 1), cache: make(chan cache.Cacher, 1)}
+	h.RegisterGRPCService(&userServiceDesc, srv)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	h.SetContext(ctx)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ln) }()
+	defer func() {
+		cancel()
+		assert.NilError(t, <-served)
+	}()
+
+	conn, err := grpc.NewClient("passthrough:///"+ln.Addr().String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NilError(t, err)
+	defer conn.Close()
+
+	req, err := structpb.NewStruct(map[string]interface{}{"name": "ada"})
+	assert.NilError(t, err)
+	assert.NilError(t, conn.Invoke(context.Background(), "/test.UserService/CreateUser", req, new(structpb.Struct)))
+
+	assert.Assert(t, <-srv.db == db, "gRPC call context does not carry ServerConfig.DB")
+	assert.Assert(t, <-srv.cache == cache.Cacher(c), "gRPC call context does not carry ServerConfig.Cache")
+}
@@ -0,0 +1,56 @@
+package ags
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Host returns a group whose routes only match requests for the given host,
+// e.g. h.Host("api.example.com").Get("/v1/users", listUsers). A pattern
+// starting with "*." matches any subdomain, so "*.example.com" matches
+// "eu.example.com" but not "example.com". Hosts are compared without their
+// port and case-insensitively. Routes for a matching host take precedence
+// over routes registered on the handler itself, which requests for other
+// hosts fall through to.
+func (h *Handler) Host(pattern string, mw ...Middleware) *Group {
+	pattern = strings.TrimSuffix(strings.ToLower(pattern), ".")
+	if pattern == "" || strings.ContainsAny(pattern, "/ ") {
+		panic(fmt.Sprintf("ags: invalid host pattern %q", pattern))
+	}
+	group := h.Group("/", mw...)
+	group.host = pattern
+	return group
+}
+
+// hostMatches reports whether host matches the host pattern, see Host
+func hostMatches(pattern, host string) bool {
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return len(host) > len(suffix) && strings.HasSuffix(host, suffix)
+	}
+	return pattern == host
+}
+
+// sortHostPatterns orders exact hosts before wildcards and longer wildcards
+// before shorter ones, so the most specific matching pattern wins
+func sortHostPatterns(patterns []string) {
+	sort.SliceStable(patterns, func(i, j int) bool {
+		wi, wj := strings.HasPrefix(patterns[i], "*"), strings.HasPrefix(patterns[j], "*")
+		if wi != wj {
+			return wj
+		}
+		return wi && len(patterns[i]) > len(patterns[j])
+	})
+}
+
+// requestHost returns the host of r in lower case, without port and trailing
+// dot
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
@@ -0,0 +1,59 @@
+package ags_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestHandler_Host(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Host("api.example.com").Get("/v1/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("api " + ags.PathParam(r, "id")))
+	})
+	h.Host("*.example.com").Group("/v1").Get("/status", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("tenant status"))
+	})
+	h.Host("*.example.com").Post("/v1/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("tenant post"))
+	})
+	h.Get("/v1/status", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("any status"))
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		host       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "exact host", method: http.MethodGet, host: "api.example.com", path: "/v1/users/5", wantStatus: http.StatusOK, wantBody: "api 5"},
+		{name: "host with port", method: http.MethodGet, host: "API.example.com:8080", path: "/v1/users/5", wantStatus: http.StatusOK, wantBody: "api 5"},
+		{name: "wildcard subdomain", method: http.MethodGet, host: "eu.example.com", path: "/v1/status", wantStatus: http.StatusOK, wantBody: "tenant status"},
+		{name: "exact host falls through to wildcard", method: http.MethodPost, host: "api.example.com", path: "/v1/users/5", wantStatus: http.StatusOK, wantBody: "tenant post"},
+		{name: "method not allowed across hosts", method: http.MethodDelete, host: "api.example.com", path: "/v1/users/5", wantStatus: http.StatusMethodNotAllowed},
+		{name: "wildcard needs a subdomain", method: http.MethodGet, host: "example.com", path: "/v1/status", wantStatus: http.StatusOK, wantBody: "any status"},
+		{name: "other host falls through", method: http.MethodGet, host: "other.org", path: "/v1/status", wantStatus: http.StatusOK, wantBody: "any status"},
+		{name: "other host not found", method: http.MethodGet, host: "other.org", path: "/v1/users/5", wantStatus: http.StatusNotFound},
+		{name: "host in path not matched", method: http.MethodGet, host: "other.org", path: "/api.example.com/v1/users/5", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			req.Host = tt.host
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, rec.Code, tt.wantStatus)
+			if tt.wantBody != "" {
+				assert.Equal(t, rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
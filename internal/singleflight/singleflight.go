@@ -0,0 +1,84 @@
+// Package singleflight provides a duplicate call suppression mechanism so
+// that concurrent callers asking for the same key share a single execution.
+package singleflight
+
+import (
+	"fmt"
+	"runtime/debug"
+	"sync"
+)
+
+// PanicError is returned to callers waiting on a key whose function panicked.
+// The caller that ran the function receives the original panic instead.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error implements the error interface
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("singleflight: function panicked: %v\n\n%s", p.Value, p.Stack)
+}
+
+// call is an in-flight or completed Do call
+type call struct {
+	wg  sync.WaitGroup
+	val interface{}
+	err error
+}
+
+// Group represents a class of work in which duplicate calls for the same
+// key are suppressed. The zero value is ready to use.
+type Group struct {
+	mu sync.Mutex
+	m  map[string]*call
+}
+
+// Do executes fn for key, making sure only one execution is in flight at a
+// time. Duplicate callers wait for the original to complete and receive the
+// same results. The shared result reports whether v was given to more than
+// one caller. If fn panics, the panic propagates to the caller that ran it
+// and waiting callers receive a *PanicError.
+func (g *Group) Do(key string, fn func() (interface{}, error)) (v interface{}, err error, shared bool) {
+	g.mu.Lock()
+	if g.m == nil {
+		g.m = make(map[string]*call)
+	}
+	if c, ok := g.m[key]; ok {
+		g.mu.Unlock()
+		c.wg.Wait()
+		return c.val, c.err, true
+	}
+
+	c := new(call)
+	c.wg.Add(1)
+	g.m[key] = c
+	g.mu.Unlock()
+
+	g.doCall(c, key, fn)
+	return c.val, c.err, false
+}
+
+// doCall runs fn and releases the waiters, making sure they never observe
+// an unset result when fn panics
+func (g *Group) doCall(c *call, key string, fn func() (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.val = nil
+			c.err = &PanicError{Value: r, Stack: debug.Stack()}
+			g.finish(c, key)
+			panic(r)
+		}
+	}()
+
+	c.val, c.err = fn()
+	g.finish(c, key)
+}
+
+// finish forgets the key and wakes up the waiting callers
+func (g *Group) finish(c *call, key string) {
+	g.mu.Lock()
+	delete(g.m, key)
+	g.mu.Unlock()
+	c.wg.Done()
+}
@@ -0,0 +1,102 @@
+package singleflight
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDo(t *testing.T) {
+	var g Group
+	v, err, shared := g.Do("key", func() (interface{}, error) {
+		return "value", nil
+	})
+	if v != "value" || err != nil || shared {
+		t.Errorf("Do() = %v, %v, %v; want value, nil, false", v, err, shared)
+	}
+}
+
+func TestDoDeduplicates(t *testing.T) {
+	var g Group
+	var calls int32
+	release := make(chan struct{})
+
+	const callers = 10
+	var started, done sync.WaitGroup
+	started.Add(callers)
+	done.Add(callers)
+	for i := 0; i < callers; i++ {
+		go func() {
+			defer done.Done()
+			started.Done()
+			v, err, _ := g.Do("key", func() (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "value", nil
+			})
+			if v != "value" || err != nil {
+				t.Errorf("Do() = %v, %v", v, err)
+			}
+		}()
+	}
+
+	started.Wait()
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	done.Wait()
+
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("Expected fn to run once, ran %d times", n)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	entered := make(chan struct{})
+	release := make(chan struct{})
+
+	// The caller running fn receives the original panic
+	ownerPanic := make(chan interface{}, 1)
+	go func() {
+		defer func() { ownerPanic <- recover() }()
+		g.Do("key", func() (interface{}, error) {
+			close(entered)
+			<-release
+			panic("boom")
+		})
+	}()
+
+	<-entered
+	waiterResult := make(chan error, 1)
+	go func() {
+		v, err, _ := g.Do("key", func() (interface{}, error) {
+			return "unexpected", nil
+		})
+		if v != nil {
+			t.Errorf("Expected no value for a panicked call, got %v", v)
+		}
+		waiterResult <- err
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if r := <-ownerPanic; r != "boom" {
+		t.Errorf("Expected owner to panic with boom, got %v", r)
+	}
+
+	// Waiters get an error instead of a successful nil value
+	err := <-waiterResult
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Errorf("Expected waiter to receive a PanicError, got %v", err)
+	}
+
+	// The key is released so later calls run again
+	v, err, _ := g.Do("key", func() (interface{}, error) { return "again", nil })
+	if v != "again" || err != nil {
+		t.Errorf("Do() after panic = %v, %v", v, err)
+	}
+}
@@ -2,8 +2,12 @@ package ags
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
+	"os"
+	"sync"
 	"time"
 
 	"github.com/getangry/ags/pkg/middleware"
@@ -17,8 +21,13 @@ const (
 	InfoLevel
 	WarnLevel
 	ErrorLevel
+	FatalLevel
 )
 
+// exitFunc is called by Fatal after the message has been logged.
+// It is a variable so tests can intercept the exit.
+var exitFunc = os.Exit
+
 // String returns the string representation of the log level
 func (l LogLevel) String() string {
 	switch l {
@@ -30,12 +39,28 @@ func (l LogLevel) String() string {
 		return "WARN"
 	case ErrorLevel:
 		return "ERROR"
+	case FatalLevel:
+		return "FATAL"
 	default:
 		return "UNKNOWN"
 	}
 }
 
-// Logger interface for custom logging implementations
+// Logger interface for custom logging implementations.
+//
+// Fatal and Panic were added after the initial release, so existing custom
+// implementations need to provide them. A minimal implementation logs the
+// message at its highest level and then calls os.Exit(1) or panic(msg)
+// respectively, mirroring DefaultLogger. Enabled was added later still; a
+// minimal implementation returns level >= GetLevel().
+//
+// Arguments are evaluated and boxed into the variadic fields even when the
+// entry is dropped, so hot paths with expensive fields guard them with
+// Enabled:
+//
+//	if logger.Enabled(ags.DebugLevel) {
+//		logger.Debug("cache state", "keys", c.Keys(), "size", c.Size())
+//	}
 type Logger interface {
 	WithFields(fields map[string]interface{}) Logger
 	WithContext(ctx context.Context) Logger
@@ -43,8 +68,12 @@ type Logger interface {
 	Info(msg string, fields ...interface{})
 	Warn(msg string, fields ...interface{})
 	Error(msg string, fields ...interface{})
+	Fatal(msg string, fields ...interface{})
+	Panic(msg string, fields ...interface{})
 	GetLevel() LogLevel
 	SetLevel(level LogLevel)
+	// Enabled reports whether entries at level are emitted
+	Enabled(level LogLevel) bool
 }
 
 // DefaultLogger provides a basic implementation of the Logger interface
@@ -52,6 +81,7 @@ type DefaultLogger struct {
 	level  LogLevel
 	fields map[string]interface{}
 	ctx    context.Context
+	out    *log.Logger // nil writes through the standard logger
 }
 
 // NewDefaultLogger creates a new default logger with specified log level
@@ -64,7 +94,7 @@ func NewDefaultLogger(level LogLevel) *DefaultLogger {
 }
 
 func (l *DefaultLogger) log(level LogLevel, msg string, fields ...interface{}) {
-	if level < l.level {
+	if !l.Enabled(level) {
 		return
 	}
 
@@ -90,14 +120,28 @@ func (l *DefaultLogger) log(level LogLevel, msg string, fields ...interface{}) {
 		}
 	}
 
+	if l.out != nil {
+		l.out.Println(logMsg)
+		return
+	}
 	log.Println(logMsg)
 }
 
+// SetOutput sends the entries of l to w, with the date and time prefix of
+// the standard logger, instead of the standard logger's output. Loggers
+// derived afterwards with WithFields and WithContext share it. Writes are
+// serialized, so w need not be safe for concurrent use; see
+// pkg/logwriter for a rotating file writer.
+func (l *DefaultLogger) SetOutput(w io.Writer) {
+	l.out = log.New(w, "", log.LstdFlags)
+}
+
 func (l *DefaultLogger) WithFields(fields map[string]interface{}) Logger {
 	newLogger := &DefaultLogger{
 		level:  l.level,
 		fields: make(map[string]interface{}),
 		ctx:    l.ctx,
+		out:    l.out,
 	}
 
 	// Copy existing fields
@@ -118,6 +162,7 @@ func (l *DefaultLogger) WithContext(ctx context.Context) Logger {
 		level:  l.level,
 		fields: l.fields,
 		ctx:    ctx,
+		out:    l.out,
 	}
 }
 
@@ -127,3 +172,357 @@ func (l *DefaultLogger) Warn(msg string, fields ...interface{})  { l.log(WarnLev
 func (l *DefaultLogger) Error(msg string, fields ...interface{}) { l.log(ErrorLevel, msg, fields...) }
 func (l *DefaultLogger) GetLevel() LogLevel                      { return l.level }
 func (l *DefaultLogger) SetLevel(level LogLevel)                 { l.level = level }
+func (l *DefaultLogger) Enabled(level LogLevel) bool             { return level >= l.level }
+
+// Fatal logs the message at FatalLevel and terminates the process with exit code 1
+func (l *DefaultLogger) Fatal(msg string, fields ...interface{}) {
+	l.log(FatalLevel, msg, fields...)
+	exitFunc(1)
+}
+
+// Panic logs the message at FatalLevel and then panics with the message
+func (l *DefaultLogger) Panic(msg string, fields ...interface{}) {
+	l.log(FatalLevel, msg, fields...)
+	panic(msg)
+}
+
+// JSONLogger writes each entry as a JSON object on its own line, with the
+// time, level, message and request id followed by the fields. Values are
+// encoded as JSON, so structured fields such as the error_details of
+// Handler.Error become nested objects; errors are written as their message
+// and values JSON cannot encode with fmt.Sprint.
+type JSONLogger struct {
+	out    *jsonOutput
+	fields map[string]interface{}
+	ctx    context.Context
+}
+
+// jsonOutput is the writer and level shared by a JSONLogger and the loggers
+// derived from it
+type jsonOutput struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level LogLevel
+}
+
+// NewJSONLogger creates a JSONLogger writing entries at level and above to w
+func NewJSONLogger(w io.Writer, level LogLevel) *JSONLogger {
+	return &JSONLogger{
+		out: &jsonOutput{w: w, level: level},
+		ctx: context.Background(),
+	}
+}
+
+func (l *JSONLogger) log(level LogLevel, msg string, fields ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	entry := make(map[string]interface{}, 4+len(l.fields)+len(fields)/2)
+	for k, v := range l.fields {
+		entry[k] = jsonValue(v)
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		entry[fmt.Sprint(fields[i])] = jsonValue(fields[i+1])
+	}
+	entry["time"] = time.Now().Format(time.RFC3339)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+	if reqID := middleware.GetReqID(l.ctx); reqID != "" {
+		entry["request_id"] = reqID
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{"level": level.String(), "msg": msg, "log_error": err.Error()})
+	}
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	_, _ = l.out.w.Write(append(data, '\n'))
+}
+
+// jsonValue prepares a field value for encoding
+func jsonValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil:
+		return nil
+	case json.Marshaler:
+		return v
+	case error:
+		return v.Error()
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprint(v)
+	}
+	return v
+}
+
+func (l *JSONLogger) WithFields(fields map[string]interface{}) Logger {
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &JSONLogger{out: l.out, fields: merged, ctx: l.ctx}
+}
+
+func (l *JSONLogger) WithContext(ctx context.Context) Logger {
+	return &JSONLogger{out: l.out, fields: l.fields, ctx: ctx}
+}
+
+func (l *JSONLogger) Debug(msg string, fields ...interface{}) { l.log(DebugLevel, msg, fields...) }
+func (l *JSONLogger) Info(msg string, fields ...interface{})  { l.log(InfoLevel, msg, fields...) }
+func (l *JSONLogger) Warn(msg string, fields ...interface{})  { l.log(WarnLevel, msg, fields...) }
+func (l *JSONLogger) Error(msg string, fields ...interface{}) { l.log(ErrorLevel, msg, fields...) }
+
+// Fatal logs the message at FatalLevel and terminates the process with exit code 1
+func (l *JSONLogger) Fatal(msg string, fields ...interface{}) {
+	l.log(FatalLevel, msg, fields...)
+	exitFunc(1)
+}
+
+// Panic logs the message at FatalLevel and then panics with the message
+func (l *JSONLogger) Panic(msg string, fields ...interface{}) {
+	l.log(FatalLevel, msg, fields...)
+	panic(msg)
+}
+
+func (l *JSONLogger) GetLevel() LogLevel {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	return l.out.level
+}
+
+// SetLevel sets the minimum level written by l and the loggers derived from it
+func (l *JSONLogger) SetLevel(level LogLevel) {
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.level = level
+}
+
+func (l *JSONLogger) Enabled(level LogLevel) bool {
+	return level >= l.GetLevel()
+}
+
+// SampledLogger wraps a Logger and limits Debug and Info output to the first
+// N entries per interval. Warn and above are never sampled. Entries dropped by
+// the sampler are counted and can be read with Dropped.
+type SampledLogger struct {
+	inner   Logger
+	sampler *logSampler
+}
+
+// logSampler holds the sampling state shared by a SampledLogger and the
+// loggers derived from it with WithFields and WithContext.
+type logSampler struct {
+	mu       sync.Mutex
+	first    int
+	interval time.Duration
+	start    time.Time
+	count    int
+	dropped  uint64
+	now      func() time.Time
+}
+
+// NewSampledLogger creates a logger that passes through the first entries
+// per interval to inner and drops the rest
+func NewSampledLogger(inner Logger, first int, interval time.Duration) *SampledLogger {
+	return &SampledLogger{
+		inner: inner,
+		sampler: &logSampler{
+			first:    first,
+			interval: interval,
+			now:      time.Now,
+		},
+	}
+}
+
+// allow reports whether another entry may be emitted in the current interval
+func (s *logSampler) allow() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	if now.Sub(s.start) >= s.interval {
+		s.start = now
+		s.count = 0
+	}
+
+	if s.count < s.first {
+		s.count++
+		return true
+	}
+	s.dropped++
+	return false
+}
+
+// Dropped returns the number of entries dropped by the sampler so far
+func (l *SampledLogger) Dropped() uint64 {
+	l.sampler.mu.Lock()
+	defer l.sampler.mu.Unlock()
+	return l.sampler.dropped
+}
+
+func (l *SampledLogger) WithFields(fields map[string]interface{}) Logger {
+	return &SampledLogger{inner: l.inner.WithFields(fields), sampler: l.sampler}
+}
+
+func (l *SampledLogger) WithContext(ctx context.Context) Logger {
+	return &SampledLogger{inner: l.inner.WithContext(ctx), sampler: l.sampler}
+}
+
+func (l *SampledLogger) Debug(msg string, fields ...interface{}) {
+	if l.inner.Enabled(DebugLevel) && l.sampler.allow() {
+		l.inner.Debug(msg, fields...)
+	}
+}
+
+func (l *SampledLogger) Info(msg string, fields ...interface{}) {
+	if l.inner.Enabled(InfoLevel) && l.sampler.allow() {
+		l.inner.Info(msg, fields...)
+	}
+}
+
+func (l *SampledLogger) Warn(msg string, fields ...interface{})  { l.inner.Warn(msg, fields...) }
+func (l *SampledLogger) Error(msg string, fields ...interface{}) { l.inner.Error(msg, fields...) }
+func (l *SampledLogger) Fatal(msg string, fields ...interface{}) { l.inner.Fatal(msg, fields...) }
+func (l *SampledLogger) Panic(msg string, fields ...interface{}) { l.inner.Panic(msg, fields...) }
+func (l *SampledLogger) GetLevel() LogLevel                      { return l.inner.GetLevel() }
+func (l *SampledLogger) SetLevel(level LogLevel)                 { l.inner.SetLevel(level) }
+func (l *SampledLogger) Enabled(level LogLevel) bool             { return l.inner.Enabled(level) }
+
+// NopLogger discards every entry. Use it to silence a handler, e.g. in
+// libraries embedding ags: &ServerConfig{Log: NopLogger{}}. Like any Logger,
+// Fatal still exits and Panic still panics.
+type NopLogger struct{}
+
+func (NopLogger) WithFields(fields map[string]interface{}) Logger { return NopLogger{} }
+func (NopLogger) WithContext(ctx context.Context) Logger          { return NopLogger{} }
+func (NopLogger) Debug(msg string, fields ...interface{})         {}
+func (NopLogger) Info(msg string, fields ...interface{})          {}
+func (NopLogger) Warn(msg string, fields ...interface{})          {}
+func (NopLogger) Error(msg string, fields ...interface{})         {}
+func (NopLogger) Fatal(msg string, fields ...interface{})         { exitFunc(1) }
+func (NopLogger) Panic(msg string, fields ...interface{})         { panic(msg) }
+func (NopLogger) GetLevel() LogLevel                              { return FatalLevel }
+func (NopLogger) SetLevel(level LogLevel)                         {}
+func (NopLogger) Enabled(level LogLevel) bool                     { return false }
+
+// LogEntry is an entry recorded by a CaptureLogger. Fields merges the fields
+// added with WithFields and the key/value pairs passed with the message;
+// keys that are not strings are formatted with fmt.Sprint.
+type LogEntry struct {
+	Level   LogLevel
+	Message string
+	Fields  map[string]interface{}
+	Context context.Context
+}
+
+// CaptureLogger records entries in memory for tests to inspect with
+// Entries. Loggers derived with WithFields and WithContext record into the
+// same list. Fatal records its entry without exiting; Panic records it and
+// panics.
+type CaptureLogger struct {
+	store  *captureStore
+	fields map[string]interface{}
+	ctx    context.Context
+}
+
+// captureStore holds the entries shared by a CaptureLogger and the loggers
+// derived from it
+type captureStore struct {
+	mu      sync.Mutex
+	level   LogLevel
+	entries []LogEntry
+}
+
+// NewCaptureLogger returns a CaptureLogger recording entries of every level
+func NewCaptureLogger() *CaptureLogger {
+	return &CaptureLogger{
+		store: &captureStore{level: DebugLevel},
+		ctx:   context.Background(),
+	}
+}
+
+// Entries returns a copy of the entries recorded so far, oldest first
+func (l *CaptureLogger) Entries() []LogEntry {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	return append([]LogEntry(nil), l.store.entries...)
+}
+
+func (l *CaptureLogger) record(level LogLevel, msg string, fields ...interface{}) {
+	entry := LogEntry{
+		Level:   level,
+		Message: msg,
+		Fields:  make(map[string]interface{}, len(l.fields)+len(fields)/2),
+		Context: l.ctx,
+	}
+	for k, v := range l.fields {
+		entry.Fields[k] = v
+	}
+	for i := 0; i+1 < len(fields); i += 2 {
+		entry.Fields[fmt.Sprint(fields[i])] = fields[i+1]
+	}
+
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	if level >= l.store.level {
+		l.store.entries = append(l.store.entries, entry)
+	}
+}
+
+func (l *CaptureLogger) WithFields(fields map[string]interface{}) Logger {
+	merged := make(map[string]interface{}, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &CaptureLogger{store: l.store, fields: merged, ctx: l.ctx}
+}
+
+func (l *CaptureLogger) WithContext(ctx context.Context) Logger {
+	return &CaptureLogger{store: l.store, fields: l.fields, ctx: ctx}
+}
+
+func (l *CaptureLogger) Debug(msg string, fields ...interface{}) {
+	l.record(DebugLevel, msg, fields...)
+}
+func (l *CaptureLogger) Info(msg string, fields ...interface{}) { l.record(InfoLevel, msg, fields...) }
+func (l *CaptureLogger) Warn(msg string, fields ...interface{}) { l.record(WarnLevel, msg, fields...) }
+func (l *CaptureLogger) Error(msg string, fields ...interface{}) {
+	l.record(ErrorLevel, msg, fields...)
+}
+func (l *CaptureLogger) Fatal(msg string, fields ...interface{}) {
+	l.record(FatalLevel, msg, fields...)
+}
+
+// Panic records the entry at FatalLevel and then panics with the message
+func (l *CaptureLogger) Panic(msg string, fields ...interface{}) {
+	l.record(FatalLevel, msg, fields...)
+	panic(msg)
+}
+
+func (l *CaptureLogger) GetLevel() LogLevel {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	return l.store.level
+}
+
+// SetLevel sets the minimum level recorded by l and the loggers sharing its
+// entries
+func (l *CaptureLogger) SetLevel(level LogLevel) {
+	l.store.mu.Lock()
+	defer l.store.mu.Unlock()
+	l.store.level = level
+}
+
+// Enabled reports whether l records entries at level
+func (l *CaptureLogger) Enabled(level LogLevel) bool {
+	return level >= l.GetLevel()
+}
@@ -0,0 +1,259 @@
+package ags
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultLogger_Fatal(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	exitCode := -1
+	exitFunc = func(code int) {
+		if !strings.Contains(buf.String(), "[FATAL]") {
+			t.Errorf("expected fatal message to be logged before exit, got %q", buf.String())
+		}
+		exitCode = code
+	}
+	defer func() { exitFunc = os.Exit }()
+
+	NewDefaultLogger(ErrorLevel).Fatal("cannot start", "error", "boom")
+
+	if exitCode != 1 {
+		t.Errorf("expected exit code 1, got %d", exitCode)
+	}
+	if !strings.Contains(buf.String(), "cannot start error=boom") {
+		t.Errorf("expected message and fields in output, got %q", buf.String())
+	}
+}
+
+func TestDefaultLogger_Panic(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	defer func() {
+		if r := recover(); r != "unrecoverable" {
+			t.Errorf("expected panic with message, got %v", r)
+		}
+		if !strings.Contains(buf.String(), "[FATAL]") || !strings.Contains(buf.String(), "unrecoverable") {
+			t.Errorf("expected panic message to be logged, got %q", buf.String())
+		}
+	}()
+
+	// Panic must record its message even at the most restrictive level
+	NewDefaultLogger(FatalLevel).Panic("unrecoverable")
+}
+
+// countingLogger counts entries per level and otherwise behaves like DefaultLogger
+type countingLogger struct {
+	*DefaultLogger
+	counts map[LogLevel]int
+}
+
+func (l *countingLogger) Debug(msg string, fields ...interface{})         { l.counts[DebugLevel]++ }
+func (l *countingLogger) Info(msg string, fields ...interface{})          { l.counts[InfoLevel]++ }
+func (l *countingLogger) Warn(msg string, fields ...interface{})          { l.counts[WarnLevel]++ }
+func (l *countingLogger) WithFields(fields map[string]interface{}) Logger { return l }
+
+func TestSampledLogger(t *testing.T) {
+	inner := &countingLogger{DefaultLogger: NewDefaultLogger(DebugLevel), counts: make(map[LogLevel]int)}
+	sampled := NewSampledLogger(inner, 10, time.Second)
+
+	now := time.Now()
+	sampled.sampler.now = func() time.Time { return now }
+
+	for i := 0; i < 100; i++ {
+		sampled.Debug("request completed", "i", i)
+	}
+	sampled.Warn("never sampled")
+
+	if inner.counts[DebugLevel] != 10 {
+		t.Errorf("expected 10 debug entries, got %d", inner.counts[DebugLevel])
+	}
+	if inner.counts[WarnLevel] != 1 {
+		t.Errorf("expected warn entries to bypass sampling, got %d", inner.counts[WarnLevel])
+	}
+	if sampled.Dropped() != 90 {
+		t.Errorf("expected 90 dropped entries, got %d", sampled.Dropped())
+	}
+
+	// A new interval resets the budget, including for derived loggers
+	now = now.Add(time.Second)
+	derived := sampled.WithFields(map[string]interface{}{"component": "test"})
+	for i := 0; i < 20; i++ {
+		derived.Info("request completed")
+	}
+
+	if inner.counts[InfoLevel] != 10 {
+		t.Errorf("expected 10 info entries in the new interval, got %d", inner.counts[InfoLevel])
+	}
+	if sampled.Dropped() != 100 {
+		t.Errorf("expected 100 dropped entries, got %d", sampled.Dropped())
+	}
+}
+
+func TestCaptureLogger(t *testing.T) {
+	logger := NewCaptureLogger()
+	logger.Info("server started", "port", 7841)
+
+	type ctxKey struct{}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "req")
+	derived := logger.WithFields(map[string]interface{}{"component": "auth"}).WithContext(ctx)
+	derived.Warn("token expiring", "user", "ada")
+	derived.Error("login failed", "error", "bad password", 42, "non-string key", "dangling")
+
+	func() {
+		defer func() {
+			if r := recover(); r != "invariant broken" {
+				t.Errorf("expected panic with message, got %v", r)
+			}
+		}()
+		logger.Panic("invariant broken")
+	}()
+
+	logger.SetLevel(WarnLevel)
+	logger.Debug("dropped")
+	derived.Info("dropped too")
+
+	want := []LogEntry{
+		{Level: InfoLevel, Message: "server started", Fields: map[string]interface{}{"port": 7841}},
+		{Level: WarnLevel, Message: "token expiring", Fields: map[string]interface{}{"component": "auth", "user": "ada"}},
+		{Level: ErrorLevel, Message: "login failed", Fields: map[string]interface{}{"component": "auth", "error": "bad password", "42": "non-string key"}},
+		{Level: FatalLevel, Message: "invariant broken", Fields: map[string]interface{}{}},
+	}
+	got := logger.Entries()
+	if len(got) != len(want) {
+		t.Fatalf("expected %d entries, got %d: %+v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i].Level != want[i].Level || got[i].Message != want[i].Message || !reflect.DeepEqual(got[i].Fields, want[i].Fields) {
+			t.Errorf("entry %d: expected %+v, got %+v", i, want[i], got[i])
+		}
+	}
+	if got[1].Context != ctx || got[0].Context != context.Background() {
+		t.Errorf("expected entries to carry the logger context")
+	}
+	if logger.GetLevel() != WarnLevel || derived.GetLevel() != WarnLevel {
+		t.Errorf("expected the level to be shared with derived loggers")
+	}
+}
+
+func TestNopLogger(t *testing.T) {
+	var logger Logger = NopLogger{}
+	logger.WithFields(map[string]interface{}{"a": 1}).WithContext(context.Background()).Error("ignored")
+
+	exitCode := -1
+	exitFunc = func(code int) { exitCode = code }
+	defer func() { exitFunc = os.Exit }()
+	logger.Fatal("stop")
+	if exitCode != 1 {
+		t.Errorf("expected Fatal to exit with code 1, got %d", exitCode)
+	}
+}
+
+func TestLogger_Enabled(t *testing.T) {
+	capture := NewCaptureLogger()
+	capture.SetLevel(WarnLevel)
+
+	loggers := []struct {
+		name   string
+		logger Logger
+	}{
+		{name: "default", logger: NewDefaultLogger(WarnLevel)},
+		{name: "default derived", logger: NewDefaultLogger(WarnLevel).WithFields(map[string]interface{}{"a": 1}).WithContext(context.Background())},
+		{name: "sampled", logger: NewSampledLogger(NewDefaultLogger(WarnLevel), 10, time.Second)},
+		{name: "capture", logger: capture},
+	}
+
+	want := map[LogLevel]bool{
+		DebugLevel: false,
+		InfoLevel:  false,
+		WarnLevel:  true,
+		ErrorLevel: true,
+		FatalLevel: true,
+	}
+	for _, tt := range loggers {
+		for level, enabled := range want {
+			if got := tt.logger.Enabled(level); got != enabled {
+				t.Errorf("%s: Enabled(%s) = %v, want %v", tt.name, level, got, enabled)
+			}
+		}
+
+		tt.logger.SetLevel(DebugLevel)
+		if !tt.logger.Enabled(DebugLevel) {
+			t.Errorf("%s: Enabled(DEBUG) = false after SetLevel(DEBUG)", tt.name)
+		}
+	}
+
+	for level := range want {
+		if (NopLogger{}).Enabled(level) {
+			t.Errorf("nop: Enabled(%s) = true, want false", level)
+		}
+	}
+}
+
+func TestDefaultLogger_SetOutput(t *testing.T) {
+	var std bytes.Buffer
+	log.SetOutput(&std)
+	defer log.SetOutput(os.Stderr)
+
+	var buf bytes.Buffer
+	logger := NewDefaultLogger(InfoLevel)
+	logger.SetOutput(&buf)
+	logger.WithFields(map[string]interface{}{"component": "billing"}).WithContext(context.Background()).Info("charged", "amount", 42)
+
+	if !strings.Contains(buf.String(), "charged component=billing amount=42") {
+		t.Errorf("expected the derived logger to write to the output, got %q", buf.String())
+	}
+	if std.Len() != 0 {
+		t.Errorf("expected nothing on the standard logger, got %q", std.String())
+	}
+}
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := NewJSONLogger(&buf, InfoLevel)
+	logger.Debug("dropped")
+	logger.WithFields(map[string]interface{}{"component": "auth"}).
+		WithContext(context.Background()).
+		Warn("login failed", "attempts", 3, "error", errors.New("bad password"), "tags", []string{"a", "b"}, "fn", func() {})
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected 1 line, got %q", buf.String())
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("line is not JSON: %v", err)
+	}
+	want := map[string]interface{}{
+		"level":     "WARN",
+		"msg":       "login failed",
+		"component": "auth",
+		"attempts":  float64(3),
+		"error":     "bad password",
+		"tags":      []interface{}{"a", "b"},
+	}
+	for k, v := range want {
+		if !reflect.DeepEqual(entry[k], v) {
+			t.Errorf("%s = %#v, want %#v", k, entry[k], v)
+		}
+	}
+	if fn, ok := entry["fn"].(string); !ok || !strings.HasPrefix(fn, "0x") {
+		t.Errorf("unencodable value not formatted with fmt: %#v", entry["fn"])
+	}
+	if _, err := time.Parse(time.RFC3339, entry["time"].(string)); err != nil {
+		t.Errorf("time not RFC 3339: %v", err)
+	}
+}
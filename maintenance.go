@@ -0,0 +1,74 @@
+package ags
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maintenanceMode is the state set by SetMaintenance while it is on
+type maintenanceMode struct {
+	retryAfter time.Duration
+	allow      []string
+}
+
+// allows reports whether path is served during maintenance. The liveness and
+// readiness endpoints always are; allowlist entries ending in "*" match by
+// prefix.
+func (m *maintenanceMode) allows(path string) bool {
+	if path == "/_/health" || path == "/_/ready" {
+		return true
+	}
+	for _, allowed := range m.allow {
+		if prefix, ok := strings.CutSuffix(allowed, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// SetMaintenance switches maintenance mode on or off. While it is on, every
+// request except the health endpoints and allowPaths gets 503 Service
+// Unavailable with a Retry-After header of retryAfter, when positive, before
+// global middleware runs. An allowPaths entry ending in "*" matches paths
+// with that prefix, e.g. "/admin/*". It is safe to call while the handler is
+// serving requests.
+func (h *Handler) SetMaintenance(on bool, retryAfter time.Duration, allowPaths ...string) {
+	if !on {
+		h.maintenance.Store(nil)
+		return
+	}
+	h.maintenance.Store(&maintenanceMode{
+		retryAfter: retryAfter,
+		allow:      append([]string(nil), allowPaths...),
+	})
+}
+
+// InMaintenance reports whether maintenance mode is on
+func (h *Handler) InMaintenance() bool {
+	return h.maintenance.Load() != nil
+}
+
+// maintenanceGate is the outermost middleware, answering requests with 503
+// while maintenance mode is on
+func (h *Handler) maintenanceGate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		m := h.maintenance.Load()
+		if m == nil || m.allows(r.URL.Path) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if m.retryAfter > 0 {
+			seconds := int64((m.retryAfter + time.Second - 1) / time.Second)
+			w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+		}
+		// Expected during the window, so rendered without logging an error
+		h.errorRendererFor(w)(w, r, NewError(ErrCodeServiceUnavailable, "Service is under maintenance, please retry later"))
+	})
+}
@@ -0,0 +1,57 @@
+package ags_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestHandler_SetMaintenance(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+	h.Get("/orders", ok)
+	h.Get("/status", ok)
+	h.Get("/admin/jobs", ok)
+
+	h.SetMaintenance(true, 90*time.Second, "/status", "/admin/*")
+	assert.Assert(t, h.InMaintenance())
+
+	tests := []struct {
+		path           string
+		wantStatus     int
+		wantRetryAfter string
+	}{
+		{path: "/orders", wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "90"},
+		{path: "/missing", wantStatus: http.StatusServiceUnavailable, wantRetryAfter: "90"},
+		{path: "/status", wantStatus: http.StatusOK},
+		{path: "/admin/jobs", wantStatus: http.StatusOK},
+		{path: "/_/health", wantStatus: http.StatusOK},
+		{path: "/_/ready", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, tt.wantRetryAfter, rec.Header().Get("Retry-After"))
+		})
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders", nil))
+	assert.Equal(t, `{"ok":false,"message":"Service is under maintenance, please retry later","error":{"code":"SERVICE_UNAVAILABLE","message":"Service is under maintenance, please retry later"}}`+"\n", rec.Body.String())
+
+	h.SetMaintenance(false, 0)
+	assert.Assert(t, !h.InMaintenance())
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/orders", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
@@ -0,0 +1,29 @@
+package ags
+
+import "net/http"
+
+// Pagination is the keyset pagination metadata sent alongside the results of
+// a list response. NextCursor is opaque to clients, which pass it back to
+// fetch the following page; it is empty on the last page.
+type Pagination struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	HasMore    bool   `json:"has_more"`
+}
+
+// WithPagination adds pagination metadata to the envelope written by
+// RespondJSONWith
+func WithPagination(p Pagination) JSONOption {
+	return func(o *jsonOptions) {
+		o.pagination = &p
+	}
+}
+
+// RespondPaginated sends one page of items in the standard envelope with the
+// cursor of the next page, or "" when items is the last page. Cursors are
+// typically built with queryfilter.EncodeCursor from the last item's sort key.
+func RespondPaginated(w http.ResponseWriter, status int, message string, items interface{}, nextCursor string) error {
+	return RespondJSONWith(w, status, message, items, WithPagination(Pagination{
+		NextCursor: nextCursor,
+		HasMore:    nextCursor != "",
+	}))
+}
@@ -0,0 +1,42 @@
+package ags_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getangry/ags"
+	"github.com/getangry/ags/pkg/queryfilter"
+	"gotest.tools/assert"
+)
+
+func TestRespondPaginated(t *testing.T) {
+	cursor, err := queryfilter.EncodeCursor(queryfilter.Cursor{Field: "created_at", Value: "2024-05-01T10:00:00Z", Desc: true})
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name       string
+		nextCursor string
+		wantBody   string
+	}{
+		{
+			name:       "more pages",
+			nextCursor: cursor,
+			wantBody:   `{"ok":true,"message":"orders","results":[1,2],"pagination":{"next_cursor":"` + cursor + `","has_more":true}}` + "\n",
+		},
+		{
+			name:     "last page",
+			wantBody: `{"ok":true,"message":"orders","results":[1,2],"pagination":{"has_more":false}}` + "\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			assert.NilError(t, ags.RespondPaginated(rec, http.StatusOK, "orders", []int{1, 2}, tt.nextCursor))
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Equal(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
@@ -0,0 +1,134 @@
+// Package agstest provides helpers for testing ags handlers. Requests are
+// sent through the handler's full pipeline: global middleware, routing, the
+// pre and post phases and the route handler itself.
+package agstest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/getangry/ags"
+)
+
+// RequestOption customizes a request built by NewRequest or Do
+type RequestOption func(*http.Request) *http.Request
+
+// WithHeader sets a request header
+func WithHeader(key, value string) RequestOption {
+	return func(r *http.Request) *http.Request {
+		r.Header.Set(key, value)
+		return r
+	}
+}
+
+// WithBearerToken sets an Authorization header with the given bearer token
+func WithBearerToken(token string) RequestOption {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// WithUser stores user in the request context as ags.WithUser does, for
+// handlers that read it with ags.ContextUser
+func WithUser(user interface{}) RequestOption {
+	return WithContext(func(ctx context.Context) context.Context {
+		return ags.WithUser(ctx, user)
+	})
+}
+
+// WithContext replaces the request context with the result of fn
+func WithContext(fn func(ctx context.Context) context.Context) RequestOption {
+	return func(r *http.Request) *http.Request {
+		return r.WithContext(fn(r.Context()))
+	}
+}
+
+// NewRequest builds a request for method and path. A nil body sends no body;
+// a string, []byte or io.Reader is sent as is; any other value is encoded as
+// JSON and the Content-Type is set accordingly. It panics if body cannot be
+// encoded.
+func NewRequest(method, path string, body interface{}, opts ...RequestOption) *http.Request {
+	var reader io.Reader
+	isJSON := false
+	switch b := body.(type) {
+	case nil:
+	case string:
+		reader = strings.NewReader(b)
+	case []byte:
+		reader = bytes.NewReader(b)
+	case io.Reader:
+		reader = b
+	default:
+		data, err := json.Marshal(b)
+		if err != nil {
+			panic(fmt.Sprintf("agstest: encoding request body: %v", err))
+		}
+		reader = bytes.NewReader(data)
+		isJSON = true
+	}
+
+	r := httptest.NewRequest(method, path, reader)
+	if isJSON {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for _, opt := range opts {
+		r = opt(r)
+	}
+	return r
+}
+
+// Do sends a request through h and returns the recorder together with the
+// decoded response envelope. The envelope is nil when the body is not a JSON
+// StandardResponse, e.g. for file server responses.
+func Do(h http.Handler, method, path string, body interface{}, opts ...RequestOption) (*ags.StandardResponse, *httptest.ResponseRecorder) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, NewRequest(method, path, body, opts...))
+	return Decode(rec), rec
+}
+
+// Decode decodes the recorded body as a StandardResponse, or returns nil if
+// it is not one
+func Decode(rec *httptest.ResponseRecorder) *ags.StandardResponse {
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/json") {
+		return nil
+	}
+	var resp ags.StandardResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		return nil
+	}
+	return &resp
+}
+
+// DecodeResults decodes the Results field of a StandardResponse body into v
+func DecodeResults(rec *httptest.ResponseRecorder, v interface{}) error {
+	var resp struct {
+		Results json.RawMessage `json:"results"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		return err
+	}
+	if len(resp.Results) == 0 {
+		return fmt.Errorf("agstest: response has no results")
+	}
+	return json.Unmarshal(resp.Results, v)
+}
+
+// RunPrePhase runs the configured pre-request functions against r in order,
+// as the handler does before calling a route, and returns the resulting
+// context. It stops at the first error. The recorder captures anything the
+// functions write, such as headers.
+func RunPrePhase(cfg *ags.ServerConfig, r *http.Request) (context.Context, *httptest.ResponseRecorder, error) {
+	rec := httptest.NewRecorder()
+	ctx := r.Context()
+	for _, pre := range cfg.PrePhase {
+		var err error
+		if ctx, err = pre(ctx, rec, r); err != nil {
+			return ctx, rec, err
+		}
+	}
+	return ctx, rec, nil
+}
@@ -0,0 +1,107 @@
+package agstest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/getangry/ags"
+	"github.com/getangry/ags/pkg/agstest"
+	"gotest.tools/assert"
+)
+
+type user struct {
+	Name string `json:"name"`
+}
+
+func newSampleHandler() (*ags.Handler, *ags.ServerConfig) {
+	cfg := &ags.ServerConfig{
+		Log: ags.NewDefaultLogger(ags.ErrorLevel),
+		PrePhase: []ags.PreRequestFunc{
+			func(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
+				if r.Header.Get("Authorization") == "Bearer bad" {
+					return ctx, ags.NewError(ags.ErrCodeUnauthorized, "invalid token")
+				}
+				w.Header().Set("X-Checked", "yes")
+				return ctx, nil
+			},
+		},
+	}
+	h := ags.NewHandler(cfg)
+
+	h.Post("/users", func(w http.ResponseWriter, r *http.Request) {
+		var u user
+		if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
+			h.Error(w, ags.NewError(ags.ErrCodeBadRequest, "invalid body"))
+			return
+		}
+		_ = ags.RespondJSON(w, http.StatusCreated, "created", u)
+	})
+	h.Get("/me", func(w http.ResponseWriter, r *http.Request) {
+		u, ok := ags.ContextUser(r.Context())
+		if !ok {
+			h.Error(w, ags.NewError(ags.ErrCodeUnauthorized, "no user"))
+			return
+		}
+		_ = ags.RespondJSON(w, http.StatusOK, "ok", u)
+	})
+	return h, cfg
+}
+
+func TestDo(t *testing.T) {
+	h, _ := newSampleHandler()
+
+	resp, rec := agstest.Do(h, http.MethodPost, "/users", user{Name: "ada"})
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Assert(t, resp != nil)
+	assert.Assert(t, resp.OK)
+	assert.Equal(t, "created", resp.Message)
+	assert.Equal(t, "yes", rec.Header().Get("X-Checked"))
+
+	var got user
+	assert.NilError(t, agstest.DecodeResults(rec, &got))
+	assert.Equal(t, "ada", got.Name)
+
+	resp, rec = agstest.Do(h, http.MethodPost, "/users", "not json")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Assert(t, !resp.OK)
+	assert.Equal(t, ags.ErrCodeBadRequest, resp.Error.Code)
+}
+
+func TestDo_Options(t *testing.T) {
+	h, _ := newSampleHandler()
+
+	resp, rec := agstest.Do(h, http.MethodGet, "/me", nil, agstest.WithUser(user{Name: "grace"}))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Assert(t, resp.OK)
+
+	_, rec = agstest.Do(h, http.MethodGet, "/me", nil)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	resp, rec = agstest.Do(h, http.MethodGet, "/me", nil, agstest.WithBearerToken("bad"))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Equal(t, "invalid token", resp.Message)
+}
+
+func TestDo_NonJSONResponse(t *testing.T) {
+	h, _ := newSampleHandler()
+
+	resp, rec := agstest.Do(h, http.MethodGet, "/_/health", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Assert(t, resp == nil)
+}
+
+func TestRunPrePhase(t *testing.T) {
+	_, cfg := newSampleHandler()
+
+	_, rec, err := agstest.RunPrePhase(cfg, agstest.NewRequest(http.MethodGet, "/", nil))
+	assert.NilError(t, err)
+	assert.Equal(t, "yes", rec.Header().Get("X-Checked"))
+
+	_, _, err = agstest.RunPrePhase(cfg, agstest.NewRequest(http.MethodGet, "/", nil, agstest.WithBearerToken("bad")))
+	var appErr *ags.AppError
+	assert.Assert(t, errors.As(err, &appErr))
+	assert.Equal(t, ags.ErrCodeUnauthorized, appErr.Code)
+}
@@ -6,6 +6,11 @@ import (
 
 // Cacher is an interface that defines methods for a cache system.
 // It provides methods to set, get, and delete cache entries.
+//
+// Implementations must honor the context: when ctx is already cancelled or
+// past its deadline, Get reports a miss and Set and Delete do nothing.
+// Backends doing network I/O should additionally abort in-flight operations
+// when ctx is done.
 type Cacher interface {
 	// Set stores a value in the cache with the specified key.
 	Set(ctx context.Context, key string, value interface{})
@@ -15,3 +20,11 @@ type Cacher interface {
 	// Delete removes a value from the cache using the specified key.
 	Delete(ctx context.Context, key string)
 }
+
+// PrefixDeleter is implemented by caches that can invalidate a group of
+// related entries at once, e.g. every key starting with "user:123:".
+// A Redis implementation maps this to SCAN with a MATCH pattern followed by DEL.
+type PrefixDeleter interface {
+	// DeletePrefix removes every entry whose key starts with prefix.
+	DeletePrefix(ctx context.Context, prefix string)
+}
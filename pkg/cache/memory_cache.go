@@ -2,6 +2,7 @@ package cache
 
 import (
 	"context"
+	"strings"
 	"sync"
 	"time"
 )
@@ -29,12 +30,19 @@ func NewInMemoryCache(ttl time.Duration, cleanupFreq time.Duration) *InMemoryCac
 
 // Set stores a key-value pair in the cache
 func (c *InMemoryCache) Set(ctx context.Context, key string, value interface{}) {
+	if ctx.Err() != nil {
+		return
+	}
 	expiry := time.Now().Add(c.ttl)
 	c.data.Store(key, cacheEntry{value: value, expiresAt: expiry})
 }
 
 // Get retrieves a value from the cache and validates TTL
 func (c *InMemoryCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	if ctx.Err() != nil {
+		return nil, false
+	}
+
 	entry, ok := c.data.Load(key)
 	if !ok {
 		return nil, false
@@ -51,9 +59,25 @@ func (c *InMemoryCache) Get(ctx context.Context, key string) (interface{}, bool)
 
 // Delete removes a key-value pair from the cache
 func (c *InMemoryCache) Delete(ctx context.Context, key string) {
+	if ctx.Err() != nil {
+		return
+	}
 	c.data.Delete(key)
 }
 
+// DeletePrefix removes all key-value pairs whose key starts with prefix
+func (c *InMemoryCache) DeletePrefix(ctx context.Context, prefix string) {
+	if ctx.Err() != nil {
+		return
+	}
+	c.data.Range(func(key, value interface{}) bool {
+		if strings.HasPrefix(key.(string), prefix) {
+			c.data.Delete(key)
+		}
+		return true
+	})
+}
+
 // StartCleanup starts the periodic cleanup of expired cache entries
 func (c *InMemoryCache) StartCleanup(ctx context.Context) {
 	ticker := time.NewTicker(c.cleanupFreq)
@@ -53,3 +53,52 @@ func TestStopCleanup(t *testing.T) {
 		t.Errorf("Expected key1 to be expired and removed from cache")
 	}
 }
+
+func TestCancelledContext(t *testing.T) {
+	ctx := context.Background()
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	cache := NewInMemoryCache(time.Minute, time.Minute)
+
+	// Set with a cancelled context must not store anything
+	cache.Set(cancelled, "key1", "value1")
+	if _, found := cache.Get(ctx, "key1"); found {
+		t.Errorf("Expected Set with a cancelled context to be a no-op")
+	}
+
+	// Get with a cancelled context must report a miss
+	cache.Set(ctx, "key2", "value2")
+	if _, found := cache.Get(cancelled, "key2"); found {
+		t.Errorf("Expected Get with a cancelled context to report a miss")
+	}
+
+	// Delete with a cancelled context must not remove the entry
+	cache.Delete(cancelled, "key2")
+	if value, found := cache.Get(ctx, "key2"); !found || value != "value2" {
+		t.Errorf("Expected Delete with a cancelled context to be a no-op")
+	}
+}
+
+func TestDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	cache := NewInMemoryCache(time.Minute, time.Minute)
+
+	cache.Set(ctx, "user:123:profile", "profile")
+	cache.Set(ctx, "user:123:settings", "settings")
+	cache.Set(ctx, "user:1234:profile", "other profile")
+	cache.Set(ctx, "session:123", "session")
+
+	cache.DeletePrefix(ctx, "user:123:")
+
+	for _, key := range []string{"user:123:profile", "user:123:settings"} {
+		if _, found := cache.Get(ctx, key); found {
+			t.Errorf("Expected %s to be deleted by prefix", key)
+		}
+	}
+	for _, key := range []string{"user:1234:profile", "session:123"} {
+		if _, found := cache.Get(ctx, key); !found {
+			t.Errorf("Expected %s to survive prefix deletion", key)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package cache
+
+import (
+	"context"
+	"strings"
+)
+
+// NamespaceSeparator separates the namespace from the key in a Namespaced cache
+const NamespaceSeparator = ":"
+
+// namespacedCache is a view over another Cacher that prefixes every key
+type namespacedCache struct {
+	inner  Cacher
+	prefix string
+}
+
+// namespaceEscaper escapes the separator (and the escape character itself) in
+// namespace names, so the first unescaped separator always ends the namespace
+var namespaceEscaper = strings.NewReplacer(`\`, `\\`, NamespaceSeparator, `\`+NamespaceSeparator)
+
+// Namespaced returns a Cacher that transparently prefixes all keys with
+// prefix and NamespaceSeparator before delegating to c. It allows several
+// subsystems to share one backing cache without key collisions.
+// Separators inside prefix are escaped, so Namespaced(c, "a") with key "b:c"
+// and Namespaced(c, "a:b") with key "c" never address the same entry.
+//
+// The returned Cacher implements PrefixDeleter if and only if c does, so a
+// type assertion reliably tells callers whether bulk invalidation is possible.
+func Namespaced(c Cacher, prefix string) Cacher {
+	n := &namespacedCache{
+		inner:  c,
+		prefix: namespaceEscaper.Replace(prefix) + NamespaceSeparator,
+	}
+	if pd, ok := c.(PrefixDeleter); ok {
+		return &namespacedPrefixCache{namespacedCache: n, deleter: pd}
+	}
+	return n
+}
+
+// Set stores a value under the namespaced key
+func (n *namespacedCache) Set(ctx context.Context, key string, value interface{}) {
+	n.inner.Set(ctx, n.prefix+key, value)
+}
+
+// Get retrieves a value using the namespaced key
+func (n *namespacedCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	return n.inner.Get(ctx, n.prefix+key)
+}
+
+// Delete removes a value using the namespaced key
+func (n *namespacedCache) Delete(ctx context.Context, key string) {
+	n.inner.Delete(ctx, n.prefix+key)
+}
+
+// namespacedPrefixCache is a namespaced view over a cache that supports
+// prefix deletion
+type namespacedPrefixCache struct {
+	*namespacedCache
+	deleter PrefixDeleter
+}
+
+// DeletePrefix removes every entry in the namespace whose key starts with prefix
+func (n *namespacedPrefixCache) DeletePrefix(ctx context.Context, prefix string) {
+	n.deleter.DeletePrefix(ctx, n.prefix+prefix)
+}
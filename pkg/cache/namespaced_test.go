@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNamespacedIsolation(t *testing.T) {
+	ctx := context.Background()
+	backing := NewInMemoryCache(time.Minute, time.Minute)
+
+	users := Namespaced(backing, "users")
+	orders := Namespaced(backing, "orders")
+
+	users.Set(ctx, "1", "alice")
+	orders.Set(ctx, "1", "order-1")
+
+	if value, found := users.Get(ctx, "1"); !found || value != "alice" {
+		t.Errorf("Expected users namespace to return alice, got %v", value)
+	}
+	if value, found := orders.Get(ctx, "1"); !found || value != "order-1" {
+		t.Errorf("Expected orders namespace to return order-1, got %v", value)
+	}
+
+	// Keys are stored prefixed in the backing cache
+	if value, found := backing.Get(ctx, "users:1"); !found || value != "alice" {
+		t.Errorf("Expected backing cache to hold users:1, got %v", value)
+	}
+	if _, found := backing.Get(ctx, "1"); found {
+		t.Errorf("Expected unprefixed key to be absent from backing cache")
+	}
+
+	// Deleting in one namespace leaves the other untouched
+	users.Delete(ctx, "1")
+	if _, found := users.Get(ctx, "1"); found {
+		t.Errorf("Expected users:1 to be deleted")
+	}
+	if _, found := orders.Get(ctx, "1"); !found {
+		t.Errorf("Expected orders:1 to survive deletion in another namespace")
+	}
+}
+
+func TestNamespacedDeletePrefix(t *testing.T) {
+	ctx := context.Background()
+	backing := NewInMemoryCache(time.Minute, time.Minute)
+
+	users := Namespaced(backing, "users")
+	orders := Namespaced(backing, "orders")
+
+	users.Set(ctx, "1", "alice")
+	users.Set(ctx, "2", "bob")
+	orders.Set(ctx, "1", "order-1")
+
+	// An empty prefix clears the whole namespace
+	users.(PrefixDeleter).DeletePrefix(ctx, "")
+
+	if _, found := users.Get(ctx, "1"); found {
+		t.Errorf("Expected users namespace to be cleared")
+	}
+	if _, found := users.Get(ctx, "2"); found {
+		t.Errorf("Expected users namespace to be cleared")
+	}
+	if _, found := orders.Get(ctx, "1"); !found {
+		t.Errorf("Expected orders namespace to be untouched")
+	}
+}
+
+func TestNamespacedSeparatorInPrefix(t *testing.T) {
+	ctx := context.Background()
+	backing := NewInMemoryCache(time.Minute, time.Minute)
+
+	a := Namespaced(backing, "a")
+	ab := Namespaced(backing, "a:b")
+
+	a.Set(ctx, "b:c", "from a")
+	ab.Set(ctx, "c", "from a:b")
+
+	if value, found := a.Get(ctx, "b:c"); !found || value != "from a" {
+		t.Errorf("Expected namespace a to return its own value, got %v", value)
+	}
+	if value, found := ab.Get(ctx, "c"); !found || value != "from a:b" {
+		t.Errorf("Expected namespace a:b to return its own value, got %v", value)
+	}
+
+	// Clearing one namespace must not reach into a namespace that merely
+	// starts with the same name
+	users := Namespaced(backing, "users")
+	usersX := Namespaced(backing, "users:x")
+	users.Set(ctx, "1", "alice")
+	usersX.Set(ctx, "1", "bob")
+
+	users.(PrefixDeleter).DeletePrefix(ctx, "")
+
+	if _, found := users.Get(ctx, "1"); found {
+		t.Errorf("Expected users namespace to be cleared")
+	}
+	if value, found := usersX.Get(ctx, "1"); !found || value != "bob" {
+		t.Errorf("Expected users:x namespace to be untouched, got %v", value)
+	}
+}
+
+func TestNamespacedPrefixDeleterSupport(t *testing.T) {
+	backing := NewInMemoryCache(time.Minute, time.Minute)
+
+	if _, ok := Namespaced(backing, "users").(PrefixDeleter); !ok {
+		t.Errorf("Expected namespace over InMemoryCache to support DeletePrefix")
+	}
+
+	// Embedding only the Cacher interface hides DeletePrefix
+	var plain Cacher = struct{ Cacher }{backing}
+	if _, ok := Namespaced(plain, "users").(PrefixDeleter); ok {
+		t.Errorf("Expected namespace over a cache without DeletePrefix not to expose it")
+	}
+}
@@ -0,0 +1,189 @@
+// Package client calls ags-based APIs. It sends requests with an http.Client
+// and decodes the ags.StandardResponse envelope; failed responses are
+// returned as *ags.AppError values rebuilt from the envelope's error field.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/getangry/ags"
+)
+
+// Client sends requests to an ags-based API
+type Client struct {
+	baseURL    string
+	httpClient *http.Client
+	header     http.Header
+}
+
+// Option configures a Client
+type Option func(*Client)
+
+// WithHTTPClient sets the http.Client used to send requests, e.g. one with a
+// timeout or a custom transport. http.DefaultClient is used by default.
+func WithHTTPClient(hc *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = hc
+	}
+}
+
+// WithHeader sets a header sent with every request
+func WithHeader(key, value string) Option {
+	return func(c *Client) {
+		c.header.Set(key, value)
+	}
+}
+
+// WithBearerToken sends an Authorization header with the given bearer token
+func WithBearerToken(token string) Option {
+	return WithHeader("Authorization", "Bearer "+token)
+}
+
+// New returns a Client for the API at baseURL, e.g. "http://orders:7841"
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: http.DefaultClient,
+		header:     make(http.Header),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// envelope is ags.StandardResponse with the results left undecoded
+type envelope struct {
+	OK         bool              `json:"ok"`
+	Message    string            `json:"message"`
+	Results    json.RawMessage   `json:"results,omitempty"`
+	Pagination *ags.Pagination   `json:"pagination,omitempty"`
+	Warnings   []ags.ErrorDetail `json:"warnings,omitempty"`
+	Error      *ags.ErrorInfo    `json:"error,omitempty"`
+}
+
+// Do sends req and decodes the response envelope. When the envelope reports
+// success, its results are decoded into results unless it is nil. When it
+// reports a failure, Do returns the envelope and an *ags.AppError carrying
+// the error code, message, details and the response status. Responses that
+// are not an envelope produce a plain error, or an *ags.AppError for error
+// statuses.
+func (c *Client) Do(req *http.Request, results interface{}) (*ags.StandardResponse, error) {
+	for key, values := range c.header {
+		if req.Header.Get(key) == "" {
+			req.Header[key] = values
+		}
+	}
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("client: reading response: %w", err)
+	}
+
+	var env envelope
+	if err := json.Unmarshal(body, &env); err != nil {
+		if resp.StatusCode >= http.StatusBadRequest {
+			return nil, statusError(resp.StatusCode)
+		}
+		return nil, fmt.Errorf("client: decoding response: %w", err)
+	}
+
+	sr := &ags.StandardResponse{
+		OK:         env.OK,
+		Message:    env.Message,
+		Pagination: env.Pagination,
+		Warnings:   env.Warnings,
+		Error:      env.Error,
+	}
+	if !env.OK {
+		return sr, appError(resp.StatusCode, env)
+	}
+
+	if len(env.Results) > 0 {
+		sr.Results = env.Results
+		if results != nil {
+			if err := json.Unmarshal(env.Results, results); err != nil {
+				return sr, fmt.Errorf("client: decoding results: %w", err)
+			}
+			sr.Results = results
+		}
+	}
+	return sr, nil
+}
+
+// Get sends a GET request for path and decodes the results into results
+func (c *Client) Get(ctx context.Context, path string, results interface{}) (*ags.StandardResponse, error) {
+	return c.send(ctx, http.MethodGet, path, nil, results)
+}
+
+// Post sends body as JSON in a POST request and decodes the results into results
+func (c *Client) Post(ctx context.Context, path string, body, results interface{}) (*ags.StandardResponse, error) {
+	return c.send(ctx, http.MethodPost, path, body, results)
+}
+
+// Put sends body as JSON in a PUT request and decodes the results into results
+func (c *Client) Put(ctx context.Context, path string, body, results interface{}) (*ags.StandardResponse, error) {
+	return c.send(ctx, http.MethodPut, path, body, results)
+}
+
+// Delete sends a DELETE request for path and decodes the results into results
+func (c *Client) Delete(ctx context.Context, path string, results interface{}) (*ags.StandardResponse, error) {
+	return c.send(ctx, http.MethodDelete, path, nil, results)
+}
+
+// send builds a request with an optional JSON body and passes it to Do
+func (c *Client) send(ctx context.Context, method, path string, body, results interface{}) (*ags.StandardResponse, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("client: encoding request body: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return c.Do(req, results)
+}
+
+// appError rebuilds the AppError reported by a failed envelope
+func appError(status int, env envelope) *ags.AppError {
+	if env.Error == nil {
+		appErr := ags.NewError(ags.ErrCodeInternal, env.Message)
+		appErr.StatusCode = status
+		return appErr
+	}
+
+	appErr := ags.NewError(env.Error.Code, env.Error.Message)
+	appErr.StatusCode = status
+	appErr.Details = append(appErr.Details, env.Error.Details...)
+	return appErr
+}
+
+// statusError reports an error status whose body is not an envelope
+func statusError(status int) *ags.AppError {
+	appErr := ags.NewError(ags.ErrCodeInternal, http.StatusText(status))
+	appErr.StatusCode = status
+	return appErr
+}
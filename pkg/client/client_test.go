@@ -0,0 +1,126 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/getangry/ags"
+	"github.com/getangry/ags/pkg/client"
+	"gotest.tools/assert"
+)
+
+type order struct {
+	ID    int    `json:"id"`
+	Owner string `json:"owner"`
+}
+
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:          ags.NewDefaultLogger(ags.ErrorLevel),
+		ErrorDetails: ags.ErrorDetailsFields,
+	})
+
+	h.Get("/orders/1", func(w http.ResponseWriter, r *http.Request) {
+		owner := "anonymous"
+		if r.Header.Get("Authorization") == "Bearer secret" {
+			owner = "ada"
+		}
+		_ = ags.RespondJSON(w, http.StatusOK, "found", order{ID: 1, Owner: owner})
+	})
+	h.Post("/orders", func(w http.ResponseWriter, r *http.Request) {
+		var o order
+		if err := json.NewDecoder(r.Body).Decode(&o); err != nil || o.Owner == "" {
+			h.Error(w, ags.NewError(ags.ErrCodeValidation, "invalid order").
+				WithField("owner", "is required"))
+			return
+		}
+		_ = ags.RespondJSON(w, http.StatusCreated, "created", o)
+	})
+	h.Get("/orders/2", func(w http.ResponseWriter, r *http.Request) {
+		h.Error(w, ags.NewError(ags.ErrCodeNotFound, "no such order"))
+	})
+	h.Get("/plain", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	})
+
+	s := httptest.NewServer(h)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestClient_Success(t *testing.T) {
+	s := newTestServer(t)
+	c := client.New(s.URL+"/", client.WithBearerToken("secret"))
+
+	var got order
+	resp, err := c.Get(context.Background(), "/orders/1", &got)
+	assert.NilError(t, err)
+	assert.Assert(t, resp.OK)
+	assert.Equal(t, "found", resp.Message)
+	assert.DeepEqual(t, order{ID: 1, Owner: "ada"}, got)
+}
+
+func TestClient_Errors(t *testing.T) {
+	s := newTestServer(t)
+	c := client.New(s.URL)
+
+	tests := []struct {
+		name        string
+		call        func() (*ags.StandardResponse, error)
+		wantStatus  int
+		wantCode    ags.ErrorCode
+		wantMessage string
+		wantDetails []ags.ErrorDetail
+	}{
+		{
+			name: "validation error",
+			call: func() (*ags.StandardResponse, error) {
+				return c.Post(context.Background(), "/orders", order{ID: 3}, nil)
+			},
+			wantStatus:  http.StatusBadRequest,
+			wantCode:    ags.ErrCodeValidation,
+			wantMessage: "invalid order",
+			wantDetails: []ags.ErrorDetail{
+				{Code: ags.ErrCodeValidation, Message: "is required", Field: "owner"},
+			},
+		},
+		{
+			name: "not found",
+			call: func() (*ags.StandardResponse, error) {
+				return c.Get(context.Background(), "/orders/2", nil)
+			},
+			wantStatus:  http.StatusNotFound,
+			wantCode:    ags.ErrCodeNotFound,
+			wantMessage: "no such order",
+		},
+		{
+			name: "response without envelope",
+			call: func() (*ags.StandardResponse, error) {
+				return c.Get(context.Background(), "/plain", nil)
+			},
+			wantStatus:  http.StatusBadGateway,
+			wantCode:    ags.ErrCodeInternal,
+			wantMessage: "Bad Gateway",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.call()
+
+			var appErr *ags.AppError
+			assert.Assert(t, errors.As(err, &appErr), "got %v", err)
+			assert.Equal(t, tt.wantStatus, appErr.StatusCode)
+			assert.Equal(t, tt.wantCode, appErr.Code)
+			assert.Equal(t, tt.wantMessage, appErr.Message)
+			if tt.wantDetails != nil {
+				assert.DeepEqual(t, tt.wantDetails, appErr.Details)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+// Package logwriter provides an io.Writer appending to a log file that it
+// rotates by size or age, for environments without external rotation such
+// as logrotate. Use it with DefaultLogger.SetOutput:
+//
+//	w, err := logwriter.New("/var/log/orders/app.log",
+//		logwriter.WithMaxSize(100<<20), logwriter.WithMaxBackups(7), logwriter.WithCompress())
+//	if err != nil {
+//		return err
+//	}
+//	defer w.Close()
+//	logger.SetOutput(w)
+package logwriter
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files so they sort by rotation time
+const backupTimeFormat = "20060102T150405.000000000"
+
+// Option configures a Writer
+type Option func(*Writer)
+
+// WithMaxSize rotates the file before a write would grow it past n bytes. A
+// single write larger than n still goes to a file of its own. Zero, the
+// default, never rotates by size.
+func WithMaxSize(n int64) Option {
+	return func(w *Writer) {
+		w.maxSize = n
+	}
+}
+
+// WithMaxAge rotates the file on the first write once it has been open for
+// d. Zero, the default, never rotates by age.
+func WithMaxAge(d time.Duration) Option {
+	return func(w *Writer) {
+		w.maxAge = d
+	}
+}
+
+// WithMaxBackups keeps at most n rotated files, removing the oldest. Zero,
+// the default, keeps them all.
+func WithMaxBackups(n int) Option {
+	return func(w *Writer) {
+		w.maxBackups = n
+	}
+}
+
+// WithCompress gzips rotated files in the background, adding a .gz suffix
+func WithCompress() Option {
+	return func(w *Writer) {
+		w.compress = true
+	}
+}
+
+// Writer appends to a log file and rotates it. Rotated files are renamed
+// after the rotation time, e.g. app-20261015T101500.000000000.log for
+// app.log, and a new file is opened at the original path. It is safe for
+// concurrent use.
+type Writer struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+	compress   bool
+	now        func() time.Time
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+
+	// millMu serializes compressing and pruning of rotated files, which run
+	// in the background so writes are not held up
+	millMu sync.Mutex
+	mills  sync.WaitGroup
+}
+
+// New opens path for appending, creating it and its directory if needed,
+// and returns a Writer rotating it according to opts
+func New(path string, opts ...Option) (*Writer, error) {
+	w := &Writer{path: path, now: time.Now}
+	for _, opt := range opts {
+		opt(w)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("logwriter: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends p to the file, rotating it first when p would exceed the
+// size limit or the file has reached its maximum age
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+	if w.shouldRotate(len(p)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate closes the current file, renames it and opens a new one at the
+// original path
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+// Close closes the file and waits for background compression and pruning
+// to finish. Writes after Close fail with os.ErrClosed.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	var err error
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+
+	w.mills.Wait()
+	return err
+}
+
+// shouldRotate reports whether the file must be rotated before writing n
+// bytes. Empty files are never rotated.
+func (w *Writer) shouldRotate(n int) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.maxSize > 0 && w.size+int64(n) > w.maxSize {
+		return true
+	}
+	return w.maxAge > 0 && w.now().Sub(w.openedAt) >= w.maxAge
+}
+
+// open opens the file at path for appending
+func (w *Writer) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("logwriter: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("logwriter: %w", err)
+	}
+	w.file = f
+	w.size = info.Size()
+	w.openedAt = w.now()
+	return nil
+}
+
+// rotate renames the current file after the rotation time and reopens the
+// path. The caller holds w.mu.
+func (w *Writer) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("logwriter: %w", err)
+	}
+	w.file = nil
+
+	backup := w.backupName(w.now())
+	if err := os.Rename(w.path, backup); err != nil {
+		// Keep logging to the current file rather than losing entries
+		if openErr := w.open(); openErr != nil {
+			return errors.Join(fmt.Errorf("logwriter: %w", err), openErr)
+		}
+		return fmt.Errorf("logwriter: %w", err)
+	}
+	if err := w.open(); err != nil {
+		return err
+	}
+
+	if w.compress || w.maxBackups > 0 {
+		w.mills.Add(1)
+		go func() {
+			defer w.mills.Done()
+			w.mill(backup)
+		}()
+	}
+	return nil
+}
+
+// backupName returns an unused name for a file rotated at t
+func (w *Writer) backupName(t time.Time) string {
+	prefix, ext := w.backupAffixes()
+	stamp := t.UTC().Format(backupTimeFormat)
+	name := prefix + stamp + ext
+	for i := 1; exists(name) || exists(name+".gz"); i++ {
+		name = fmt.Sprintf("%s%s-%d%s", prefix, stamp, i, ext)
+	}
+	return name
+}
+
+// backupAffixes returns what rotated file names start and end with, e.g.
+// "/var/log/app-" and ".log" for /var/log/app.log
+func (w *Writer) backupAffixes() (string, string) {
+	ext := filepath.Ext(w.path)
+	return strings.TrimSuffix(w.path, ext) + "-", ext
+}
+
+// mill compresses a freshly rotated file and removes the oldest backups
+func (w *Writer) mill(backup string) {
+	w.millMu.Lock()
+	defer w.millMu.Unlock()
+
+	if w.compress {
+		// A failed compression leaves the plain file in place
+		_ = compressFile(backup)
+	}
+	if w.maxBackups > 0 {
+		backups := w.backups()
+		for len(backups) > w.maxBackups {
+			_ = os.Remove(backups[0])
+			backups = backups[1:]
+		}
+	}
+}
+
+// backups returns the rotated files of the writer, oldest first
+func (w *Writer) backups() []string {
+	prefix, ext := w.backupAffixes()
+	dir, base := filepath.Dir(prefix), filepath.Base(prefix)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var backups []string
+	for _, entry := range entries {
+		name := entry.Name()
+		stamp, ok := strings.CutPrefix(strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ext), base)
+		if !ok || entry.IsDir() || len(stamp) < len(backupTimeFormat) {
+			continue
+		}
+		if _, err := time.Parse(backupTimeFormat, stamp[:len(backupTimeFormat)]); err != nil {
+			continue
+		}
+		backups = append(backups, filepath.Join(dir, name))
+	}
+	sort.Strings(backups)
+	return backups
+}
+
+// compressFile gzips name into name.gz and removes name
+func compressFile(name string) error {
+	if err := gzipFile(name, name+".gz"); err != nil {
+		os.Remove(name + ".gz")
+		return err
+	}
+	return os.Remove(name)
+}
+
+// gzipFile writes the gzipped content of src to dst
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	if _, err := io.Copy(zw, in); err != nil {
+		zw.Close()
+		out.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// exists reports whether a file exists at name
+func exists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
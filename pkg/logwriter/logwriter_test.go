@@ -0,0 +1,186 @@
+package logwriter
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWriter_RotatesAtMaxSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := New(path, WithMaxSize(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"first\n", "second\n", "third\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) error = %v", line, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := w.backups()
+	if len(backups) != 2 {
+		t.Fatalf("expected 2 rotated files, got %v", backups)
+	}
+	for i, want := range []string{"first\n", "second\n"} {
+		if got := readFile(t, backups[i]); got != want {
+			t.Errorf("backup %d holds %q, want %q", i, got, want)
+		}
+		if !strings.HasPrefix(filepath.Base(backups[i]), "app-") || filepath.Ext(backups[i]) != ".log" {
+			t.Errorf("unexpected backup name %s", backups[i])
+		}
+	}
+	if got := readFile(t, path); got != "third\n" {
+		t.Errorf("current file holds %q, want %q", got, "third\n")
+	}
+}
+
+func TestWriter_RotatesAtMaxAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	now := time.Date(2026, 10, 15, 10, 0, 0, 0, time.UTC)
+	w, err := New(path, WithMaxAge(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.now = func() time.Time { return now }
+	w.openedAt = now
+
+	write := func(s string) {
+		t.Helper()
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("morning\n")
+	now = now.Add(30 * time.Minute)
+	write("still morning\n")
+	if n := len(w.backups()); n != 0 {
+		t.Fatalf("expected no rotation within the hour, got %d backups", n)
+	}
+
+	now = now.Add(30 * time.Minute)
+	write("noon\n")
+	backups := w.backups()
+	if len(backups) != 1 {
+		t.Fatalf("expected 1 rotated file, got %v", backups)
+	}
+	if want := filepath.Join(filepath.Dir(path), "app-20261015T110000.000000000.log"); backups[0] != want {
+		t.Errorf("backup named %s, want %s", backups[0], want)
+	}
+	if got := readFile(t, backups[0]); got != "morning\nstill morning\n" {
+		t.Errorf("backup holds %q", got)
+	}
+}
+
+func TestWriter_CompressAndMaxBackups(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := New(path, WithMaxSize(4), WithMaxBackups(2), WithCompress())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{"one\n", "two\n", "six\n", "ten\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	backups := w.backups()
+	if len(backups) != 2 {
+		t.Fatalf("expected the 2 newest backups to be kept, got %v", backups)
+	}
+	for i, want := range []string{"two\n", "six\n"} {
+		if !strings.HasSuffix(backups[i], ".log.gz") {
+			t.Errorf("backup %s is not compressed", backups[i])
+			continue
+		}
+		if got := readGzip(t, backups[i]); got != want {
+			t.Errorf("backup %d holds %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestWriter_ConcurrentWrites(t *testing.T) {
+	const writers, perWriter = 8, 200
+	line := strings.Repeat("x", 31) + "\n"
+
+	path := filepath.Join(t.TempDir(), "app.log")
+	w, err := New(path, WithMaxSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < perWriter; j++ {
+				if _, err := w.Write([]byte(line)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	total := len(readFile(t, path))
+	for _, backup := range w.backups() {
+		content := readFile(t, backup)
+		if len(content) > 1024 {
+			t.Errorf("backup %s is %d bytes, over the limit", backup, len(content))
+		}
+		total += len(content)
+	}
+	if want := writers * perWriter * len(line); total != want {
+		t.Errorf("files hold %d bytes, want %d", total, want)
+	}
+
+	if _, err := w.Write([]byte(line)); err != os.ErrClosed {
+		t.Errorf("Write after Close error = %v, want os.ErrClosed", err)
+	}
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func readGzip(t *testing.T, name string) string {
+	t.Helper()
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
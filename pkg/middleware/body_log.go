@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+)
+
+// InfoLogger is the logging method BodyLog needs. ags.Logger satisfies it.
+type InfoLogger interface {
+	Info(msg string, fields ...interface{})
+}
+
+// BodyLog is a middleware that logs the request and response bodies of a
+// sampled fraction of requests, for targeted production debugging. sampleRate
+// is the probability of logging a request: 0 disables logging and 1 logs every
+// request. Each body is truncated to maxBytes. The request body is restored
+// after reading, so the handler still sees it in full.
+func BodyLog(logger InfoLogger, sampleRate float64, maxBytes int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if sampleRate <= 0 || (sampleRate < 1 && rand.Float64() >= sampleRate) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// Read one byte beyond the limit to know whether the body was truncated
+			var reqBody []byte
+			if r.Body != nil && r.Body != http.NoBody {
+				reqBody, _ = io.ReadAll(io.LimitReader(r.Body, int64(maxBytes)+1))
+				r.Body = readCloser{
+					Reader: io.MultiReader(bytes.NewReader(reqBody), r.Body),
+					Closer: r.Body,
+				}
+			}
+			reqTruncated := len(reqBody) > maxBytes
+			if reqTruncated {
+				reqBody = reqBody[:maxBytes]
+			}
+
+			bw := &bodyCaptureWriter{ResponseWriter: w, status: http.StatusOK, limit: maxBytes}
+			next.ServeHTTP(bw, r)
+
+			logger.Info("http body",
+				"request_id", GetReqID(r.Context()),
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", bw.status,
+				"request_body", string(reqBody),
+				"request_truncated", reqTruncated,
+				"response_body", bw.buf.String(),
+				"response_truncated", bw.truncated)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// readCloser pairs the replayed request body with the original Closer
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyCaptureWriter records the status and the first limit bytes of the
+// response body while passing everything through
+type bodyCaptureWriter struct {
+	http.ResponseWriter
+	status    int
+	limit     int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (w *bodyCaptureWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	if room := w.limit - w.buf.Len(); room > 0 {
+		if len(b) > room {
+			w.buf.Write(b[:room])
+			w.truncated = true
+		} else {
+			w.buf.Write(b)
+		}
+	} else if len(b) > 0 {
+		w.truncated = true
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush passes flushes through for streaming handlers
+func (w *bodyCaptureWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (w *bodyCaptureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
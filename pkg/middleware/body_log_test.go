@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type recordingLogger struct {
+	calls  int
+	fields map[string]interface{}
+}
+
+func (l *recordingLogger) Info(msg string, fields ...interface{}) {
+	l.calls++
+	l.fields = make(map[string]interface{})
+	for i := 0; i+1 < len(fields); i += 2 {
+		l.fields[fields[i].(string)] = fields[i+1]
+	}
+}
+
+func TestBodyLog(t *testing.T) {
+	tests := []struct {
+		name             string
+		maxBytes         int
+		reqBody          string
+		wantReqBody      string
+		wantReqTruncated bool
+		wantRespBody     string
+		wantRespTrunc    bool
+	}{
+		{
+			name:         "within limit",
+			maxBytes:     64,
+			reqBody:      `{"name":"ada"}`,
+			wantReqBody:  `{"name":"ada"}`,
+			wantRespBody: `echo:{"name":"ada"}`,
+		},
+		{
+			name:             "truncated",
+			maxBytes:         4,
+			reqBody:          `{"name":"ada"}`,
+			wantReqBody:      `{"na`,
+			wantReqTruncated: true,
+			wantRespBody:     `echo`,
+			wantRespTrunc:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logger := &recordingLogger{}
+			var handlerSaw string
+			handler := BodyLog(logger, 1, tt.maxBytes)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				handlerSaw = string(body)
+				w.WriteHeader(http.StatusCreated)
+				_, _ = w.Write([]byte("echo:"))
+				_, _ = w.Write(body)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(tt.reqBody))
+			req = req.WithContext(context.WithValue(req.Context(), RequestIDKey, "req-1"))
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if handlerSaw != tt.reqBody {
+				t.Errorf("handler read %q, want the full body %q", handlerSaw, tt.reqBody)
+			}
+			if rec.Body.String() != "echo:"+tt.reqBody {
+				t.Errorf("response = %q, want it passed through in full", rec.Body.String())
+			}
+			if logger.calls != 1 {
+				t.Fatalf("logged %d times, want 1", logger.calls)
+			}
+
+			want := map[string]interface{}{
+				"request_id":         "req-1",
+				"status":             http.StatusCreated,
+				"request_body":       tt.wantReqBody,
+				"request_truncated":  tt.wantReqTruncated,
+				"response_body":      tt.wantRespBody,
+				"response_truncated": tt.wantRespTrunc,
+			}
+			for key, value := range want {
+				if logger.fields[key] != value {
+					t.Errorf("logged %s = %v, want %v", key, logger.fields[key], value)
+				}
+			}
+		})
+	}
+}
+
+func TestBodyLog_Sampling(t *testing.T) {
+	logger := &recordingLogger{}
+	handler := BodyLog(logger, 0, 64)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for i := 0; i < 10; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+	if logger.calls != 0 {
+		t.Errorf("logged %d times with a zero sample rate, want 0", logger.calls)
+	}
+}
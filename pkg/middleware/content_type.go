@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// RequireContentType is a middleware that rejects requests with a body whose
+// Content-Type is not one of the allowed media types. Parameters such as
+// charset are ignored when comparing. Safe methods (GET, HEAD, OPTIONS, TRACE)
+// and requests without a body are passed through unchanged.
+// Rejected requests receive a 415 Unsupported Media Type response.
+func RequireContentType(types ...string) func(http.Handler) http.Handler {
+	allowed := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		allowed[strings.ToLower(t)] = struct{}{}
+	}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if isSafeMethod(r.Method) || r.ContentLength == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if err == nil {
+				if _, ok := allowed[mediaType]; ok {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+
+			writeError(w, http.StatusUnsupportedMediaType, "UNSUPPORTED_MEDIA_TYPE",
+				"Unsupported Content-Type, expected one of: "+strings.Join(types, ", "))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// isSafeMethod reports whether the method is defined as safe by RFC 9110
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
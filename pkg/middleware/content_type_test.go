@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireContentType(t *testing.T) {
+	handler := RequireContentType("application/json")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		wantStatus  int
+	}{
+		{
+			name:        "accepted type",
+			method:      http.MethodPost,
+			contentType: "application/json",
+			body:        `{}`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "charset suffixed type",
+			method:      http.MethodPut,
+			contentType: "application/json; charset=utf-8",
+			body:        `{}`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:        "rejected type",
+			method:      http.MethodPost,
+			contentType: "text/plain",
+			body:        "hello",
+			wantStatus:  http.StatusUnsupportedMediaType,
+		},
+		{
+			name:       "missing type",
+			method:     http.MethodPatch,
+			body:       `{}`,
+			wantStatus: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:        "safe method is not checked",
+			method:      http.MethodGet,
+			contentType: "text/plain",
+			wantStatus:  http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			rec := httptest.NewRecorder()
+
+			handler.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusUnsupportedMediaType &&
+				!strings.Contains(rec.Body.String(), `"ok":false`) {
+				t.Errorf("expected standard error envelope, got %s", rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/getangry/ags/pkg/cache"
+)
+
+// IdempotencyKeyHeader is the request header carrying the idempotency key
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// DefaultIdempotencyMaxBody is the largest request body Idempotency reads to
+// fingerprint a request, unless changed with WithIdempotencyMaxBody
+const DefaultIdempotencyMaxBody = 1 << 20
+
+// IdempotencyOption configures a middleware created by Idempotency
+type IdempotencyOption func(*idempotencyOptions)
+
+type idempotencyOptions struct {
+	scope   func(*http.Request) string
+	maxBody int64
+}
+
+// WithIdempotencyScope sets the function identifying the client a key
+// belongs to, e.g. the authenticated user ID. Keys are only shared between
+// requests with the same scope. The default uses the Authorization header,
+// or the client IP when there is none.
+func WithIdempotencyScope(scope func(*http.Request) string) IdempotencyOption {
+	return func(o *idempotencyOptions) {
+		o.scope = scope
+	}
+}
+
+// WithIdempotencyMaxBody sets the largest request body read to fingerprint a
+// request. Larger bodies are rejected with 413 Request Entity Too Large.
+func WithIdempotencyMaxBody(n int64) IdempotencyOption {
+	return func(o *idempotencyOptions) {
+		o.maxBody = n
+	}
+}
+
+// defaultIdempotencyScope identifies the client by its credentials, falling
+// back to its IP address
+func defaultIdempotencyScope(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		return "auth:" + auth
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// replayedHeader reports whether a response header may be stored and
+// replayed; request IDs and cookies belong to the original request only
+func replayedHeader(name string) bool {
+	return !strings.EqualFold(name, RequestIDHeader) && !strings.EqualFold(name, "Set-Cookie")
+}
+
+// idempotentResponse is a response stored by Idempotency
+type idempotentResponse struct {
+	fingerprint [sha256.Size]byte
+	status      int
+	header      http.Header
+	body        []byte
+	expiresAt   time.Time
+}
+
+// Idempotency is a middleware that makes POST, PUT, PATCH and DELETE requests
+// carrying an Idempotency-Key header safe to retry. The first response for a
+// key is stored in c for ttl and replayed, with its status, headers and body
+// and an Idempotent-Replayed: true header, to later requests with the same
+// key, method and path from the same client, see WithIdempotencyScope. Only
+// headers set by the wrapped handler are replayed, without request IDs. A
+// request reusing a key with a different body gets 409 Conflict, as does one
+// arriving while the first is still running on this instance. 5xx responses
+// and responses setting cookies are not stored, so such requests run again.
+// Requests without the header pass through.
+func Idempotency(c cache.Cacher, ttl time.Duration, opts ...IdempotencyOption) func(http.Handler) http.Handler {
+	o := idempotencyOptions{scope: defaultIdempotencyScope, maxBody: DefaultIdempotencyMaxBody}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	var inFlight sync.Map // cache key -> struct{}
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get(IdempotencyKeyHeader)
+			if key == "" || !idempotentMethod(r.Method) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, o.maxBody))
+			if err != nil {
+				var maxErr *http.MaxBytesError
+				if errors.As(err, &maxErr) {
+					writeError(w, http.StatusRequestEntityTooLarge, "BAD_REQUEST", "Request body too large")
+					return
+				}
+				writeError(w, http.StatusBadRequest, "BAD_REQUEST", "Failed to read request body")
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+			fingerprint := sha256.Sum256(body)
+
+			// The scope is hashed so credentials never end up in cache keys
+			scope := sha256.Sum256([]byte(o.scope(r)))
+			ctx := r.Context()
+			cacheKey := "idempotency:" + hex.EncodeToString(scope[:]) + " " + r.Method + " " + r.URL.Path + " " + key
+			if v, ok := c.Get(ctx, cacheKey); ok {
+				if stored, ok := v.(idempotentResponse); ok && time.Now().Before(stored.expiresAt) {
+					if stored.fingerprint != fingerprint {
+						writeError(w, http.StatusConflict, "CONFLICT",
+							"Idempotency key was already used with a different request body")
+						return
+					}
+					stored.replay(w)
+					return
+				}
+			}
+
+			if _, busy := inFlight.LoadOrStore(cacheKey, struct{}{}); busy {
+				writeError(w, http.StatusConflict, "CONFLICT",
+					"A request with this idempotency key is still being processed")
+				return
+			}
+			defer inFlight.Delete(cacheKey)
+
+			rec := &teeRecorder{ResponseWriter: w, status: http.StatusOK, before: w.Header().Clone()}
+			next.ServeHTTP(rec, r)
+
+			if rec.status >= http.StatusInternalServerError || rec.setCookie {
+				return
+			}
+			c.Set(ctx, cacheKey, idempotentResponse{
+				fingerprint: fingerprint,
+				status:      rec.status,
+				header:      rec.header,
+				body:        rec.body.Bytes(),
+				expiresAt:   time.Now().Add(ttl),
+			})
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// idempotentMethod reports whether Idempotency applies to method
+func idempotentMethod(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// replay writes the stored response to w
+func (s idempotentResponse) replay(w http.ResponseWriter) {
+	dst := w.Header()
+	for k, v := range s.header {
+		dst[k] = append([]string(nil), v...)
+	}
+	dst.Set("Idempotent-Replayed", "true")
+	w.WriteHeader(s.status)
+	w.Write(s.body)
+}
+
+// teeRecorder passes the response through while keeping a copy of it
+type teeRecorder struct {
+	http.ResponseWriter
+	status      int
+	before      http.Header // headers set before the handler ran
+	header      http.Header
+	body        bytes.Buffer
+	setCookie   bool
+	wroteHeader bool
+}
+
+// WriteHeader keeps the replayable headers set by the handler itself
+func (t *teeRecorder) WriteHeader(status int) {
+	if t.wroteHeader {
+		return
+	}
+	t.wroteHeader = true
+	t.status = status
+	t.header = make(http.Header)
+	for k, v := range t.ResponseWriter.Header() {
+		if !replayedHeader(k) {
+			continue
+		}
+		if prev, ok := t.before[k]; ok && reflect.DeepEqual(prev, v) {
+			continue
+		}
+		t.header[k] = append([]string(nil), v...)
+	}
+	t.setCookie = len(t.ResponseWriter.Header().Values("Set-Cookie")) > 0
+	t.ResponseWriter.WriteHeader(status)
+}
+
+func (t *teeRecorder) Write(b []byte) (int, error) {
+	if !t.wroteHeader {
+		t.WriteHeader(http.StatusOK)
+	}
+	t.body.Write(b)
+	return t.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the wrapped ResponseWriter
+func (t *teeRecorder) Unwrap() http.ResponseWriter {
+	return t.ResponseWriter
+}
@@ -0,0 +1,225 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags/pkg/cache"
+)
+
+func TestIdempotency(t *testing.T) {
+	runs := 0
+	handler := Idempotency(cache.NewInMemoryCache(time.Hour, time.Hour), time.Minute)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			runs++
+			body, _ := io.ReadAll(r.Body)
+			if string(body) == `{"fail":true}` {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			w.Header().Set("Location", "/orders/42")
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{"id":42}`))
+		}))
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name         string
+		key          string
+		body         string
+		wantStatus   int
+		wantBody     string
+		wantReplayed string
+		wantRuns     int
+	}{
+		{name: "first request", key: "k1", body: `{"qty":1}`, wantStatus: http.StatusCreated, wantBody: `{"id":42}`, wantRuns: 1},
+		{name: "replay", key: "k1", body: `{"qty":1}`, wantStatus: http.StatusCreated, wantBody: `{"id":42}`, wantReplayed: "true", wantRuns: 1},
+		{name: "conflicting body", key: "k1", body: `{"qty":2}`, wantStatus: http.StatusConflict, wantRuns: 1},
+		{name: "other key", key: "k2", body: `{"qty":2}`, wantStatus: http.StatusCreated, wantBody: `{"id":42}`, wantRuns: 2},
+		{name: "no key", body: `{"qty":1}`, wantStatus: http.StatusCreated, wantBody: `{"id":42}`, wantRuns: 3},
+		{name: "server error", key: "k3", body: `{"fail":true}`, wantStatus: http.StatusBadGateway, wantRuns: 4},
+		{name: "server error is not stored", key: "k3", body: `{"fail":true}`, wantStatus: http.StatusBadGateway, wantRuns: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := post(tt.key, tt.body)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+			if tt.wantStatus == http.StatusCreated && rec.Header().Get("Location") != "/orders/42" {
+				t.Errorf("Location = %q, want /orders/42", rec.Header().Get("Location"))
+			}
+			if got := rec.Header().Get("Idempotent-Replayed"); got != tt.wantReplayed {
+				t.Errorf("Idempotent-Replayed = %q, want %q", got, tt.wantReplayed)
+			}
+			if runs != tt.wantRuns {
+				t.Errorf("handler ran %d times, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestIdempotency_InFlight(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	handler := Idempotency(cache.NewInMemoryCache(time.Hour, time.Hour), time.Minute)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(started)
+			<-release
+		}))
+
+	newRequest := func() *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		return req
+	}
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), newRequest())
+		close(done)
+	}()
+	<-started
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, newRequest())
+	if rec.Code != http.StatusConflict {
+		t.Errorf("concurrent request got %d, want 409", rec.Code)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestIdempotency_PerRequestHeaders(t *testing.T) {
+	runs := map[string]int{}
+	handler := RequestID(Idempotency(cache.NewInMemoryCache(time.Hour, time.Hour), time.Minute)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			runs[r.URL.Path]++
+			if r.URL.Path == "/sessions" {
+				http.SetCookie(w, &http.Cookie{Name: "session", Value: "secret"})
+			}
+			w.Header().Set("Location", "/orders/42")
+			w.WriteHeader(http.StatusCreated)
+		})))
+
+	post := func(path, reqID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader("{}"))
+		req.Header.Set(IdempotencyKeyHeader, "k1")
+		req.Header.Set(RequestIDHeader, reqID)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	post("/orders", "first")
+	rec := post("/orders", "second")
+	if runs["/orders"] != 1 || rec.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("second request was not replayed, handler ran %d times", runs["/orders"])
+	}
+	if got := rec.Header().Get(RequestIDHeader); !strings.HasPrefix(got, "second/") {
+		t.Errorf("%s = %q, want the replaying request's ID", RequestIDHeader, got)
+	}
+	if got := rec.Header().Values(RequestIDHeader); len(got) != 1 {
+		t.Errorf("%s = %q, want a single value", RequestIDHeader, got)
+	}
+	if got := rec.Header().Get("Location"); got != "/orders/42" {
+		t.Errorf("Location = %q, want /orders/42", got)
+	}
+
+	// Responses setting cookies are not stored
+	post("/sessions", "first")
+	rec = post("/sessions", "second")
+	if runs["/sessions"] != 2 {
+		t.Errorf("handler ran %d times for /sessions, want 2", runs["/sessions"])
+	}
+	if rec.Header().Get("Idempotent-Replayed") != "" {
+		t.Error("response setting a cookie was replayed")
+	}
+}
+
+func TestIdempotency_Scope(t *testing.T) {
+	runs := 0
+	handler := Idempotency(cache.NewInMemoryCache(time.Hour, time.Hour), time.Minute)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			runs++
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+	tests := []struct {
+		name       string
+		auth       string
+		remoteAddr string
+		wantRuns   int
+	}{
+		{name: "first client", auth: "Bearer alice", remoteAddr: "10.0.0.1:1234", wantRuns: 1},
+		{name: "same credentials", auth: "Bearer alice", remoteAddr: "10.0.0.2:1234", wantRuns: 1},
+		{name: "other credentials", auth: "Bearer bob", remoteAddr: "10.0.0.1:1234", wantRuns: 2},
+		{name: "anonymous client", remoteAddr: "10.0.0.3:1234", wantRuns: 3},
+		{name: "same address", remoteAddr: "10.0.0.3:5678", wantRuns: 3},
+		{name: "other address", remoteAddr: "10.0.0.4:1234", wantRuns: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader("{}"))
+			req.Header.Set(IdempotencyKeyHeader, "shared")
+			if tt.auth != "" {
+				req.Header.Set("Authorization", tt.auth)
+			}
+			req.RemoteAddr = tt.remoteAddr
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if runs != tt.wantRuns {
+				t.Errorf("handler ran %d times, want %d", runs, tt.wantRuns)
+			}
+		})
+	}
+}
+
+func TestIdempotency_MaxBody(t *testing.T) {
+	runs := 0
+	handler := Idempotency(cache.NewInMemoryCache(time.Hour, time.Hour), time.Minute, WithIdempotencyMaxBody(8))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			runs++
+			w.WriteHeader(http.StatusCreated)
+		}))
+
+	tests := []struct {
+		body       string
+		wantStatus int
+		wantRuns   int
+	}{
+		{body: `{"a":1}`, wantStatus: http.StatusCreated, wantRuns: 1},
+		{body: `{"a":"too long"}`, wantStatus: http.StatusRequestEntityTooLarge, wantRuns: 1},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(tt.body))
+		req.Header.Set(IdempotencyKeyHeader, tt.body)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.wantStatus {
+			t.Errorf("body %s: status = %d, want %d", tt.body, rec.Code, tt.wantStatus)
+		}
+		if runs != tt.wantRuns {
+			t.Errorf("body %s: handler ran %d times, want %d", tt.body, runs, tt.wantRuns)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// MethodOverrideHeader is the request header MethodOverride reads
+const MethodOverrideHeader = "X-HTTP-Method-Override"
+
+// MethodOverrideField is the form field MethodOverride reads when the header
+// is absent
+const MethodOverrideField = "_method"
+
+// MethodOverride is a middleware that lets clients limited to GET and POST,
+// such as HTML forms, send PUT, PATCH and DELETE requests. A POST request
+// carrying one of those methods in the X-HTTP-Method-Override header or the
+// _method field of a form body is passed on with that method. Any other
+// request method or override target, e.g. TRACE, leaves the request
+// unchanged. Register it with Handler.Use so it runs before route matching.
+func MethodOverride() func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodPost {
+				if method, ok := overrideMethod(r); ok {
+					r.Method = method
+				}
+			}
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// overrideMethod returns the method a POST request asks to be treated as
+func overrideMethod(r *http.Request) (string, bool) {
+	method := r.Header.Get(MethodOverrideHeader)
+	if method == "" && isFormRequest(r) {
+		// Parsing keeps the form available to the handler through r.Form
+		method = r.PostFormValue(MethodOverrideField)
+	}
+
+	switch method = strings.ToUpper(strings.TrimSpace(method)); method {
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		return method, true
+	default:
+		return "", false
+	}
+}
+
+// isFormRequest reports whether the request body is an HTML form
+func isFormRequest(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/x-www-form-urlencoded" || mediaType == "multipart/form-data"
+}
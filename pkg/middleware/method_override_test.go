@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestMethodOverride(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodGet, http.MethodTrace} {
+		method := method
+		mux.HandleFunc(method+" /items/1", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Route", method)
+			w.Header().Set("X-Name", r.FormValue("name"))
+		})
+	}
+	handler := MethodOverride()(mux)
+
+	tests := []struct {
+		name      string
+		method    string
+		form      url.Values
+		header    string
+		wantRoute string
+		wantName  string
+	}{
+		{
+			name:      "form field",
+			method:    http.MethodPost,
+			form:      url.Values{"_method": {"DELETE"}, "name": {"widget"}},
+			wantRoute: http.MethodDelete,
+			wantName:  "widget",
+		},
+		{
+			name:      "lower case form field",
+			method:    http.MethodPost,
+			form:      url.Values{"_method": {"patch"}},
+			wantRoute: http.MethodPatch,
+		},
+		{
+			name:      "header",
+			method:    http.MethodPost,
+			header:    "PUT",
+			wantRoute: http.MethodPut,
+		},
+		{
+			name:      "header wins over form field",
+			method:    http.MethodPost,
+			form:      url.Values{"_method": {"DELETE"}},
+			header:    "PATCH",
+			wantRoute: http.MethodPatch,
+		},
+		{
+			name:      "unsafe target ignored",
+			method:    http.MethodPost,
+			form:      url.Values{"_method": {"TRACE"}},
+			wantRoute: http.MethodPost,
+		},
+		{
+			name:      "GET target ignored",
+			method:    http.MethodPost,
+			header:    "GET",
+			wantRoute: http.MethodPost,
+		},
+		{
+			name:      "only POST is upgraded",
+			method:    http.MethodGet,
+			header:    "DELETE",
+			wantRoute: http.MethodGet,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var req *http.Request
+			if tt.form != nil {
+				req = httptest.NewRequest(tt.method, "/items/1", strings.NewReader(tt.form.Encode()))
+				req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			} else {
+				req = httptest.NewRequest(tt.method, "/items/1", nil)
+			}
+			if tt.header != "" {
+				req.Header.Set(MethodOverrideHeader, tt.header)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("X-Route"); got != tt.wantRoute {
+				t.Errorf("reached %q route, want %q", got, tt.wantRoute)
+			}
+			if got := rec.Header().Get("X-Name"); got != tt.wantName {
+				t.Errorf("form field name = %q, want %q", got, tt.wantName)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+type ctxKeyOriginalPath struct{}
+
+// RewritePath is a middleware that replaces the request path with
+// rewrite(path) before passing the request on. Used as global middleware on
+// an ags.Handler it runs before route matching, so the rewritten path selects
+// the route and ags.RoutePattern reports the pattern it matched. The path
+// as received stays available through OriginalPath.
+func RewritePath(rewrite func(string) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			path := rewrite(r.URL.Path)
+			if path == r.URL.Path {
+				next.ServeHTTP(w, r)
+				return
+			}
+			next.ServeHTTP(w, withPath(r, path, ""))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// StripPrefix is a middleware that removes prefix from the request path, so
+// an application mounted behind a proxy forwarding /service/... can register
+// its routes at /... The prefix must end at a segment boundary: with prefix
+// "/service", "/service/users" becomes "/users" and "/service" becomes "/",
+// while "/services" is left alone. Unlike http.StripPrefix, requests without
+// the prefix pass through unchanged instead of getting a 404.
+func StripPrefix(prefix string) func(http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			path, ok := stripPrefix(r.URL.Path, prefix)
+			if !ok || prefix == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rawPath, _ := stripPrefix(r.URL.RawPath, prefix)
+			next.ServeHTTP(w, withPath(r, path, rawPath))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// OriginalPath returns the request path as received, before RewritePath or
+// StripPrefix changed it, or "" when neither did
+func OriginalPath(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	path, _ := ctx.Value(ctxKeyOriginalPath{}).(string)
+	return path
+}
+
+// stripPrefix removes prefix from path if it ends at a segment boundary
+func stripPrefix(path, prefix string) (string, bool) {
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && rest[0] != '/') {
+		return path, false
+	}
+	if rest == "" {
+		rest = "/"
+	}
+	return rest, true
+}
+
+// withPath returns a shallow copy of r with a new path, recording the
+// original one in the context unless an earlier rewrite already did
+func withPath(r *http.Request, path, rawPath string) *http.Request {
+	ctx := r.Context()
+	if OriginalPath(ctx) == "" {
+		ctx = context.WithValue(ctx, ctxKeyOriginalPath{}, r.URL.Path)
+	}
+
+	r2 := r.WithContext(ctx)
+	u := new(url.URL)
+	*u = *r.URL
+	u.Path = path
+	u.RawPath = rawPath
+	r2.URL = u
+	return r2
+}
@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestStripPrefix(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/users", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("users from " + OriginalPath(r.Context())))
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("root " + r.URL.Path))
+	})
+	handler := StripPrefix("/service/")(mux)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantBody string
+	}{
+		{name: "stripped prefix", path: "/service/users", wantBody: "users from /service/users"},
+		{name: "prefix only", path: "/service", wantBody: "root /"},
+		{name: "no prefix", path: "/users", wantBody: "users from "},
+		{name: "prefix not at a segment boundary", path: "/services/users", wantBody: "root /services/users"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rec.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rec.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestStripPrefix_RawPath(t *testing.T) {
+	var gotPath, gotEscaped string
+	handler := StripPrefix("/service")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotEscaped = r.URL.Path, r.URL.EscapedPath()
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/service/files/a%2Fb", nil))
+	if gotPath != "/files/a/b" || gotEscaped != "/files/a%2Fb" {
+		t.Errorf("path = %q (escaped %q), want /files/a/b (escaped /files/a%%2Fb)", gotPath, gotEscaped)
+	}
+}
+
+func TestRewritePath(t *testing.T) {
+	var gotPath, gotOriginal string
+	handler := RewritePath(strings.ToLower)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotOriginal = r.URL.Path, OriginalPath(r.Context())
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/Users/ADA", nil)
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if gotPath != "/users/ada" || gotOriginal != "/Users/ADA" {
+		t.Errorf("path = %q, original = %q, want /users/ada and /Users/ADA", gotPath, gotOriginal)
+	}
+	if req.URL.Path != "/Users/ADA" {
+		t.Errorf("inbound request modified: %q", req.URL.Path)
+	}
+}
@@ -15,11 +15,14 @@ type ctxKeyRequestID string
 
 const RequestIDKey ctxKeyRequestID = "req_id"
 
+// DefaultCounterShards is the number of request counter shards used by
+// RequestID and by NewRequestID unless WithCounterShards is given
+const DefaultCounterShards = 64
+
 var (
 	RequestIDHeader = "X-ReqId"
 	prefix          string
-	counterShards   = 64
-	counters        [64]uint64
+	defaultIDs      = newRequestIDGenerator(DefaultCounterShards)
 )
 
 func init() {
@@ -38,26 +41,70 @@ func init() {
 	prefix = fmt.Sprintf("%s/%s", hostname, b64)
 }
 
+// requestIDGenerator hands out request sequence numbers from a set of
+// counter shards, spreading contention across remote addresses
+type requestIDGenerator struct {
+	counters []uint64
+}
+
+func newRequestIDGenerator(shards int) *requestIDGenerator {
+	if shards < 1 {
+		shards = 1
+	}
+	return &requestIDGenerator{counters: make([]uint64, shards)}
+}
+
 // shardCounter returns a pointer to the counter shard based on a hash of the input string.
-func shardCounter(key string) *uint64 {
+func (g *requestIDGenerator) shardCounter(key string) *uint64 {
 	hash := fnv.New64a()
 	hash.Write([]byte(key))
-	return &counters[hash.Sum64()%uint64(counterShards)]
+	return &g.counters[hash.Sum64()%uint64(len(g.counters))]
 }
 
 // nextRequestID generates the next request ID using a shard based on the remote address.
-func nextRequestID(key string) uint64 {
-	return atomic.AddUint64(shardCounter(key), 1)
+func (g *requestIDGenerator) nextRequestID(key string) uint64 {
+	return atomic.AddUint64(g.shardCounter(key), 1)
+}
+
+// RequestIDOption configures a middleware created by NewRequestID
+type RequestIDOption func(*requestIDOptions)
+
+type requestIDOptions struct {
+	shards int
 }
 
-// RequestID is a middleware that injects a request ID into the context of each request.
-// If the header already exists, it appends the new ID using "/" as a separator.
+// WithCounterShards sets the number of counter shards. More shards reduce
+// contention on machines serving many distinct remote addresses concurrently;
+// fewer save memory. Values below 1 are treated as 1.
+func WithCounterShards(n int) RequestIDOption {
+	return func(o *requestIDOptions) {
+		o.shards = n
+	}
+}
+
+// NewRequestID returns a request ID middleware like RequestID with its own
+// counters configured by opts
+func NewRequestID(opts ...RequestIDOption) func(http.Handler) http.Handler {
+	o := requestIDOptions{shards: DefaultCounterShards}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return newRequestIDGenerator(o.shards).middleware
+}
+
+// RequestID is a middleware that injects a request ID into the context of each request
+// and sets it on the response header. If the request header already exists, the new
+// ID is appended to it using "/" as a separator. The request header is not modified.
 func RequestID(next http.Handler) http.Handler {
+	return defaultIDs.middleware(next)
+}
+
+func (g *requestIDGenerator) middleware(next http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
 		// Use the remote address or another unique attribute of the request as a shard key
-		newID := fmt.Sprintf("%s-%06d", prefix, nextRequestID(r.RemoteAddr))
+		newID := fmt.Sprintf("%s-%06d", prefix, g.nextRequestID(r.RemoteAddr))
 
 		existingRequestID := r.Header.Get(RequestIDHeader)
 		if existingRequestID != "" {
@@ -65,7 +112,8 @@ func RequestID(next http.Handler) http.Handler {
 			newID = fmt.Sprintf("%s/%s", existingRequestID, newID)
 		}
 
-		r.Header.Set(RequestIDHeader, newID)
+		// Echo the ID to the client; the inbound request header is left as sent
+		w.Header().Set(RequestIDHeader, newID)
 
 		// Add the final ID to the request context
 		ctx = context.WithValue(ctx, RequestIDKey, newID)
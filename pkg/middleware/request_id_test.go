@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewRequestID(t *testing.T) {
+	for _, shards := range []int{0, 1, 8} {
+		t.Run(fmt.Sprintf("shards=%d", shards), func(t *testing.T) {
+			var ids []string
+			handler := NewRequestID(WithCounterShards(shards))(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ids = append(ids, GetReqID(r.Context()))
+			}))
+
+			for i := 0; i < 3; i++ {
+				handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			}
+
+			for i, want := range []string{"-000001", "-000002", "-000003"} {
+				if !strings.HasPrefix(ids[i], prefix) || !strings.HasSuffix(ids[i], want) {
+					t.Errorf("request %d id = %q, want %s...%s", i, ids[i], prefix, want)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkRequestIDShards(b *testing.B) {
+	keys := make([]string, 1024)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("10.0.%d.%d:4000", i/256, i%256)
+	}
+
+	for _, shards := range []int{1, 16, 64, 256} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			g := newRequestIDGenerator(shards)
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					g.nextRequestID(keys[i%len(keys)])
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestRequestID_LeavesRequestHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		inbound  string
+		wantHead string
+	}{
+		{name: "no inbound id", inbound: ""},
+		{name: "inbound id is extended", inbound: "upstream-1", wantHead: "upstream-1/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ctxID, seenHeader string
+			handler := RequestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ctxID = GetReqID(r.Context())
+				seenHeader = r.Header.Get(RequestIDHeader)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.inbound != "" {
+				req.Header.Set(RequestIDHeader, tt.inbound)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+
+			if seenHeader != tt.inbound {
+				t.Errorf("handler saw request header %q, want it unchanged as %q", seenHeader, tt.inbound)
+			}
+			if got := req.Header.Get(RequestIDHeader); got != tt.inbound {
+				t.Errorf("request header = %q after the middleware, want %q", got, tt.inbound)
+			}
+			if !strings.HasPrefix(ctxID, tt.wantHead+prefix) {
+				t.Errorf("context id = %q, want prefix %q", ctxID, tt.wantHead+prefix)
+			}
+			if got := rec.Header().Get(RequestIDHeader); got != ctxID {
+				t.Errorf("response header = %q, want the context id %q", got, ctxID)
+			}
+		})
+	}
+}
@@ -0,0 +1,33 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// errorResponse mirrors the ags StandardResponse envelope for error responses.
+// It is duplicated here because the ags package depends on this one.
+type errorResponse struct {
+	OK      bool       `json:"ok"`
+	Message string     `json:"message"`
+	Error   *errorInfo `json:"error,omitempty"`
+}
+
+type errorInfo struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// writeError sends an error response in the standard ags JSON envelope
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorResponse{
+		OK:      false,
+		Message: message,
+		Error: &errorInfo{
+			Code:    code,
+			Message: message,
+		},
+	})
+}
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/getangry/ags/internal/singleflight"
+)
+
+// SingleFlight is a middleware coalescing concurrent identical GET and HEAD
+// requests: the first one runs the handler while the others with the same
+// key wait for it, then every request receives a copy of the buffered
+// response. Other methods are not coalesced, nor are requests for which key
+// returns "". A nil key uses the request URI.
+//
+// The key must cover everything the response depends on, e.g. the user for
+// authenticated endpoints, or one client's response is served to another.
+// The handler runs with a context that is not canceled when the first client
+// goes away, since others may be waiting for its result; its response is
+// buffered, so streaming responses are not supported behind it. If it panics,
+// the panic propagates in the request that ran it and the waiting requests
+// receive a 500 response.
+func SingleFlight(key func(*http.Request) string) func(http.Handler) http.Handler {
+	return newSingleFlight(key).middleware
+}
+
+// singleFlight holds the in-flight executions of a SingleFlight middleware
+type singleFlight struct {
+	key     func(*http.Request) string
+	group   singleflight.Group
+	pending atomic.Int64 // requests inside group.Do, for tests
+}
+
+func newSingleFlight(key func(*http.Request) string) *singleFlight {
+	if key == nil {
+		key = func(r *http.Request) string { return r.URL.RequestURI() }
+	}
+	return &singleFlight{key: key}
+}
+
+func (s *singleFlight) middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := s.key(r)
+		if key == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		s.pending.Add(1)
+		v, err, _ := s.group.Do(r.Method+" "+key, func() (interface{}, error) {
+			rec := &flightRecorder{header: make(http.Header)}
+			next.ServeHTTP(rec, r.WithContext(context.WithoutCancel(r.Context())))
+			return rec, nil
+		})
+		s.pending.Add(-1)
+
+		var panicErr *singleflight.PanicError
+		if errors.As(err, &panicErr) {
+			writeError(w, http.StatusInternalServerError, "INTERNAL_ERROR", "An internal error occurred")
+			return
+		}
+		v.(*flightRecorder).replay(w)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// flightRecorder buffers the response shared by coalesced requests
+type flightRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (f *flightRecorder) Header() http.Header {
+	return f.header
+}
+
+func (f *flightRecorder) WriteHeader(status int) {
+	if f.status == 0 {
+		f.status = status
+	}
+}
+
+func (f *flightRecorder) Write(b []byte) (int, error) {
+	if f.status == 0 {
+		f.status = http.StatusOK
+	}
+	return f.body.Write(b)
+}
+
+// replay writes a copy of the recorded response to w
+func (f *flightRecorder) replay(w http.ResponseWriter) {
+	dst := w.Header()
+	for k, v := range f.header {
+		dst[k] = append([]string(nil), v...)
+	}
+	status := f.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(f.body.Bytes())
+}
@@ -0,0 +1,125 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSingleFlight(t *testing.T) {
+	const n = 10
+
+	var runs atomic.Int32
+	release := make(chan struct{})
+	sf := newSingleFlight(nil)
+	handler := sf.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs.Add(1)
+		<-release
+		w.Header().Set("X-Report", "monthly")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("report"))
+	}))
+
+	var wg sync.WaitGroup
+	recs := make([]*httptest.ResponseRecorder, n)
+	serve := func(i int) {
+		defer wg.Done()
+		recs[i] = httptest.NewRecorder()
+		handler.ServeHTTP(recs[i], httptest.NewRequest(http.MethodGet, "/report?month=5", nil))
+	}
+
+	// Start one request and let it reach the handler, then the others
+	wg.Add(n)
+	go serve(0)
+	waitFor(t, func() bool { return runs.Load() == 1 })
+	for i := 1; i < n; i++ {
+		go serve(i)
+	}
+	waitFor(t, func() bool { return sf.pending.Load() == n })
+	// pending is counted just before joining the flight
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := runs.Load(); got != 1 {
+		t.Errorf("handler ran %d times, want 1", got)
+	}
+	for i, rec := range recs {
+		if rec.Code != http.StatusCreated || rec.Body.String() != "report" || rec.Header().Get("X-Report") != "monthly" {
+			t.Errorf("request %d got %d %q %v, want the shared response", i, rec.Code, rec.Body.String(), rec.Header())
+		}
+	}
+
+	// The flight is over, so a new request runs the handler again
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/report?month=5", nil))
+	if got := runs.Load(); got != 2 {
+		t.Errorf("handler ran %d times after the flight, want 2", got)
+	}
+}
+
+func TestSingleFlight_Bypass(t *testing.T) {
+	var runs atomic.Int32
+	handler := SingleFlight(func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		runs.Add(1)
+	}))
+
+	tests := []struct {
+		name   string
+		method string
+		user   string
+	}{
+		{name: "unsafe method", method: http.MethodPost, user: "ada"},
+		{name: "empty key", method: http.MethodGet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := runs.Load()
+			req := httptest.NewRequest(tt.method, "/", nil)
+			req.Header.Set("X-User", tt.user)
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if runs.Load() != before+1 {
+				t.Errorf("handler did not run")
+			}
+		})
+	}
+}
+
+func TestSingleFlight_Panic(t *testing.T) {
+	release := make(chan struct{})
+	sf := newSingleFlight(nil)
+	handler := sf.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		panic("boom")
+	}))
+
+	leaderPanicked := make(chan interface{}, 1)
+	go func() {
+		defer func() { leaderPanicked <- recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+	waitFor(t, func() bool { return sf.pending.Load() == 1 })
+
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+	waitFor(t, func() bool { return sf.pending.Load() == 2 })
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+
+	if p := <-leaderPanicked; p != "boom" {
+		t.Errorf("leader recovered %v, want boom", p)
+	}
+	<-done
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("waiting request got %d, want 500", rec.Code)
+	}
+}
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Skip wraps mw so that it is bypassed for requests matching skip; those go
+// straight to the next handler. It works with any middleware, e.g. to keep
+// health checks out of authentication or access logging.
+func Skip(mw func(http.Handler) http.Handler, skip func(*http.Request) bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		wrapped := mw(next)
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			if skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			wrapped.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// Unless wraps mw so that it is bypassed for the given paths. A path ending
+// in "/" matches every path under it, so "/_/" excludes all internal
+// endpoints; other paths must match exactly.
+func Unless(mw func(http.Handler) http.Handler, paths ...string) func(http.Handler) http.Handler {
+	return Skip(mw, func(r *http.Request) bool {
+		for _, p := range paths {
+			if r.URL.Path == p || (strings.HasSuffix(p, "/") && strings.HasPrefix(r.URL.Path, p)) {
+				return true
+			}
+		}
+		return false
+	})
+}
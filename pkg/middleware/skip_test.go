@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// markMiddleware sets a header so tests can see whether it ran
+func markMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Middleware", "ran")
+		next.ServeHTTP(w, r)
+	})
+}
+
+func TestSkip(t *testing.T) {
+	handler := Skip(markMiddleware, func(r *http.Request) bool {
+		return r.Method == http.MethodOptions
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		method  string
+		wantRan bool
+	}{
+		{method: http.MethodGet, wantRan: true},
+		{method: http.MethodOptions, wantRan: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(tt.method, "/orders", nil))
+
+			if rec.Code != http.StatusOK {
+				t.Errorf("got status %d, want 200", rec.Code)
+			}
+			if ran := rec.Header().Get("X-Middleware") == "ran"; ran != tt.wantRan {
+				t.Errorf("middleware ran = %v, want %v", ran, tt.wantRan)
+			}
+		})
+	}
+}
+
+func TestUnless(t *testing.T) {
+	handler := Unless(markMiddleware, "/metrics", "/_/")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	tests := []struct {
+		path    string
+		wantRan bool
+	}{
+		{path: "/orders", wantRan: true},
+		{path: "/metrics", wantRan: false},
+		{path: "/metrics/extra", wantRan: true},
+		{path: "/_/health", wantRan: false},
+		{path: "/_/ready", wantRan: false},
+		{path: "/_", wantRan: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if ran := rec.Header().Get("X-Middleware") == "ran"; ran != tt.wantRan {
+				t.Errorf("middleware ran for %s = %v, want %v", tt.path, ran, tt.wantRan)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// ThrottleOption configures the Throttle middleware
+type ThrottleOption func(*throttler)
+
+// WithThrottleQueue lets up to n requests wait for a free slot once the
+// concurrency cap is reached. Without it, requests over the cap are rejected
+// immediately.
+func WithThrottleQueue(n int) ThrottleOption {
+	return func(t *throttler) {
+		t.queue = n
+	}
+}
+
+// WithThrottleTimeout bounds how long a queued request waits for a slot before
+// being rejected. Zero waits until the request context is done.
+func WithThrottleTimeout(d time.Duration) ThrottleOption {
+	return func(t *throttler) {
+		t.timeout = d
+	}
+}
+
+// throttler is a semaphore limiting the requests being handled concurrently
+type throttler struct {
+	tokens  chan struct{}
+	backlog chan struct{}
+	queue   int
+	timeout time.Duration
+}
+
+func newThrottler(max int, opts ...ThrottleOption) *throttler {
+	if max < 1 {
+		max = 1
+	}
+	t := &throttler{}
+	for _, opt := range opts {
+		opt(t)
+	}
+	if t.queue < 0 {
+		t.queue = 0
+	}
+	t.tokens = make(chan struct{}, max)
+	t.backlog = make(chan struct{}, max+t.queue)
+	return t
+}
+
+// Throttle is a middleware that caps the number of requests handled
+// concurrently at max, protecting downstream services. Requests over the cap
+// wait in a queue of configurable length (see WithThrottleQueue and
+// WithThrottleTimeout); when the queue is full or the wait times out they
+// receive a 503 Service Unavailable response. Slots are released when the
+// handler returns, including when it panics, so it composes with a recovery
+// middleware placed inside or outside it.
+func Throttle(max int, opts ...ThrottleOption) func(http.Handler) http.Handler {
+	return newThrottler(max, opts...).middleware
+}
+
+func (t *throttler) middleware(next http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		// The backlog counts running and queued requests together
+		select {
+		case t.backlog <- struct{}{}:
+		default:
+			t.reject(w)
+			return
+		}
+		defer func() { <-t.backlog }()
+
+		var timeout <-chan time.Time
+		if t.timeout > 0 {
+			timer := time.NewTimer(t.timeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+
+		select {
+		case t.tokens <- struct{}{}:
+		case <-timeout:
+			t.reject(w)
+			return
+		case <-r.Context().Done():
+			t.reject(w)
+			return
+		}
+		defer func() { <-t.tokens }()
+
+		next.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+func (t *throttler) reject(w http.ResponseWriter) {
+	writeError(w, http.StatusServiceUnavailable, "SERVICE_UNAVAILABLE",
+		"Server is at capacity, please retry later")
+}
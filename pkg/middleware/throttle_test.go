@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// waitFor polls cond until it holds or the test times out
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not reached")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	const max, queue = 2, 1
+
+	release := make(chan struct{})
+	th := newThrottler(max, WithThrottleQueue(queue))
+	handler := th.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	var wg sync.WaitGroup
+	codes := make(chan int, max+queue)
+	for i := 0; i < max+queue; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			codes <- rec.Code
+		}()
+	}
+
+	// max requests running and queue requests waiting
+	waitFor(t, func() bool { return len(th.tokens) == max && len(th.backlog) == max+queue })
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("request over the cap and queue got %d, want 503", rec.Code)
+	}
+
+	close(release)
+	wg.Wait()
+	close(codes)
+	for code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("admitted request got %d, want 200", code)
+		}
+	}
+	if len(th.tokens) != 0 || len(th.backlog) != 0 {
+		t.Errorf("slots not released: tokens=%d backlog=%d", len(th.tokens), len(th.backlog))
+	}
+}
+
+func TestThrottle_QueueTimeout(t *testing.T) {
+	release := make(chan struct{})
+	th := newThrottler(1, WithThrottleQueue(1), WithThrottleTimeout(10*time.Millisecond))
+	handler := th.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(done)
+	}()
+	waitFor(t, func() bool { return len(th.tokens) == 1 })
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("queued request got %d after the timeout, want 503", rec.Code)
+	}
+
+	close(release)
+	<-done
+}
+
+func TestThrottle_ReleasesOnPanic(t *testing.T) {
+	th := newThrottler(1)
+	handler := th.middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+
+	func() {
+		defer func() { _ = recover() }()
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}()
+
+	if len(th.tokens) != 0 || len(th.backlog) != 0 {
+		t.Errorf("slots not released after panic: tokens=%d backlog=%d", len(th.tokens), len(th.backlog))
+	}
+}
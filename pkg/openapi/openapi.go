@@ -0,0 +1,467 @@
+// Package openapi validates requests against an OpenAPI 3 document. The
+// Validator maps the route serving a request, see ags.RoutePattern, to the
+// operation of the document with the same path and method, e.g. the route
+// "/users/:id" to the path "/users/{id}", and checks the query parameters
+// and the JSON request body. Violations are reported as an ags.AppError with
+// the ErrCodeValidation code and one field detail each:
+//
+//	v, err := openapi.LoadFile("api/openapi.json", openapi.WithBasePath("/api"))
+//	if err != nil {
+//		return err
+//	}
+//	h.AddPreRequestFunc(v.PreRequest())
+//
+// Documents must be JSON. Only the schema keywords listed on Schema are
+// enforced; path parameters and other request parts are not validated yet.
+package openapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/getangry/ags"
+)
+
+// Document is the subset of an OpenAPI 3 document used for validation
+type Document struct {
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+// Components holds the reusable objects of a document referenced with $ref
+type Components struct {
+	Schemas    map[string]*Schema    `json:"schemas"`
+	Parameters map[string]*Parameter `json:"parameters"`
+}
+
+// PathItem describes the operations available on a path
+type PathItem struct {
+	Parameters []*Parameter `json:"parameters"`
+	Get        *Operation   `json:"get"`
+	Put        *Operation   `json:"put"`
+	Post       *Operation   `json:"post"`
+	Delete     *Operation   `json:"delete"`
+	Options    *Operation   `json:"options"`
+	Head       *Operation   `json:"head"`
+	Patch      *Operation   `json:"patch"`
+}
+
+// Operation describes a single API operation on a path
+type Operation struct {
+	OperationID string       `json:"operationId"`
+	Parameters  []*Parameter `json:"parameters"`
+	RequestBody *RequestBody `json:"requestBody"`
+}
+
+// Parameter describes a single operation parameter
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody describes the request body of an operation
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+// MediaType holds the schema of a request body content type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of a JSON schema enforced by the Validator
+type Schema struct {
+	Ref        string             `json:"$ref"`
+	Type       string             `json:"type"`
+	Nullable   bool               `json:"nullable"`
+	Enum       []interface{}      `json:"enum"`
+	Required   []string           `json:"required"`
+	Properties map[string]*Schema `json:"properties"`
+	Items      *Schema            `json:"items"`
+	Minimum    *float64           `json:"minimum"`
+	Maximum    *float64           `json:"maximum"`
+	MinLength  *int               `json:"minLength"`
+	MaxLength  *int               `json:"maxLength"`
+	MinItems   *int               `json:"minItems"`
+	MaxItems   *int               `json:"maxItems"`
+	Pattern    string             `json:"pattern"`
+
+	pattern *regexp.Regexp
+}
+
+// Option configures a Validator
+type Option func(*Validator)
+
+// WithBasePath sets the prefix routes have over the document paths, e.g.
+// "/api/v1" when the document describes "/users" served at /api/v1/users
+func WithBasePath(prefix string) Option {
+	return func(v *Validator) {
+		v.basePath = strings.TrimRight(prefix, "/")
+	}
+}
+
+// Validator checks requests against the operations of a Document
+type Validator struct {
+	doc        *Document
+	basePath   string
+	operations map[string]*operation
+}
+
+// operation is a document operation with the parameters of its path item
+// merged in
+type operation struct {
+	params []*Parameter
+	body   *RequestBody
+}
+
+// LoadFile reads the JSON OpenAPI document at path, see Load
+func LoadFile(path string, opts ...Option) (*Validator, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("openapi: %w", err)
+	}
+	return Load(data, opts...)
+}
+
+// Load parses a JSON OpenAPI document and returns a Validator for it. It
+// fails when a $ref cannot be resolved or a pattern does not compile.
+func Load(data []byte, opts ...Option) (*Validator, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc Document
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("openapi: decoding document: %w", err)
+	}
+	return New(&doc, opts...)
+}
+
+// New returns a Validator for doc
+func New(doc *Document, opts ...Option) (*Validator, error) {
+	v := &Validator{doc: doc, operations: make(map[string]*operation)}
+	for _, opt := range opts {
+		opt(v)
+	}
+
+	for name, s := range doc.Components.Schemas {
+		if err := v.compile(s); err != nil {
+			return nil, fmt.Errorf("openapi: schema %q: %w", name, err)
+		}
+	}
+	for path, item := range doc.Paths {
+		for method, op := range item.operations() {
+			merged, err := v.merge(item.Parameters, op)
+			if err != nil {
+				return nil, fmt.Errorf("openapi: %s %s: %w", method, path, err)
+			}
+			v.operations[method+" "+path] = merged
+		}
+	}
+	return v, nil
+}
+
+// operations returns the operations of the path item by HTTP method
+func (p *PathItem) operations() map[string]*Operation {
+	ops := make(map[string]*Operation)
+	for method, op := range map[string]*Operation{
+		http.MethodGet:     p.Get,
+		http.MethodPut:     p.Put,
+		http.MethodPost:    p.Post,
+		http.MethodDelete:  p.Delete,
+		http.MethodOptions: p.Options,
+		http.MethodHead:    p.Head,
+		http.MethodPatch:   p.Patch,
+	} {
+		if op != nil {
+			ops[method] = op
+		}
+	}
+	return ops
+}
+
+// merge resolves the parameters of op and of its path item, the former
+// overriding the latter, and compiles their schemas
+func (v *Validator) merge(shared []*Parameter, op *Operation) (*operation, error) {
+	merged := &operation{body: op.RequestBody}
+	index := make(map[string]int)
+	for _, p := range append(append([]*Parameter(nil), shared...), op.Parameters...) {
+		p, err := v.parameter(p)
+		if err != nil {
+			return nil, err
+		}
+		if err := v.compile(p.Schema); err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", p.Name, err)
+		}
+		key := p.In + " " + p.Name
+		if i, ok := index[key]; ok {
+			merged.params[i] = p
+			continue
+		}
+		index[key] = len(merged.params)
+		merged.params = append(merged.params, p)
+	}
+
+	if op.RequestBody != nil {
+		for contentType, mt := range op.RequestBody.Content {
+			if err := v.compile(mt.Schema); err != nil {
+				return nil, fmt.Errorf("request body %s: %w", contentType, err)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// parameter resolves a parameter $ref
+func (v *Validator) parameter(p *Parameter) (*Parameter, error) {
+	if p.Ref == "" {
+		return p, nil
+	}
+	name, ok := strings.CutPrefix(p.Ref, "#/components/parameters/")
+	if resolved := v.doc.Components.Parameters[name]; ok && resolved != nil {
+		return resolved, nil
+	}
+	return nil, fmt.Errorf("unresolved reference %q", p.Ref)
+}
+
+// schema resolves a schema $ref
+func (v *Validator) schema(s *Schema) (*Schema, error) {
+	for seen := 0; s != nil && s.Ref != ""; seen++ {
+		name, ok := strings.CutPrefix(s.Ref, "#/components/schemas/")
+		resolved := v.doc.Components.Schemas[name]
+		if !ok || resolved == nil || seen > len(v.doc.Components.Schemas) {
+			return nil, fmt.Errorf("unresolved reference %q", s.Ref)
+		}
+		s = resolved
+	}
+	return s, nil
+}
+
+// compile checks the references of s and compiles its patterns. Referenced
+// schemas are compiled on their own, which keeps recursive schemas finite.
+func (v *Validator) compile(s *Schema) error {
+	if s == nil {
+		return nil
+	}
+	if s.Ref != "" {
+		_, err := v.schema(s)
+		return err
+	}
+	if s.Pattern != "" && s.pattern == nil {
+		re, err := regexp.Compile(s.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %w", err)
+		}
+		s.pattern = re
+	}
+	for _, prop := range s.Properties {
+		if err := v.compile(prop); err != nil {
+			return err
+		}
+	}
+	return v.compile(s.Items)
+}
+
+// PreRequest returns a pre-request function validating every request, see
+// ags.Handler.AddPreRequestFunc
+func (v *Validator) PreRequest() ags.PreRequestFunc {
+	return func(ctx context.Context, w http.ResponseWriter, r *http.Request) (context.Context, error) {
+		return ctx, v.Validate(r)
+	}
+}
+
+// Middleware returns a middleware validating the requests of a route group,
+// rendering violations with h
+func (v *Validator) Middleware(h *ags.Handler) ags.Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := v.Validate(r); err != nil {
+				h.ErrorCtx(w, r, err)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// Validate checks r against the operation of its route. Requests whose
+// route or method the document does not describe pass. The request body is
+// read for validation and replaced so handlers can still decode it.
+func (v *Validator) Validate(r *http.Request) error {
+	op, ok := v.operations[r.Method+" "+v.specPath(ags.RoutePattern(r.Context()))]
+	if !ok {
+		return nil
+	}
+
+	var violations []violation
+	query := r.URL.Query()
+	for _, p := range op.params {
+		if p.In == "query" {
+			violations = append(violations, v.validateQuery(p, query[p.Name])...)
+		}
+	}
+
+	if op.body != nil {
+		bodyViolations, err := v.validateBody(r, op.body)
+		if err != nil {
+			return err
+		}
+		violations = append(violations, bodyViolations...)
+	}
+
+	if len(violations) == 0 {
+		return nil
+	}
+	appErr := ags.NewError(ags.ErrCodeValidation, "Request does not match the API specification").
+		WithContext(r.Context())
+	for _, vi := range violations {
+		appErr.WithField(vi.field, vi.message)
+	}
+	return appErr
+}
+
+// specPath converts a route pattern to its document path, e.g.
+// "/users/:id(int)" to "/users/{id}". A host the route is bound to and the
+// base path are left out.
+func (v *Validator) specPath(pattern string) string {
+	if i := strings.IndexByte(pattern, '/'); i > 0 {
+		pattern = pattern[i:]
+	}
+	pattern = strings.TrimPrefix(pattern, v.basePath)
+
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		name, ok := strings.CutPrefix(part, ":")
+		if !ok {
+			continue
+		}
+		if j := strings.IndexByte(name, '('); j >= 0 {
+			name = name[:j]
+		}
+		parts[i] = "{" + name + "}"
+	}
+	if path := strings.Join(parts, "/"); path != "" {
+		return path
+	}
+	return "/"
+}
+
+// validateQuery checks the values of a query parameter
+func (v *Validator) validateQuery(p *Parameter, values []string) []violation {
+	field := "query." + p.Name
+	if len(values) == 0 {
+		if p.Required {
+			return []violation{{field, "is required"}}
+		}
+		return nil
+	}
+
+	schema, err := v.schema(p.Schema)
+	if err != nil || schema == nil {
+		return nil
+	}
+	if schema.Type != "array" {
+		value, vi := queryValue(schema, values[0], field)
+		if vi != nil {
+			return []violation{*vi}
+		}
+		return v.validate(schema, value, field)
+	}
+
+	items, err := v.schema(schema.Items)
+	if err != nil {
+		return nil
+	}
+	list := make([]interface{}, 0, len(values))
+	for i, s := range values {
+		value, vi := queryValue(items, s, fmt.Sprintf("%s[%d]", field, i))
+		if vi != nil {
+			return []violation{*vi}
+		}
+		list = append(list, value)
+	}
+	return v.validate(schema, list, field)
+}
+
+// queryValue converts a query string value to the JSON value of its schema
+// type
+func queryValue(s *Schema, value, field string) (interface{}, *violation) {
+	if s == nil {
+		return value, nil
+	}
+	switch s.Type {
+	case "integer", "number":
+		n := json.Number(value)
+		if _, err := n.Float64(); err != nil {
+			return nil, &violation{field, "must be " + article(s.Type)}
+		}
+		return n, nil
+	case "boolean":
+		switch value {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+		return nil, &violation{field, "must be a boolean"}
+	}
+	return value, nil
+}
+
+// validateBody checks the JSON request body. Bodies of other content types
+// are not validated.
+func (v *Validator) validateBody(r *http.Request, body *RequestBody) ([]violation, error) {
+	var data []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		var err error
+		data, err = io.ReadAll(r.Body)
+		r.Body.Close()
+		if err != nil {
+			return nil, ags.NewError(ags.ErrCodeBadRequest, "Failed to read request body").
+				WithError(err).
+				WithContext(r.Context())
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+	}
+	if len(data) == 0 {
+		if body.Required {
+			return []violation{{"body", "is required"}}, nil
+		}
+		return nil, nil
+	}
+
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !isJSON(mediaType) {
+		return nil, nil
+	}
+	mt := body.Content[mediaType]
+	if mt == nil {
+		mt = body.Content["application/json"]
+	}
+	if mt == nil || mt.Schema == nil {
+		return nil, nil
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var value interface{}
+	if err := dec.Decode(&value); err != nil {
+		return []violation{{"body", "must be valid JSON"}}, nil
+	}
+	return v.validate(mt.Schema, value, "body"), nil
+}
+
+// isJSON reports whether mediaType is application/json or a +json type
+func isJSON(mediaType string) bool {
+	return mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+}
@@ -0,0 +1,200 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getangry/ags"
+)
+
+const testDocument = `{
+	"openapi": "3.0.3",
+	"paths": {
+		"/users": {
+			"get": {
+				"parameters": [
+					{"name": "limit", "in": "query", "required": true, "schema": {"type": "integer", "minimum": 1, "maximum": 100}},
+					{"$ref": "#/components/parameters/Sort"}
+				]
+			},
+			"post": {
+				"requestBody": {
+					"required": true,
+					"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}
+				}
+			}
+		},
+		"/users/{id}": {
+			"get": {
+				"parameters": [{"name": "fields", "in": "query", "schema": {"type": "array", "items": {"type": "string", "enum": ["name", "email"]}}}]
+			}
+		}
+	},
+	"components": {
+		"parameters": {
+			"Sort": {"name": "sort", "in": "query", "schema": {"type": "string", "enum": ["asc", "desc"]}}
+		},
+		"schemas": {
+			"User": {
+				"type": "object",
+				"required": ["name", "email"],
+				"properties": {
+					"name": {"type": "string", "minLength": 1},
+					"email": {"type": "string", "pattern": "^[^@]+@[^@]+$"},
+					"age": {"type": "integer", "minimum": 0},
+					"tags": {"type": "array", "maxItems": 2, "items": {"type": "string"}}
+				}
+			}
+		}
+	}
+}`
+
+// errorResponse is the part of the error envelope the tests check
+type errorResponse struct {
+	Error struct {
+		Code    ags.ErrorCode `json:"code"`
+		Details []struct {
+			Field   string `json:"field"`
+			Message string `json:"message"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+func newTestHandler(t *testing.T) *ags.Handler {
+	t.Helper()
+	v, err := Load([]byte(testDocument), WithBasePath("/api"))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	h := ags.NewHandler(&ags.ServerConfig{Log: ags.NopLogger{}, ErrorDetails: ags.ErrorDetailsFields})
+	api := h.Group("/api", v.Middleware(h))
+	ok := func(w http.ResponseWriter, r *http.Request) {
+		// The body is still readable after validation
+		var body map[string]interface{}
+		if r.Method == http.MethodPost {
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("decoding validated body: %v", err)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	}
+	api.Route("/users", ok, http.MethodGet, http.MethodPost)
+	api.Get("/users/:id(int)", ok)
+	api.Get("/undocumented", ok)
+	return h
+}
+
+func TestValidator(t *testing.T) {
+	h := newTestHandler(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantFields []string
+	}{
+		{name: "valid query", method: http.MethodGet, target: "/api/users?limit=10&sort=asc", wantStatus: http.StatusOK},
+		{name: "missing required query", method: http.MethodGet, target: "/api/users", wantStatus: http.StatusBadRequest, wantFields: []string{"query.limit"}},
+		{name: "query not an integer", method: http.MethodGet, target: "/api/users?limit=ten", wantStatus: http.StatusBadRequest, wantFields: []string{"query.limit"}},
+		{name: "query over maximum", method: http.MethodGet, target: "/api/users?limit=500&sort=up", wantStatus: http.StatusBadRequest, wantFields: []string{"query.limit", "query.sort"}},
+		{name: "array query", method: http.MethodGet, target: "/api/users/5?fields=name&fields=email", wantStatus: http.StatusOK},
+		{name: "array query item", method: http.MethodGet, target: "/api/users/5?fields=name&fields=phone", wantStatus: http.StatusBadRequest, wantFields: []string{"query.fields[1]"}},
+		{name: "valid body", method: http.MethodPost, target: "/api/users", body: `{"name":"Ada","email":"ada@example.com","age":36}`, wantStatus: http.StatusOK},
+		{name: "missing body", method: http.MethodPost, target: "/api/users", wantStatus: http.StatusBadRequest, wantFields: []string{"body"}},
+		{name: "invalid body", method: http.MethodPost, target: "/api/users", body: `{"name":"","email":"ada","age":1.5,"tags":["a","b","c"]}`, wantStatus: http.StatusBadRequest, wantFields: []string{"body.age", "body.email", "body.name", "body.tags"}},
+		{name: "missing body fields", method: http.MethodPost, target: "/api/users", body: `{}`, wantStatus: http.StatusBadRequest, wantFields: []string{"body.name", "body.email"}},
+		{name: "malformed body", method: http.MethodPost, target: "/api/users", body: `{`, wantStatus: http.StatusBadRequest, wantFields: []string{"body"}},
+		{name: "undocumented route", method: http.MethodGet, target: "/api/undocumented", wantStatus: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if tt.wantFields == nil {
+				return
+			}
+
+			var resp errorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decoding response: %v", err)
+			}
+			if resp.Error.Code != ags.ErrCodeValidation {
+				t.Errorf("code = %s, want %s", resp.Error.Code, ags.ErrCodeValidation)
+			}
+			var fields []string
+			for _, d := range resp.Error.Details {
+				fields = append(fields, d.Field)
+			}
+			if strings.Join(fields, ",") != strings.Join(tt.wantFields, ",") {
+				t.Errorf("fields = %v, want %v", fields, tt.wantFields)
+			}
+		})
+	}
+}
+
+func TestValidator_PreRequest(t *testing.T) {
+	v, err := Load([]byte(testDocument))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	h := ags.NewHandler(&ags.ServerConfig{Log: ags.NopLogger{}})
+	h.AddPreRequestFunc(v.PreRequest())
+	h.Get("/users", func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/users", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", rec.Code)
+	}
+}
+
+func TestLoad_Errors(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+	}{
+		{name: "malformed", doc: `{`},
+		{name: "unresolved schema", doc: `{"paths":{"/a":{"post":{"requestBody":{"content":{"application/json":{"schema":{"$ref":"#/components/schemas/Missing"}}}}}}}}`},
+		{name: "unresolved parameter", doc: `{"paths":{"/a":{"get":{"parameters":[{"$ref":"#/components/parameters/Missing"}]}}}}`},
+		{name: "invalid pattern", doc: `{"components":{"schemas":{"A":{"type":"string","pattern":"("}}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Load([]byte(tt.doc)); err == nil {
+				t.Error("Load succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestValidator_SpecPath(t *testing.T) {
+	v := &Validator{basePath: "/api"}
+	tests := map[string]string{
+		"/api/users/:id(int)":           "/users/{id}",
+		"/api/users/:id/posts/:post":    "/users/{id}/posts/{post}",
+		"api.example.com/api/users/:id": "/users/{id}",
+		"/api":                          "/",
+		"/other":                        "/other",
+	}
+	for pattern, want := range tests {
+		if got := v.specPath(pattern); got != want {
+			t.Errorf("specPath(%q) = %q, want %q", pattern, got, want)
+		}
+	}
+}
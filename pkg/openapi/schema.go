@@ -0,0 +1,176 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// violation is a part of a request not matching its schema
+type violation struct {
+	field   string
+	message string
+}
+
+// validate checks a decoded JSON value against s. Numbers are json.Number
+// values.
+func (v *Validator) validate(s *Schema, value interface{}, field string) []violation {
+	s, err := v.schema(s)
+	if err != nil || s == nil {
+		return nil
+	}
+
+	if value == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return []violation{{field, "must not be null"}}
+	}
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		return []violation{{field, "must be one of " + formatEnum(s.Enum)}}
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return []violation{{field, "must be an object"}}
+		}
+		return v.validateObject(s, obj, field)
+	case "array":
+		list, ok := value.([]interface{})
+		if !ok {
+			return []violation{{field, "must be an array"}}
+		}
+		return v.validateArray(s, list, field)
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			return []violation{{field, "must be a string"}}
+		}
+		return validateString(s, str, field)
+	case "integer", "number":
+		n, ok := value.(json.Number)
+		if !ok {
+			return []violation{{field, "must be " + article(s.Type)}}
+		}
+		return validateNumber(s, n, field)
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			return []violation{{field, "must be a boolean"}}
+		}
+	}
+	return nil
+}
+
+func (v *Validator) validateObject(s *Schema, obj map[string]interface{}, field string) []violation {
+	var violations []violation
+	for _, name := range s.Required {
+		if _, ok := obj[name]; !ok {
+			violations = append(violations, violation{field + "." + name, "is required"})
+		}
+	}
+
+	// Sorted so violations are reported in a stable order
+	names := make([]string, 0, len(s.Properties))
+	for name := range s.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := obj[name]; ok {
+			violations = append(violations, v.validate(s.Properties[name], value, field+"."+name)...)
+		}
+	}
+	return violations
+}
+
+func (v *Validator) validateArray(s *Schema, list []interface{}, field string) []violation {
+	if s.MinItems != nil && len(list) < *s.MinItems {
+		return []violation{{field, fmt.Sprintf("must have at least %d items", *s.MinItems)}}
+	}
+	if s.MaxItems != nil && len(list) > *s.MaxItems {
+		return []violation{{field, fmt.Sprintf("must have at most %d items", *s.MaxItems)}}
+	}
+
+	var violations []violation
+	for i, item := range list {
+		violations = append(violations, v.validate(s.Items, item, fmt.Sprintf("%s[%d]", field, i))...)
+	}
+	return violations
+}
+
+func validateString(s *Schema, str, field string) []violation {
+	n := utf8.RuneCountInString(str)
+	if s.MinLength != nil && n < *s.MinLength {
+		return []violation{{field, fmt.Sprintf("must be at least %d characters", *s.MinLength)}}
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		return []violation{{field, fmt.Sprintf("must be at most %d characters", *s.MaxLength)}}
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		return []violation{{field, "must match " + s.Pattern}}
+	}
+	return nil
+}
+
+func validateNumber(s *Schema, n json.Number, field string) []violation {
+	f, err := n.Float64()
+	if err != nil {
+		return []violation{{field, "must be " + article(s.Type)}}
+	}
+	if s.Type == "integer" && f != float64(int64(f)) {
+		return []violation{{field, "must be an integer"}}
+	}
+	if s.Minimum != nil && f < *s.Minimum {
+		return []violation{{field, fmt.Sprintf("must be at least %v", *s.Minimum)}}
+	}
+	if s.Maximum != nil && f > *s.Maximum {
+		return []violation{{field, fmt.Sprintf("must be at most %v", *s.Maximum)}}
+	}
+	return nil
+}
+
+// inEnum reports whether value is one of the enum values. Numbers compare
+// by value.
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if normalize(e) == normalize(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize makes a scalar JSON value comparable, turning numbers into
+// float64
+func normalize(value interface{}) interface{} {
+	switch x := value.(type) {
+	case json.Number:
+		if f, err := x.Float64(); err == nil {
+			return f
+		}
+		return x.String()
+	case string, bool, float64:
+		return x
+	}
+	return fmt.Sprint(value)
+}
+
+func formatEnum(enum []interface{}) string {
+	values := make([]string, len(enum))
+	for i, e := range enum {
+		values[i] = fmt.Sprint(e)
+	}
+	return strings.Join(values, ", ")
+}
+
+// article prefixes a numeric type name with its indefinite article
+func article(typ string) string {
+	if typ == "integer" {
+		return "an " + typ
+	}
+	return "a " + typ
+}
@@ -0,0 +1,70 @@
+package queryfilter
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Cursor marks the position after the last item of a page in keyset
+// pagination: the next page holds the items whose sort key comes after
+// Value. The sort key must be unique, e.g. a sequential id or a timestamp
+// with a unique tie-breaker folded in, or items sharing a key may be skipped.
+type Cursor struct {
+	Field string      `json:"f"`
+	Value interface{} `json:"v"`
+	Desc  bool        `json:"d,omitempty"`
+}
+
+// EncodeCursor returns the opaque, URL-safe form of c sent to clients
+func EncodeCursor(c Cursor) (string, error) {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// DecodeCursor parses a cursor produced by EncodeCursor. Values come back
+// with their JSON types, e.g. timestamps as RFC 3339 strings.
+func DecodeCursor(s string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	var c Cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	if c.Field == "" {
+		return Cursor{}, fmt.Errorf("invalid cursor: missing field")
+	}
+	return c, nil
+}
+
+// Filter returns the keyset condition selecting the items after c, e.g.
+// created_at < ? for a descending sort, to be combined with the other
+// filters of the query and lowered with ToSQL
+func (c Cursor) Filter() Filter {
+	op := Gt
+	if c.Desc {
+		op = Lt
+	}
+	return Filter{Field: c.Field, Operator: op, Value: c.Value}
+}
+
+// ParseCursor reads the cursor query parameter of r. It returns nil when the
+// request asks for the first page. ParseQueryFilters does not know about the
+// parameter, so drop the "cursor" filter it returns before calling ToSQL.
+func ParseCursor(r *http.Request) (*Cursor, error) {
+	s := r.URL.Query().Get("cursor")
+	if s == "" {
+		return nil, nil
+	}
+	c, err := DecodeCursor(s)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
@@ -0,0 +1,95 @@
+package queryfilter
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestCursor_NextPage(t *testing.T) {
+	type item struct{ ID float64 }
+	items := []item{{9}, {7}, {5}, {4}, {2}} // sorted by id, descending
+
+	// page returns up to size items after the cursor, applying its filter
+	page := func(c *Cursor, size int) []item {
+		var out []item
+		for _, it := range items {
+			if c != nil {
+				f := c.Filter()
+				bound := f.Value.(float64)
+				if (f.Operator == Lt && it.ID >= bound) || (f.Operator == Gt && it.ID <= bound) {
+					continue
+				}
+			}
+			if len(out) == size {
+				break
+			}
+			out = append(out, it)
+		}
+		return out
+	}
+
+	first := page(nil, 2)
+	if !reflect.DeepEqual(first, []item{{9}, {7}}) {
+		t.Fatalf("first page = %v", first)
+	}
+
+	cursor, err := EncodeCursor(Cursor{Field: "id", Value: first[len(first)-1].ID, Desc: true})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	r := httptest.NewRequest("GET", "/items?cursor="+cursor, nil)
+	c, err := ParseCursor(r)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := c.Filter(); got != (Filter{Field: "id", Operator: Lt, Value: float64(7)}) {
+		t.Errorf("Filter() = %#v", got)
+	}
+
+	second := page(c, 2)
+	if !reflect.DeepEqual(second, []item{{5}, {4}}) {
+		t.Errorf("second page = %v, want [{5} {4}]", second)
+	}
+
+	sql, args, err := ToSQL([]Filter{c.Filter()}, SQLite)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sql != "id < ?" || !reflect.DeepEqual(args, []interface{}{float64(7)}) {
+		t.Errorf("ToSQL() = %q %v, want \"id < ?\" [7]", sql, args)
+	}
+}
+
+func TestParseCursor(t *testing.T) {
+	tests := []struct {
+		name      string
+		query     string
+		wantNil   bool
+		wantError bool
+	}{
+		{name: "first page", query: "", wantNil: true},
+		{name: "not base64", query: "cursor=%21%21", wantError: true},
+		{name: "not json", query: "cursor=bm9wZQ", wantError: true},
+		{name: "missing field", query: "cursor=eyJ2IjoxfQ", wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := ParseCursor(httptest.NewRequest("GET", "/items?"+tt.query, nil))
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("Expected an error, but got nil")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if (c == nil) != tt.wantNil {
+				t.Errorf("ParseCursor() = %v, want nil = %v", c, tt.wantNil)
+			}
+		})
+	}
+}
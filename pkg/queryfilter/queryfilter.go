@@ -19,6 +19,7 @@ const (
 	Lte              Operator = "<="
 	Like             Operator = "LIKE"
 	ILike            Operator = "ILIKE"
+	IEq              Operator = "ieq"
 	Contains         Operator = "contains"
 	Includes         Operator = "includes"
 	DoesNotContain   Operator = "doesNotContain"
@@ -47,6 +48,9 @@ type Filter struct {
 // - "lt": Less than
 // - "lte": Less than or equal to
 // - "ne": Not equal to
+// - "ieq": Equal to, ignoring case
+// - "like": SQL LIKE pattern
+// - "ilike": SQL LIKE pattern, ignoring case
 // - "sw", "startswith": Starts with
 // - "ew", "endswith": Ends with
 // - "contains": Contains
@@ -70,6 +74,12 @@ func MapOperator(op string) Operator {
 		return Lte
 	case "ne":
 		return Ne
+	case "ieq":
+		return IEq
+	case "like":
+		return Like
+	case "ilike":
+		return ILike
 	case "sw", "startswith":
 		return StartsWith
 	case "ew", "endswith":
@@ -93,6 +103,22 @@ func MapOperator(op string) Operator {
 	}
 }
 
+// ParseOption configures ParseQueryString and ParseQueryFilters
+type ParseOption func(*parseOptions)
+
+type parseOptions struct {
+	useNumber bool
+}
+
+// WithUseNumber keeps numbers in JSON-based filters as json.Number instead of
+// float64, so 64-bit ids survive parsing. ToSQL binds them as int64 or
+// float64.
+func WithUseNumber() ParseOption {
+	return func(o *parseOptions) {
+		o.useNumber = true
+	}
+}
+
 // ParseQueryString parses a query string into a slice of Filter objects.
 // It supports three patterns for filters:
 // 1. Field-based filters with operators, e.g., "age[gt]=30".
@@ -101,11 +127,17 @@ func MapOperator(op string) Operator {
 //
 // Parameters:
 //   - queryString: The query string to parse.
+//   - opts: Parsing options, e.g. WithUseNumber.
 //
 // Returns:
 //   - A slice of Filter objects representing the parsed filters.
 //   - An error if the query string is invalid or cannot be parsed.
-func ParseQueryString(queryString string) ([]Filter, error) {
+func ParseQueryString(queryString string, opts ...ParseOption) ([]Filter, error) {
+	var o parseOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
 	values, err := url.ParseQuery(queryString)
 	if err != nil {
 		return nil, err
@@ -125,8 +157,11 @@ func ParseQueryString(queryString string) ([]Filter, error) {
 					// Second pattern (JSON)
 					var jsonFilter map[string]interface{}
 
-					err := json.Unmarshal([]byte(val), &jsonFilter)
-					if err != nil {
+					dec := json.NewDecoder(strings.NewReader(val))
+					if o.useNumber {
+						dec.UseNumber()
+					}
+					if err := dec.Decode(&jsonFilter); err != nil {
 						return nil, err
 					}
 					for op, value := range jsonFilter {
@@ -146,6 +181,6 @@ func ParseQueryString(queryString string) ([]Filter, error) {
 }
 
 // ParseQueryFilters parses the query string from the request and returns a slice of Filters.
-func ParseQueryFilters(r *http.Request) ([]Filter, error) {
-	return ParseQueryString(r.URL.RawQuery)
+func ParseQueryFilters(r *http.Request, opts ...ParseOption) ([]Filter, error) {
+	return ParseQueryString(r.URL.RawQuery, opts...)
 }
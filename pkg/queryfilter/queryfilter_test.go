@@ -116,6 +116,11 @@ func TestMapOperator(t *testing.T) {
 		{"LTE", Lte},
 		{"ne", Ne},
 		{"NE", Ne},
+		{"ieq", IEq},
+		{"IEQ", IEq},
+		{"like", Like},
+		{"ilike", ILike},
+		{"ILike", ILike},
 		{"sw", StartsWith},
 		{"SW", StartsWith},
 		{"ew", EndsWith},
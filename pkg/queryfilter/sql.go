@@ -0,0 +1,152 @@
+package queryfilter
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Dialect selects the SQL flavor produced by ToSQL
+type Dialect int
+
+const (
+	Postgres Dialect = iota
+	MySQL
+	SQLite
+)
+
+// identifierPattern matches the column names ToSQL accepts, optionally
+// qualified with a table name
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// likeEscaper escapes LIKE wildcards in values matched with the contains,
+// startsWith and endsWith operators
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// ToSQL lowers filters to a WHERE clause, without the WHERE keyword, joined
+// with AND, and returns the clause with its arguments. Placeholders are $1,
+// $2, ... for Postgres and ? otherwise. Case-insensitive operators use ILIKE
+// on Postgres and LOWER(col) LIKE LOWER(?) on dialects without it. Field
+// names must be plain or table-qualified identifiers; filters should be
+// checked against the columns a caller may query before calling ToSQL.
+func ToSQL(filters []Filter, d Dialect) (string, []interface{}, error) {
+	b := sqlBuilder{dialect: d}
+	clauses := make([]string, 0, len(filters))
+	for _, f := range filters {
+		clause, err := b.clause(f)
+		if err != nil {
+			return "", nil, err
+		}
+		clauses = append(clauses, clause)
+	}
+	return strings.Join(clauses, " AND "), b.args, nil
+}
+
+// sqlBuilder accumulates the arguments of a WHERE clause
+type sqlBuilder struct {
+	dialect Dialect
+	args    []interface{}
+}
+
+// bind adds v as an argument and returns its placeholder. json.Number values
+// are bound as int64 when they hold an integer and as float64 otherwise, since
+// database drivers do not accept them.
+func (b *sqlBuilder) bind(v interface{}) string {
+	if n, ok := v.(json.Number); ok {
+		v = numberValue(n)
+	}
+	b.args = append(b.args, v)
+	if b.dialect == Postgres {
+		return "$" + strconv.Itoa(len(b.args))
+	}
+	return "?"
+}
+
+func (b *sqlBuilder) clause(f Filter) (string, error) {
+	if !identifierPattern.MatchString(f.Field) {
+		return "", fmt.Errorf("invalid field name: %q", f.Field)
+	}
+	col := f.Field
+
+	switch f.Operator {
+	case Eq, Ne, Gt, Gte, Lt, Lte:
+		return fmt.Sprintf("%s %s %s", col, f.Operator, b.bind(f.Value)), nil
+	case Before:
+		return fmt.Sprintf("%s < %s", col, b.bind(f.Value)), nil
+	case After:
+		return fmt.Sprintf("%s > %s", col, b.bind(f.Value)), nil
+	case IEq:
+		return fmt.Sprintf("LOWER(%s) = LOWER(%s)", col, b.bind(f.Value)), nil
+	case Like:
+		return fmt.Sprintf("%s LIKE %s", col, b.bind(f.Value)), nil
+	case ILike:
+		if b.dialect == Postgres {
+			return fmt.Sprintf("%s ILIKE %s", col, b.bind(f.Value)), nil
+		}
+		return fmt.Sprintf("LOWER(%s) LIKE LOWER(%s)", col, b.bind(f.Value)), nil
+	case Contains, Includes:
+		return b.like(col, "%", f.Value, "%", false), nil
+	case DoesNotContain:
+		return b.like(col, "%", f.Value, "%", true), nil
+	case StartsWith:
+		return b.like(col, "", f.Value, "%", false), nil
+	case DoesNotStartWith:
+		return b.like(col, "", f.Value, "%", true), nil
+	case EndsWith:
+		return b.like(col, "%", f.Value, "", false), nil
+	case DoesNotEndWith:
+		return b.like(col, "%", f.Value, "", true), nil
+	case Between:
+		lo, hi, err := betweenBounds(f.Value)
+		if err != nil {
+			return "", fmt.Errorf("field %s: %w", f.Field, err)
+		}
+		return fmt.Sprintf("%s BETWEEN %s AND %s", col, b.bind(lo), b.bind(hi)), nil
+	default:
+		return "", fmt.Errorf("unsupported operator %q for field %s", f.Operator, f.Field)
+	}
+}
+
+// like builds a LIKE clause matching value literally between prefix and suffix
+func (b *sqlBuilder) like(col, prefix string, value interface{}, suffix string, negate bool) string {
+	op := "LIKE"
+	if negate {
+		op = "NOT LIKE"
+	}
+	pattern := prefix + likeEscaper.Replace(fmt.Sprint(value)) + suffix
+	return fmt.Sprintf(`%s %s %s ESCAPE '\'`, col, op, b.bind(pattern))
+}
+
+// betweenBounds extracts the bounds of a between filter, given either as a
+// two-element list or as a "low,high" string
+func betweenBounds(v interface{}) (interface{}, interface{}, error) {
+	switch bounds := v.(type) {
+	case []interface{}:
+		if len(bounds) == 2 {
+			return bounds[0], bounds[1], nil
+		}
+	case []string:
+		if len(bounds) == 2 {
+			return bounds[0], bounds[1], nil
+		}
+	case string:
+		if lo, hi, ok := strings.Cut(bounds, ","); ok {
+			return lo, hi, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("between needs two bounds, got %v", v)
+}
+
+// numberValue converts a json.Number to int64 or float64, falling back to its
+// text when it is neither
+func numberValue(n json.Number) interface{} {
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return n.String()
+}
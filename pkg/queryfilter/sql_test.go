@@ -0,0 +1,125 @@
+package queryfilter
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestToSQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		filters  []Filter
+		dialect  Dialect
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "comparison on postgres",
+			filters:  []Filter{{Field: "age", Operator: Gte, Value: "18"}, {Field: "u.name", Operator: Eq, Value: "Ada"}},
+			dialect:  Postgres,
+			wantSQL:  "age >= $1 AND u.name = $2",
+			wantArgs: []interface{}{"18", "Ada"},
+		},
+		{
+			name:     "ilike on postgres",
+			filters:  []Filter{{Field: "name", Operator: ILike, Value: "ad%"}},
+			dialect:  Postgres,
+			wantSQL:  "name ILIKE $1",
+			wantArgs: []interface{}{"ad%"},
+		},
+		{
+			name:     "ilike on sqlite",
+			filters:  []Filter{{Field: "name", Operator: ILike, Value: "ad%"}},
+			dialect:  SQLite,
+			wantSQL:  "LOWER(name) LIKE LOWER(?)",
+			wantArgs: []interface{}{"ad%"},
+		},
+		{
+			name:     "ilike on mysql",
+			filters:  []Filter{{Field: "name", Operator: ILike, Value: "ad%"}},
+			dialect:  MySQL,
+			wantSQL:  "LOWER(name) LIKE LOWER(?)",
+			wantArgs: []interface{}{"ad%"},
+		},
+		{
+			name:     "ieq on sqlite",
+			filters:  []Filter{{Field: "email", Operator: IEq, Value: "Ada@Example.com"}},
+			dialect:  SQLite,
+			wantSQL:  "LOWER(email) = LOWER(?)",
+			wantArgs: []interface{}{"Ada@Example.com"},
+		},
+		{
+			name:     "contains escapes wildcards",
+			filters:  []Filter{{Field: "title", Operator: Contains, Value: "50%_off"}},
+			dialect:  SQLite,
+			wantSQL:  `title LIKE ? ESCAPE '\'`,
+			wantArgs: []interface{}{`%50\%\_off%`},
+		},
+		{
+			name:     "between",
+			filters:  []Filter{{Field: "price", Operator: Between, Value: "10,20"}},
+			dialect:  Postgres,
+			wantSQL:  "price BETWEEN $1 AND $2",
+			wantArgs: []interface{}{"10", "20"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := ToSQL(tt.filters, tt.dialect)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("ToSQL() sql = %q, want %q", sql, tt.wantSQL)
+			}
+			if !reflect.DeepEqual(args, tt.wantArgs) {
+				t.Errorf("ToSQL() args = %#v, want %#v", args, tt.wantArgs)
+			}
+		})
+	}
+}
+
+func TestToSQL_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter Filter
+	}{
+		{name: "injected field", filter: Filter{Field: "name; DROP TABLE users", Operator: Eq, Value: "x"}},
+		{name: "between without bounds", filter: Filter{Field: "price", Operator: Between, Value: "10"}},
+		{name: "unknown operator", filter: Filter{Field: "price", Operator: Operator("near"), Value: "10"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := ToSQL([]Filter{tt.filter}, SQLite); err == nil {
+				t.Errorf("Expected an error, but got nil")
+			}
+		})
+	}
+}
+
+func TestToSQL_UseNumber(t *testing.T) {
+	filters, err := ParseQueryString(`id={"eq":9007199254740993}&price={"lt":9.5}`, WithUseNumber())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	sort.Slice(filters, func(i, j int) bool { return filters[i].Field < filters[j].Field })
+
+	if filters[0].Value != json.Number("9007199254740993") {
+		t.Errorf("Expected id to stay a json.Number, got %#v", filters[0].Value)
+	}
+
+	sql, args, err := ToSQL(filters, Postgres)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if sql != "id = $1 AND price < $2" {
+		t.Errorf("Expected id = $1 AND price < $2, got %q", sql)
+	}
+	if !reflect.DeepEqual(args, []interface{}{int64(9007199254740993), 9.5}) {
+		t.Errorf("Expected args [9007199254740993 9.5], got %#v", args)
+	}
+}
@@ -0,0 +1,129 @@
+package tty
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// StatusLine renders a single line of status output. On a terminal the line
+// is redrawn in place using a carriage return and fitted to the terminal
+// width; otherwise every update is appended as a plain line so logs and
+// pipes stay readable.
+type StatusLine struct {
+	mu    sync.Mutex
+	w     io.Writer
+	width int
+	tty   bool
+	dirty bool
+}
+
+// NewStatusLine creates a StatusLine for f, detecting whether it refers to a
+// terminal and, if so, its width.
+func NewStatusLine(f *os.File) *StatusLine {
+	fd := f.Fd()
+	isTTY := IsTTY(fd)
+
+	width := 0
+	if isTTY {
+		width, _ = SizeOrDefault(fd, DefaultWidth, DefaultHeight)
+	}
+	return NewStatusLineWriter(f, width, isTTY)
+}
+
+// NewStatusLineWriter creates a StatusLine writing to w with an explicit
+// width and terminal mode. A width of zero disables fitting.
+func NewStatusLineWriter(w io.Writer, width int, isTTY bool) *StatusLine {
+	return &StatusLine{
+		w:     w,
+		width: width,
+		tty:   isTTY,
+	}
+}
+
+// Update replaces the current status text
+func (s *StatusLine) Update(text string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.tty {
+		_, err := fmt.Fprintln(s.w, text)
+		return err
+	}
+
+	s.dirty = true
+	_, err := fmt.Fprint(s.w, "\r"+fit(text, s.width))
+	return err
+}
+
+// Progress renders a progress bar for done out of total, prefixed by label,
+// sized to fill the available width
+func (s *StatusLine) Progress(label string, done, total int) error {
+	return s.Update(renderProgress(label, done, total, s.width))
+}
+
+// Done terminates the status line so subsequent output starts on a new line.
+// It is a no-op when nothing has been drawn or when not writing to a terminal.
+func (s *StatusLine) Done() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.tty || !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	_, err := fmt.Fprintln(s.w)
+	return err
+}
+
+// fit truncates or pads text to width-1 columns. The last column is left
+// free so the terminal does not wrap the cursor onto the next line.
+func fit(text string, width int) string {
+	if width <= 1 {
+		return text
+	}
+	limit := width - 1
+
+	n := utf8.RuneCountInString(text)
+	if n > limit {
+		runes := []rune(text)
+		if limit > 1 {
+			return string(runes[:limit-1]) + "…"
+		}
+		return string(runes[:limit])
+	}
+	return text + strings.Repeat(" ", limit-n)
+}
+
+// renderProgress builds a "label [=====>    ] 42%" style bar for the given width
+func renderProgress(label string, done, total, width int) string {
+	if total <= 0 {
+		total = 1
+	}
+	if done > total {
+		done = total
+	}
+	if done < 0 {
+		done = 0
+	}
+	percent := done * 100 / total
+	suffix := fmt.Sprintf(" %3d%%", percent)
+
+	barWidth := 20
+	if width > 1 {
+		barWidth = width - 1 - utf8.RuneCountInString(label) - len(suffix) - 3
+	}
+	if barWidth < 1 {
+		return label + suffix
+	}
+
+	filled := barWidth * done / total
+	bar := strings.Repeat("=", filled)
+	if filled < barWidth {
+		bar += ">" + strings.Repeat(" ", barWidth-filled-1)
+	}
+	return fmt.Sprintf("%s [%s]%s", label, bar, suffix)
+}
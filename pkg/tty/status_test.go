@@ -0,0 +1,70 @@
+package tty
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestStatusLineFitsWidth(t *testing.T) {
+	var buf bytes.Buffer
+	status := NewStatusLineWriter(&buf, 20, true)
+
+	if err := status.Update("this status message is far too long for the terminal"); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+	if err := status.Update("short"); err != nil {
+		t.Fatalf("Update() error = %v", err)
+	}
+
+	frames := strings.Split(buf.String(), "\r")[1:]
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 frames, got %d: %q", len(frames), buf.String())
+	}
+	for _, frame := range frames {
+		if n := utf8.RuneCountInString(frame); n != 19 {
+			t.Errorf("Expected frame %q to be 19 columns wide, got %d", frame, n)
+		}
+	}
+	if !strings.HasPrefix(frames[1], "short ") {
+		t.Errorf("Expected short frame to be padded, got %q", frames[1])
+	}
+}
+
+func TestStatusLineProgressFitsWidth(t *testing.T) {
+	var buf bytes.Buffer
+	status := NewStatusLineWriter(&buf, 40, true)
+
+	if err := status.Progress("download", 5, 10); err != nil {
+		t.Fatalf("Progress() error = %v", err)
+	}
+	if err := status.Done(); err != nil {
+		t.Fatalf("Done() error = %v", err)
+	}
+
+	out := strings.TrimSuffix(strings.TrimPrefix(buf.String(), "\r"), "\n")
+	if n := utf8.RuneCountInString(out); n > 39 {
+		t.Errorf("Expected progress to fit in 39 columns, got %d: %q", n, out)
+	}
+	if !strings.Contains(out, "download [") || !strings.Contains(out, " 50%") {
+		t.Errorf("Unexpected progress output %q", out)
+	}
+}
+
+func TestStatusLineNonTTY(t *testing.T) {
+	var buf bytes.Buffer
+	status := NewStatusLineWriter(&buf, 20, false)
+
+	status.Update("first")
+	status.Progress("upload", 1, 2)
+	status.Done()
+
+	if strings.Contains(buf.String(), "\r") {
+		t.Errorf("Expected no carriage returns in non-TTY mode, got %q", buf.String())
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 || lines[0] != "first" {
+		t.Errorf("Expected updates to be appended as lines, got %q", lines)
+	}
+}
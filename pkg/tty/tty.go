@@ -50,3 +50,21 @@ func Size(fd uintptr) (int, int, error) {
 
 	return term.GetSize(int(fd))
 }
+
+// Default terminal dimensions used when the size cannot be detected.
+const (
+	DefaultWidth  = 80
+	DefaultHeight = 24
+)
+
+// SizeOrDefault returns the width and height of the terminal connected to the
+// given file descriptor, falling back to defW and defH when the size cannot be
+// detected, e.g. when output is piped or running in CI.
+// If the provided file descriptor is 0, it defaults to using os.Stdout.
+func SizeOrDefault(fd uintptr, defW, defH int) (int, int) {
+	width, height, err := Size(fd)
+	if err != nil || width <= 0 || height <= 0 {
+		return defW, defH
+	}
+	return width, height
+}
@@ -0,0 +1,29 @@
+package tty
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSizeOrDefaultNonTTY(t *testing.T) {
+	f, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	defer f.Close()
+
+	if IsTTY(f.Fd()) {
+		t.Skip("regular file unexpectedly reported as a terminal")
+	}
+
+	width, height := SizeOrDefault(f.Fd(), DefaultWidth, DefaultHeight)
+	if width != DefaultWidth || height != DefaultHeight {
+		t.Errorf("SizeOrDefault() = %dx%d, want %dx%d", width, height, DefaultWidth, DefaultHeight)
+	}
+
+	width, height = SizeOrDefault(f.Fd(), 120, 40)
+	if width != 120 || height != 40 {
+		t.Errorf("SizeOrDefault() = %dx%d, want custom defaults 120x40", width, height)
+	}
+}
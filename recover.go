@@ -0,0 +1,105 @@
+package ags
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicClassifier maps a recovered panic value to the error reported to the
+// client, e.g. a 400 for a known sentinel. Returning nil keeps the default
+// 500 internal error. Recover annotates a copy of the returned error, so
+// the classifier may return the same *AppError every time.
+type PanicClassifier func(recovered interface{}) *AppError
+
+// RecoverOption configures the Recover middleware
+type RecoverOption func(*recoverOptions)
+
+type recoverOptions struct {
+	classify PanicClassifier
+}
+
+// WithPanicClassifier lets Recover report known panic values with their own
+// error code and status instead of a 500
+func WithPanicClassifier(classify PanicClassifier) RecoverOption {
+	return func(o *recoverOptions) {
+		o.classify = classify
+	}
+}
+
+// Recover returns a middleware that turns a panic in the rest of the chain
+// into a 500 response. The recovered value and the stack are attached to an
+// AppError with ErrCodeInternal as internal logs, and the error goes through
+// Handler.ErrorCtx, so it is logged, passed to the OnError hook and rendered
+// like any other error without exposing the stack to the client. While
+// debug mode is enabled through /_/debug/toggle, the default JSON renderer
+// also sends the panic value and the stack as error details to speed up
+// local debugging. If the response was already committed, the error is only
+// logged.
+// http.ErrAbortHandler panics are propagated so net/http can abort the
+// response.
+func (h *Handler) Recover(opts ...RecoverOption) Middleware {
+	var o recoverOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			cw, ok := w.(interface{ Committed() bool })
+			if !ok {
+				rw := &ResponseWriter{ResponseWriter: w, status: http.StatusOK}
+				w, cw = rw, rw
+			}
+
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				if err, ok := p.(error); ok && errors.Is(err, http.ErrAbortHandler) {
+					panic(p)
+				}
+
+				var appErr *AppError
+				if o.classify != nil {
+					// The classifier may return a shared sentinel, which is
+					// annotated below
+					if classified := o.classify(p); classified != nil {
+						appErr = classified.clone()
+					}
+				}
+				if appErr == nil {
+					appErr = NewError(ErrCodeInternal, "An internal error occurred")
+				}
+				stack := debug.Stack()
+				appErr.WithContext(r.Context()).
+					AddInternalLog("panic: %v", p).
+					AddInternalLog("stack: %s", stack)
+				if h.isDebugEnabled() {
+					appErr.debugDetails = append(appErr.debugDetails,
+						ErrorDetail{Code: ErrCodeInternal, Message: fmt.Sprintf("panic: %v", p)},
+						ErrorDetail{Code: ErrCodeInternal, Message: "stack: " + string(stack)})
+				}
+				if appErr.MainError == nil {
+					if err, ok := p.(error); ok {
+						appErr.WithError(err)
+					} else {
+						appErr.WithError(fmt.Errorf("panic: %v", p))
+					}
+				}
+
+				if cw.Committed() {
+					h.Log(r.Context()).Error("panic after response was committed",
+						"internal_logs", appErr.InternalLogs,
+						"route", RoutePattern(r.Context()))
+					return
+				}
+				h.ErrorCtx(w, r, appErr)
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package ags_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestHandler_Recover(t *testing.T) {
+	logger := &mockLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{Log: logger})
+	h.Use(h.Recover())
+	h.Get("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("secret boom")
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/boom", nil))
+
+	assert.Equal(t, http.StatusInternalServerError, rec.Code)
+	var resp ags.StandardResponse
+	assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.Equal(t, ags.ErrCodeInternal, resp.Error.Code)
+	for _, leaked := range []string{"secret boom", "goroutine", "stack"} {
+		assert.Assert(t, !strings.Contains(rec.Body.String(), leaked), "body leaks %q: %s", leaked, rec.Body.String())
+	}
+
+	assert.Equal(t, "request error", logger.lastError)
+	var details struct {
+		InternalLogs []string `json:"internal_logs"`
+	}
+	assert.NilError(t, json.Unmarshal([]byte(fmt.Sprint(logger.field("error_details"))), &details))
+	logs := details.InternalLogs
+	assert.Equal(t, 2, len(logs))
+	assert.Equal(t, "panic: secret boom", logs[0])
+	assert.Assert(t, strings.Contains(logs[1], "goroutine"), "stack missing: %v", logs[1])
+}
+
+func TestHandler_Recover_Committed(t *testing.T) {
+	logger := &mockLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{Log: logger})
+	h.Use(h.Recover())
+	h.Get("/partial/:id", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late boom")
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/partial/7", nil))
+
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Equal(t, "", rec.Body.String())
+	assert.Equal(t, "panic after response was committed", logger.lastError)
+	assert.Equal(t, "/partial/:id", logger.field("route"))
+}
+
+func TestHandler_Recover_AbortHandler(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Use(h.Recover())
+	h.Get("/abort", func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	})
+
+	defer func() {
+		assert.Equal(t, http.ErrAbortHandler, recover())
+	}()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/abort", nil))
+}
+
+var errBadCursor = errors.New("bad cursor")
+
+func TestHandler_Recover_Classifier(t *testing.T) {
+	classify := func(p interface{}) *ags.AppError {
+		if err, ok := p.(error); ok && errors.Is(err, errBadCursor) {
+			return ags.NewError(ags.ErrCodeBadRequest, "Invalid cursor")
+		}
+		return nil
+	}
+
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Use(h.Recover(ags.WithPanicClassifier(classify)))
+	h.Get("/sentinel", func(w http.ResponseWriter, r *http.Request) {
+		panic(errBadCursor)
+	})
+	h.Get("/other", func(w http.ResponseWriter, r *http.Request) {
+		panic("unexpected")
+	})
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantCode   ags.ErrorCode
+	}{
+		{path: "/sentinel", wantStatus: http.StatusBadRequest, wantCode: ags.ErrCodeBadRequest},
+		{path: "/other", wantStatus: http.StatusInternalServerError, wantCode: ags.ErrCodeInternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			var resp ags.StandardResponse
+			assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, tt.wantCode, resp.Error.Code)
+		})
+	}
+}
+
+func TestHandler_Recover_SharedClassifiedError(t *testing.T) {
+	errInvalidCursor := ags.NewError(ags.ErrCodeBadRequest, "Invalid cursor")
+	classify := func(p interface{}) *ags.AppError {
+		return errInvalidCursor
+	}
+
+	h := ags.NewHandler(&ags.ServerConfig{Log: ags.NopLogger{}})
+	h.Use(h.Recover(ags.WithPanicClassifier(classify)))
+	h.Get("/sentinel", func(w http.ResponseWriter, r *http.Request) {
+		panic(errBadCursor)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/sentinel", nil))
+			if rec.Code != http.StatusBadRequest {
+				t.Errorf("got status %d, want 400", rec.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 0, len(errInvalidCursor.InternalLogs))
+	assert.Assert(t, errInvalidCursor.MainError == nil)
+	assert.Equal(t, context.Background(), errInvalidCursor.Context)
+}
+
+func TestHandler_Recover_DebugDetails(t *testing.T) {
+	t.Setenv("DEBUG_AUTH_KEY", "debug-key")
+
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Use(h.Recover())
+	h.Get("/boom", func(w http.ResponseWriter, r *http.Request) {
+		panic("secret boom")
+	})
+
+	setDebug := func(enable bool) {
+		body := `{"enable":false}`
+		if enable {
+			body = `{"enable":true}`
+		}
+		req := httptest.NewRequest(http.MethodPost, "/_/debug/toggle", strings.NewReader(body))
+		req.Header.Set("X-Debug-Key", "debug-key")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+
+	tests := []struct {
+		name        string
+		debug       bool
+		wantDetails int
+	}{
+		{name: "debug on", debug: true, wantDetails: 2},
+		{name: "debug off", debug: false, wantDetails: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setDebug(tt.debug)
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/boom", nil))
+
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+			var resp ags.StandardResponse
+			assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Equal(t, "An internal error occurred", resp.Error.Message)
+			assert.Equal(t, tt.wantDetails, len(resp.Error.Details))
+			if tt.debug {
+				assert.Equal(t, "panic: secret boom", resp.Error.Details[0].Message)
+				assert.Assert(t, strings.Contains(resp.Error.Details[1].Message, "goroutine"), resp.Error.Details[1].Message)
+			} else {
+				assert.Assert(t, !strings.Contains(rec.Body.String(), "secret boom"), rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package ags
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+var (
+	paramsContextKey     = &contextKey{"path-params"}
+	routeMatchContextKey = &contextKey{"route-match"}
+)
+
+// RouteNotFound is the pattern reported by RoutePattern for requests that no
+// route, file server or protocol handler served
+const RouteNotFound = "__not_found__"
+
+// routeMatch holds the pattern of the route serving a request
+type routeMatch struct {
+	pattern string
+}
+
+// RoutePattern returns the pattern of the route serving the request, e.g.
+// "/users/:id" for /users/5, for use as a low-cardinality label in metrics
+// and logs. Global middleware sees it once the next handler returns; before
+// routing it is "". Requests not served by any route report RouteNotFound.
+func RoutePattern(ctx context.Context) string {
+	if m, ok := ctx.Value(routeMatchContextKey).(*routeMatch); ok {
+		return m.pattern
+	}
+	return ""
+}
+
+// namedConstraints are the constraint names usable in place of a regular
+// expression, e.g. /users/:id(int)
+var namedConstraints = map[string]string{
+	"int": `[0-9]+`,
+}
+
+// routeSegment is one slash-separated part of a route pattern
+type routeSegment struct {
+	literal    string
+	param      string
+	constraint *regexp.Regexp
+}
+
+// paramRoute is a route whose pattern contains parameters
+type paramRoute struct {
+	pattern  string
+	host     string // host pattern the route is bound to, "" for any host
+	segments []routeSegment
+	config   RouteConfig
+}
+
+// isParamPattern reports whether pattern contains a :param segment
+func isParamPattern(pattern string) bool {
+	return strings.Contains(pattern, "/:")
+}
+
+// parseRoutePattern splits pattern into segments. A segment of the form :name
+// captures any non-empty value; :name(int) or :name(regexp) additionally
+// requires the whole value to match the constraint. Constraints apply to a
+// single segment and cannot contain "/". It panics on an invalid pattern, as
+// registration errors are programming errors.
+func parseRoutePattern(pattern string) []routeSegment {
+	parts := strings.Split(strings.Trim(pattern, "/"), "/")
+	segments := make([]routeSegment, 0, len(parts))
+	seen := make(map[string]bool)
+
+	for _, part := range parts {
+		if !strings.HasPrefix(part, ":") {
+			segments = append(segments, routeSegment{literal: part})
+			continue
+		}
+
+		name, expr := part[1:], ""
+		if i := strings.IndexByte(name, '('); i >= 0 {
+			if !strings.HasSuffix(name, ")") {
+				panic(fmt.Sprintf("ags: unterminated constraint in route pattern %q", pattern))
+			}
+			name, expr = name[:i], name[i+1:len(name)-1]
+		}
+		if name == "" {
+			panic(fmt.Sprintf("ags: unnamed parameter in route pattern %q", pattern))
+		}
+		if seen[name] {
+			panic(fmt.Sprintf("ags: duplicate parameter %q in route pattern %q", name, pattern))
+		}
+		seen[name] = true
+
+		seg := routeSegment{param: name}
+		if expr != "" {
+			if named, ok := namedConstraints[expr]; ok {
+				expr = named
+			}
+			re, err := regexp.Compile(`^(?:` + expr + `)$`)
+			if err != nil {
+				panic(fmt.Sprintf("ags: invalid constraint for %q in route pattern %q: %v", name, pattern, err))
+			}
+			seg.constraint = re
+		}
+		segments = append(segments, seg)
+	}
+	return segments
+}
+
+// validateGroupPrefix checks the parameter syntax of a group prefix so that
+// mistakes surface when the group is created rather than at its first route
+func validateGroupPrefix(prefix string) {
+	if isParamPattern(prefix) {
+		parseRoutePattern(prefix)
+	}
+}
+
+// match reports whether path matches the route and returns the captured
+// parameters
+func (p *paramRoute) match(path string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != len(p.segments) {
+		return nil, false
+	}
+
+	var params map[string]string
+	for i, seg := range p.segments {
+		part := parts[i]
+		if seg.param == "" {
+			if part != seg.literal {
+				return nil, false
+			}
+			continue
+		}
+		if part == "" || (seg.constraint != nil && !seg.constraint.MatchString(part)) {
+			return nil, false
+		}
+		if params == nil {
+			params = make(map[string]string, len(p.segments))
+		}
+		params[seg.param] = part
+	}
+	return params, true
+}
+
+// addParamRoute registers or replaces a parameterized route
+func (h *Handler) addParamRoute(pattern string, config RouteConfig) {
+	route := &paramRoute{
+		pattern:  pattern,
+		host:     config.host,
+		segments: parseRoutePattern(strings.TrimPrefix(pattern, config.host)),
+		config:   config,
+	}
+	for i, existing := range h.paramRoutes {
+		if existing.pattern == pattern {
+			h.paramRoutes[i] = route
+			return
+		}
+	}
+	h.paramRoutes = append(h.paramRoutes, route)
+}
+
+// resolveRoute finds the route serving method for r. Routes bound to a host
+// pattern matching the request host, see Handler.Host, take precedence over
+// routes for any host. When the path matches but no route allows the method,
+// found is false and allowed lists the methods that would be accepted.
+func (h *Handler) resolveRoute(method string, r *http.Request) (route RouteConfig, params map[string]string, allowed []string, found bool) {
+	if len(h.hosts) > 0 {
+		host := requestHost(r)
+		for _, pattern := range h.hosts {
+			if !hostMatches(pattern, host) {
+				continue
+			}
+			route, params, hostAllowed, found := h.lookupRoute(method, pattern, r.URL.Path)
+			if found {
+				return route, params, nil, true
+			}
+			allowed = mergeMethods(allowed, hostAllowed)
+		}
+	}
+
+	route, params, anyAllowed, found := h.lookupRoute(method, "", r.URL.Path)
+	if found {
+		return route, params, nil, true
+	}
+	return RouteConfig{}, nil, mergeMethods(allowed, anyAllowed), false
+}
+
+// mergeMethods appends the methods of add missing from methods
+func mergeMethods(methods, add []string) []string {
+	for _, m := range add {
+		if !containsString(methods, m) {
+			methods = append(methods, m)
+		}
+	}
+	return methods
+}
+
+// lookupRoute finds the route bound to host serving method and path. Static
+// routes take precedence; parameterized routes are tried in registration
+// order and a route whose constraints or methods do not match falls through
+// to the next. When the path matches but no route allows the method, found
+// is false and allowed lists the methods that would be accepted.
+func (h *Handler) lookupRoute(method, host, path string) (route RouteConfig, params map[string]string, allowed []string, found bool) {
+	if route, ok := h.routes[host+path]; ok && !isParamPattern(path) {
+		if !isMethodAllowed(method, route.Methods) {
+			return RouteConfig{}, nil, route.Methods, false
+		}
+		return route, nil, nil, true
+	}
+
+	for _, pr := range h.paramRoutes {
+		if pr.host != host {
+			continue
+		}
+		p, ok := pr.match(path)
+		if !ok {
+			continue
+		}
+		if isMethodAllowed(method, pr.config.Methods) {
+			return pr.config, p, nil, true
+		}
+		allowed = mergeMethods(allowed, pr.config.Methods)
+	}
+	return RouteConfig{}, nil, allowed, false
+}
+
+// DisableRoute switches off the route registered for pattern at runtime; its
+// requests get 404 Not Found as if it had never been registered. It is safe
+// to call while the handler is serving requests. Re-enable the route with
+// EnableRoute.
+func (h *Handler) DisableRoute(pattern string) error {
+	return h.DisableRouteWithStatus(pattern, http.StatusNotFound)
+}
+
+// DisableRouteWithStatus is like DisableRoute but answers requests to the
+// route with status, e.g. 503 Service Unavailable during an incident.
+func (h *Handler) DisableRouteWithStatus(pattern string, status int) error {
+	if _, ok := h.routes[pattern]; !ok {
+		return fmt.Errorf("ags: no route registered for %q", pattern)
+	}
+	h.disabledRoutes.Store(pattern, status)
+	return nil
+}
+
+// EnableRoute switches a route disabled with DisableRoute back on
+func (h *Handler) EnableRoute(pattern string) error {
+	if _, ok := h.routes[pattern]; !ok {
+		return fmt.Errorf("ags: no route registered for %q", pattern)
+	}
+	h.disabledRoutes.Delete(pattern)
+	return nil
+}
+
+// RouteEnabled reports whether the route registered for pattern is serving
+// requests
+func (h *Handler) RouteEnabled(pattern string) bool {
+	if _, ok := h.routes[pattern]; !ok {
+		return false
+	}
+	_, disabled := h.disabledRoutes.Load(pattern)
+	return !disabled
+}
+
+// Protocols returns the protocol handlers in the order they are consulted.
+// By default the WebSocket handler comes before the gRPC handler, so a
+// request carrying an Upgrade: websocket header is served as a WebSocket
+// whatever its Content-Type.
+func (h *Handler) Protocols() []ProtocolHandler {
+	return append([]ProtocolHandler(nil), h.protocols...)
+}
+
+// SetProtocols replaces the protocol handlers and their order, e.g. to add a
+// custom protocol ahead of or after the built-in ones returned by Protocols.
+// It must be called before the handler starts serving requests.
+func (h *Handler) SetProtocols(protocols ...ProtocolHandler) {
+	h.protocols = append([]ProtocolHandler(nil), protocols...)
+}
+
+// SetFallback delegates requests matching no route, protocol handler or file
+// server to fallback instead of answering 404 Not Found, e.g. a legacy mux
+// during an incremental migration. Global middleware still wraps it. Requests
+// to a known path with a disallowed method still get 405 Method Not Allowed.
+func (h *Handler) SetFallback(fallback http.Handler) {
+	h.fallback = fallback
+}
+
+// respondDisabledRoute answers a request to a disabled route
+func respondDisabledRoute(w http.ResponseWriter, r *http.Request, status int) {
+	if status == http.StatusNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	http.Error(w, http.StatusText(status), status)
+}
+
+// withPathParams returns a copy of ctx carrying the matched path parameters
+func withPathParams(ctx context.Context, params map[string]string) context.Context {
+	return context.WithValue(ctx, paramsContextKey, params)
+}
+
+// PathParams returns the path parameters matched for the request, or nil
+func PathParams(ctx context.Context) map[string]string {
+	params, _ := ctx.Value(paramsContextKey).(map[string]string)
+	return params
+}
+
+// PathParam returns the value of the named path parameter, or "" if the
+// route has no such parameter
+func PathParam(r *http.Request, name string) string {
+	return PathParams(r.Context())[name]
+}
@@ -0,0 +1,364 @@
+package ags_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"github.com/getangry/ags/pkg/middleware"
+	"gotest.tools/assert"
+)
+
+func TestRouteConstraints(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/users/:id(int)", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("user " + ags.PathParam(r, "id")))
+	})
+	h.Get("/users/:name", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("named " + ags.PathParam(r, "name")))
+	})
+	h.Get("/files/:name([a-z-]+)", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("file " + ags.PathParam(r, "name")))
+	})
+	h.Get("/users/me", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("me"))
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "int constraint", path: "/users/42", wantStatus: http.StatusOK, wantBody: "user 42"},
+		{name: "non-int falls through", path: "/users/ada", wantStatus: http.StatusOK, wantBody: "named ada"},
+		{name: "static route wins", path: "/users/me", wantStatus: http.StatusOK, wantBody: "me"},
+		{name: "regex constraint", path: "/files/release-notes", wantStatus: http.StatusOK, wantBody: "file release-notes"},
+		{name: "regex must match whole segment", path: "/files/Release1", wantStatus: http.StatusNotFound},
+		{name: "extra segment", path: "/files/a/b", wantStatus: http.StatusNotFound},
+		{name: "empty param", path: "/files/", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRouteParams_MethodNotAllowed(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/orders/:id(int)", func(w http.ResponseWriter, r *http.Request) {})
+	h.Delete("/orders/:id(int)/items/:item", func(w http.ResponseWriter, r *http.Request) {})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders/7", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+	assert.Equal(t, "GET", rec.Header().Get("Allow"))
+
+	// A constraint mismatch is a 404, not a 405
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders/x", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	methods, ok := h.AllowedMethods("/orders/7/items/a1")
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, []string{"DELETE"}, methods)
+}
+
+func TestRouteConstraints_InvalidPattern(t *testing.T) {
+	patterns := []string{
+		"/files/:name([a-z)",
+		"/files/:name([a-z]",
+		"/files/:",
+		"/users/:id/:id",
+	}
+
+	for _, pattern := range patterns {
+		func() {
+			defer func() {
+				assert.Assert(t, recover() != nil, "expected a panic for %q", pattern)
+			}()
+			h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+			h.Get(pattern, func(w http.ResponseWriter, r *http.Request) {})
+		}()
+	}
+}
+
+func TestGroupPrefixParams(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	orgs := h.Group("/orgs/:org")
+	teams := orgs.Group("/teams/:team([a-z]+)")
+
+	orgs.Route("/settings", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(ags.PathParam(r, "org")))
+	})
+	teams.Route("/members/:id(int)", func(w http.ResponseWriter, r *http.Request) {
+		params := ags.PathParams(r.Context())
+		_, _ = w.Write([]byte(params["org"] + "/" + params["team"] + "/" + params["id"]))
+	})
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: "/orgs/acme/settings", wantStatus: http.StatusOK, wantBody: "acme"},
+		{path: "/orgs/acme/teams/core/members/7", wantStatus: http.StatusOK, wantBody: "acme/core/7"},
+		{path: "/orgs/acme/teams/Core/members/7", wantStatus: http.StatusNotFound},
+		{path: "/orgs/acme/teams/core/members/x", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		assert.Equal(t, tt.wantStatus, rec.Code, tt.path)
+		if tt.wantBody != "" {
+			assert.Equal(t, tt.wantBody, rec.Body.String(), tt.path)
+		}
+	}
+}
+
+func TestGroupPrefixParams_Conflicts(t *testing.T) {
+	tests := []struct {
+		name     string
+		register func(h *ags.Handler)
+	}{
+		{
+			name: "route repeats prefix param",
+			register: func(h *ags.Handler) {
+				h.Group("/orgs/:org").Route("/users/:org", func(w http.ResponseWriter, r *http.Request) {})
+			},
+		},
+		{
+			name: "nested group repeats param",
+			register: func(h *ags.Handler) {
+				h.Group("/orgs/:org").Group("/forks/:org")
+			},
+		},
+		{
+			name: "invalid constraint in prefix",
+			register: func(h *ags.Handler) {
+				h.Group("/orgs/:org([a-z)")
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				assert.Assert(t, recover() != nil, "expected a registration panic")
+			}()
+			tt.register(ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}}))
+		})
+	}
+}
+
+func TestDisableRoute(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/checkout", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	})
+	h.Get("/orders/:id(int)", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("order " + ags.PathParam(r, "id")))
+	})
+
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		return rec
+	}
+
+	assert.NilError(t, h.DisableRoute("/checkout"))
+	assert.Equal(t, http.StatusNotFound, get("/checkout").Code)
+	assert.Assert(t, !h.RouteEnabled("/checkout"))
+
+	assert.NilError(t, h.DisableRouteWithStatus("/orders/:id(int)", http.StatusServiceUnavailable))
+	assert.Equal(t, http.StatusServiceUnavailable, get("/orders/7").Code)
+
+	assert.NilError(t, h.EnableRoute("/checkout"))
+	assert.NilError(t, h.EnableRoute("/orders/:id(int)"))
+	rec := get("/checkout")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "ok", rec.Body.String())
+	assert.Equal(t, "order 7", get("/orders/7").Body.String())
+	assert.Assert(t, h.RouteEnabled("/checkout"))
+
+	assert.ErrorContains(t, h.DisableRoute("/missing"), "no route registered")
+	assert.ErrorContains(t, h.EnableRoute("/missing"), "no route registered")
+}
+
+func TestDisableRoute_Concurrent(t *testing.T) {
+	// mockLogger records every call and is not safe for concurrent requests
+	h := ags.NewHandler(&ags.ServerConfig{Log: ags.NopLogger{}})
+	h.Get("/checkout", func(w http.ResponseWriter, r *http.Request) {})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/checkout", nil))
+				if rec.Code != http.StatusOK && rec.Code != http.StatusNotFound {
+					t.Errorf("got status %d, want 200 or 404", rec.Code)
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				_ = h.DisableRoute("/checkout")
+				_ = h.EnableRoute("/checkout")
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRoutePattern(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+
+	var seenByMiddleware string
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			next.ServeHTTP(w, r)
+			seenByMiddleware = ags.RoutePattern(r.Context())
+		})
+	})
+
+	var seenByHandler string
+	h.Get("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		seenByHandler = ags.RoutePattern(r.Context())
+	})
+	h.Group("/api").Get("/orders", func(w http.ResponseWriter, r *http.Request) {
+		seenByHandler = ags.RoutePattern(r.Context())
+	})
+
+	tests := []struct {
+		path        string
+		method      string
+		wantPattern string
+	}{
+		{path: "/users/5", method: http.MethodGet, wantPattern: "/users/:id"},
+		{path: "/api/orders", method: http.MethodGet, wantPattern: "/api/orders"},
+		{path: "/missing", method: http.MethodGet, wantPattern: ags.RouteNotFound},
+		{path: "/users/5", method: http.MethodPost, wantPattern: ags.RouteNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			seenByHandler, seenByMiddleware = "", ""
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.path, nil))
+
+			assert.Equal(t, tt.wantPattern, seenByMiddleware)
+			if tt.wantPattern != ags.RouteNotFound {
+				assert.Equal(t, tt.wantPattern, seenByHandler)
+			}
+		})
+	}
+}
+
+func TestSetFallback(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/users", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("users"))
+	})
+	h.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-Global", "1")
+			next.ServeHTTP(w, r)
+		})
+	})
+
+	legacy := http.NewServeMux()
+	legacy.HandleFunc("/legacy/orders", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("legacy orders"))
+	})
+	h.SetFallback(legacy)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "route", method: http.MethodGet, path: "/users", wantStatus: http.StatusOK, wantBody: "users"},
+		{name: "unmatched path", method: http.MethodGet, path: "/legacy/orders", wantStatus: http.StatusOK, wantBody: "legacy orders"},
+		{name: "unmatched by either", method: http.MethodGet, path: "/missing", wantStatus: http.StatusNotFound},
+		{name: "method not allowed", method: http.MethodPost, path: "/users", wantStatus: http.StatusMethodNotAllowed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, "1", rec.Header().Get("X-Global"))
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestStripPrefix_RoutePattern(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Use(middleware.StripPrefix("/service"))
+
+	var pattern string
+	h.Get("/users/:id", func(w http.ResponseWriter, r *http.Request) {
+		pattern = ags.RoutePattern(r.Context())
+		_, _ = w.Write([]byte(ags.PathParam(r, "id")))
+	})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/service/users/7", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "7", rec.Body.String())
+	assert.Equal(t, "/users/:id", pattern)
+}
+
+func listUsers(w http.ResponseWriter, r *http.Request) {}
+
+func TestGetRegisteredRoutes_HandlerNames(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	h.Get("/users", listUsers)
+	h.Group("/api", func(next http.Handler) http.Handler { return next }).Get("/users", listUsers)
+	h.GetTimeout("/slow", listUsers, time.Second)
+
+	names := make(map[string]string)
+	for _, route := range h.GetRegisteredRoutes() {
+		names[route.Pattern] = route.Handler
+	}
+
+	const want = "github.com/getangry/ags_test.listUsers"
+	assert.Equal(t, want, names["/users"])
+	assert.Equal(t, want, names["/api/users"])
+	assert.Equal(t, want, names["/slow"])
+}
+
+func BenchmarkGetRegisteredRoutes(b *testing.B) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	for i := 0; i < 50; i++ {
+		h.Get(fmt.Sprintf("/resource%d/:id", i), listUsers)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_ = h.GetRegisteredRoutes()
+	}
+}
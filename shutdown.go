@@ -0,0 +1,119 @@
+package ags
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ShutdownFunc is a cleanup run by Shutdown, see Handler.OnShutdown
+type ShutdownFunc func(ctx context.Context) error
+
+// OnShutdown registers fn to run during Shutdown once requests have drained,
+// the gRPC server has stopped and WebSocket connections are closed, but
+// before the DB and Cache are closed, so it can still use them, e.g. to flush
+// buffered writes. Functions run in registration order; their errors are
+// joined into the error of Shutdown.
+func (h *Handler) OnShutdown(fn ShutdownFunc) {
+	h.shutdownMu.Lock()
+	defer h.shutdownMu.Unlock()
+	h.onShutdown = append(h.onShutdown, fn)
+}
+
+// shutdownStep is one stage of Shutdown
+type shutdownStep struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+// shutdownSteps returns the stages of Shutdown in the order they run
+func (h *Handler) shutdownSteps() []shutdownStep {
+	return []shutdownStep{
+		{name: "http", run: h.shutdownHTTP},
+		{name: "grpc", run: h.grpcHandler.shutdown},
+		{name: "websocket", run: h.shutdownWebSocket},
+		{name: "on_shutdown", run: h.runOnShutdown},
+		{name: "dependencies", run: h.closeDependencies},
+	}
+}
+
+// shutdownStepNames returns the names of steps for logging
+func shutdownStepNames(steps []shutdownStep) []string {
+	names := make([]string, len(steps))
+	for i, step := range steps {
+		names[i] = step.name
+	}
+	return names
+}
+
+// runShutdownStep runs step until it returns or ctx is done, in which case
+// it is left running and the context error is returned
+func (h *Handler) runShutdownStep(ctx context.Context, step shutdownStep) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- step.run(ctx) }()
+	select {
+	case err := <-done:
+		if err == nil {
+			h.logger.Debug("shutdown step finished", "step", step.name)
+		}
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// shutdownHTTP stops the server started by Start or Serve from accepting
+// connections and waits for in-flight requests
+func (h *Handler) shutdownHTTP(ctx context.Context) error {
+	h.srvMu.Lock()
+	srv := h.srv
+	h.srvMu.Unlock()
+	if srv == nil {
+		return nil
+	}
+	return srv.Shutdown(ctx)
+}
+
+// shutdownWebSocket closes the stored WebSocket connections, sending
+// ServerConfig.WSShutdownMessage first when it is set
+func (h *Handler) shutdownWebSocket(ctx context.Context) error {
+	h.closeWSConnections(ctx, h.cfg.WSShutdownMessage)
+	return ctx.Err()
+}
+
+// runOnShutdown runs the functions registered with OnShutdown
+func (h *Handler) runOnShutdown(ctx context.Context) error {
+	h.shutdownMu.Lock()
+	fns := append([]ShutdownFunc(nil), h.onShutdown...)
+	h.shutdownMu.Unlock()
+
+	var errs []error
+	for _, fn := range fns {
+		if err := fn(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// closeDependencies closes the configured DB, and the Cache when it
+// implements io.Closer
+func (h *Handler) closeDependencies(ctx context.Context) error {
+	var errs []error
+	if h.cfg.DB != nil {
+		if err := h.cfg.DB.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing database: %w", err))
+		}
+	}
+	if c, ok := h.cfg.Cache.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			errs = append(errs, fmt.Errorf("closing cache: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,205 @@
+package ags_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"github.com/gorilla/websocket"
+	"gotest.tools/assert"
+)
+
+// shutdownRecorder collects the events of instrumented fakes in order
+type shutdownRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *shutdownRecorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *shutdownRecorder) recorded() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+// closeConnector is a database connector recording when the sql.DB using it
+// is closed
+type closeConnector struct {
+	rec *shutdownRecorder
+}
+
+func (c closeConnector) Connect(context.Context) (driver.Conn, error) {
+	return nil, errors.New("not connectable")
+}
+
+func (c closeConnector) Driver() driver.Driver { return nil }
+
+func (c closeConnector) Close() error {
+	c.rec.record("db")
+	return nil
+}
+
+// closingCache is a cache recording when it is closed
+type closingCache struct {
+	rec *shutdownRecorder
+}
+
+func (c closingCache) Set(ctx context.Context, key string, value interface{}) {}
+func (c closingCache) Get(ctx context.Context, key string) (interface{}, bool) {
+	return nil, false
+}
+func (c closingCache) Delete(ctx context.Context, key string) {}
+
+func (c closingCache) Close() error {
+	c.rec.record("cache")
+	return nil
+}
+
+// finishedSteps returns the shutdown steps logged as finished
+func finishedSteps(log *ags.CaptureLogger) []string {
+	var steps []string
+	for _, entry := range log.Entries() {
+		if entry.Message == "shutdown step finished" {
+			steps = append(steps, entry.Fields["step"].(string))
+		}
+	}
+	return steps
+}
+
+func TestHandler_Shutdown_Order(t *testing.T) {
+	rec := &shutdownRecorder{}
+	log := ags.NewCaptureLogger()
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:               log,
+		DB:                sql.OpenDB(closeConnector{rec: rec}),
+		Cache:             closingCache{rec: rec},
+		WSShutdownMessage: map[string]string{"type": "server_restarting"},
+	})
+	h.RegisterGRPCService(&userServiceDesc, testUserServer{})
+	h.OnShutdown(func(ctx context.Context) error {
+		rec.record("hook 1")
+		return nil
+	})
+	h.OnShutdown(func(ctx context.Context) error {
+		rec.record("hook 2")
+		return nil
+	})
+
+	release := make(chan struct{})
+	h.Get("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		rec.record("http")
+	})
+	h.RegisterWSConnRoute("/ws", func(conn *ags.WSConnection) {
+		h.StoreWSConnection("client", conn)
+		defer h.DeleteWSConnection("client")
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	served := make(chan error, 1)
+	go func() { served <- h.Serve(ln) }()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws://"+ln.Addr().String()+"/ws", nil)
+	assert.NilError(t, err)
+	defer client.Close()
+	go func() {
+		var msg map[string]string
+		if client.ReadJSON(&msg) == nil {
+			rec.record("websocket")
+		}
+		// Reading on answers the close frame
+		_, _, _ = client.ReadMessage()
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := h.GetWSConnection("client"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection not stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	go func() {
+		if resp, err := http.Get("http://" + ln.Addr().String() + "/slow"); err == nil {
+			resp.Body.Close()
+		}
+	}()
+	for h.InFlight() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("request not started")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdownDone := make(chan error, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go func() { shutdownDone <- h.Shutdown(ctx) }()
+
+	// Nothing but the drain may happen while the request runs
+	time.Sleep(20 * time.Millisecond)
+	assert.DeepEqual(t, rec.recorded(), []string(nil))
+	close(release)
+
+	assert.NilError(t, <-shutdownDone)
+	assert.NilError(t, <-served)
+	assert.DeepEqual(t, rec.recorded(), []string{"http", "websocket", "hook 1", "hook 2", "db", "cache"})
+	assert.DeepEqual(t, finishedSteps(log), []string{"http", "grpc", "websocket", "on_shutdown", "dependencies"})
+}
+
+func TestHandler_Shutdown_StepTimeout(t *testing.T) {
+	rec := &shutdownRecorder{}
+	log := ags.NewCaptureLogger()
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log: log,
+		DB:  sql.OpenDB(closeConnector{rec: rec}),
+	})
+	hookErr := errors.New("flush failed")
+	h.OnShutdown(func(ctx context.Context) error {
+		return hookErr
+	})
+	h.OnShutdown(func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := h.Shutdown(ctx)
+
+	assert.Assert(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+	assert.DeepEqual(t, rec.recorded(), []string(nil))
+
+	var timedOut *ags.LogEntry
+	for _, entry := range log.Entries() {
+		if entry.Message == "shutdown step timed out" {
+			timedOut = &entry
+		}
+	}
+	assert.Assert(t, timedOut != nil, "timeout not logged")
+	assert.Equal(t, timedOut.Fields["step"], "on_shutdown")
+	assert.DeepEqual(t, timedOut.Fields["skipped"], []string{"dependencies"})
+	assert.Assert(t, strings.Contains(strings.Join(finishedSteps(log), ","), "websocket"))
+}
@@ -2,17 +2,24 @@ package ags
 
 import (
 	"net/http"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 )
 
 // FileServerOption defines options for file server configuration
 type FileServerOption func(*fileServerConfig)
 
 type fileServerConfig struct {
-	serveSPA  bool
-	indexFile string
-	distPath  string
+	serveSPA         bool
+	indexFiles       []string
+	directoryListing bool
+	distPath         string
+	prefix           string
+	handler          http.Handler
 }
 
 // WithSPASupport enables Single Page Application support
@@ -24,13 +31,34 @@ func WithSPASupport(enable bool) FileServerOption {
 
 // WithIndexFile sets a custom index file
 func WithIndexFile(filename string) FileServerOption {
+	return WithIndexFiles(filename)
+}
+
+// WithIndexFiles sets the index files tried, in order, when a directory is
+// requested. The first one that exists is served.
+func WithIndexFiles(filenames ...string) FileServerOption {
+	return func(f *fileServerConfig) {
+		f.indexFiles = filenames
+	}
+}
+
+// WithDirectoryListing enables a generated listing for directories that
+// contain none of the index files. Listing is disabled by default.
+func WithDirectoryListing(enable bool) FileServerOption {
 	return func(f *fileServerConfig) {
-		f.indexFile = filename
+		f.directoryListing = enable
 	}
 }
 
 // RegisterFileServer adds a catch-all route for serving static files
 func (h *Handler) RegisterFileServer(distPath string, opts ...FileServerOption) error {
+	return h.RegisterFileServerAt("/", distPath, opts...)
+}
+
+// RegisterFileServerAt serves static files from distPath for requests under
+// prefix. Several mounts may be registered; the longest matching prefix wins.
+// Registering the same prefix again replaces the previous mount.
+func (h *Handler) RegisterFileServerAt(prefix string, distPath string, opts ...FileServerOption) error {
 	// Clean and verify the dist path
 	absPath, err := filepath.Abs(distPath)
 	if err != nil {
@@ -44,9 +72,10 @@ func (h *Handler) RegisterFileServer(distPath string, opts ...FileServerOption)
 
 	// Initialize default config
 	config := &fileServerConfig{
-		serveSPA:  true,
-		indexFile: "index.html",
-		distPath:  absPath,
+		serveSPA:   true,
+		indexFiles: []string{"index.html"},
+		distPath:   absPath,
+		prefix:     path.Clean("/" + prefix),
 	}
 
 	// Apply options
@@ -54,18 +83,119 @@ func (h *Handler) RegisterFileServer(distPath string, opts ...FileServerOption)
 		opt(config)
 	}
 
-	// Verify index file exists if SPA mode is enabled
+	// Verify an index file exists if SPA mode is enabled
 	if config.serveSPA {
-		indexPath := filepath.Join(absPath, config.indexFile)
-		if _, err := os.Stat(indexPath); os.IsNotExist(err) {
+		if _, ok := config.findIndex(absPath); !ok {
 			return NewError(ErrCodeNotFound, "Index file not found").
-				WithError(err).
-				WithMetadata("path", indexPath)
+				AddInternalLog("none of %v found in %s", config.indexFiles, absPath)
 		}
 	}
 
 	// Store config and handler for later use
-	h.fileServer = config
-	h.staticHandler = http.FileServer(http.Dir(absPath))
+	config.handler = http.FileServer(http.Dir(absPath))
+	h.addFileServer(config)
+	return nil
+}
+
+// addFileServer stores a mount, keeping mounts ordered by descending prefix
+// length so the first match is the longest
+func (h *Handler) addFileServer(config *fileServerConfig) {
+	for i, fs := range h.fileServers {
+		if fs.prefix == config.prefix {
+			h.fileServers[i] = config
+			return
+		}
+	}
+	h.fileServers = append(h.fileServers, config)
+	sort.SliceStable(h.fileServers, func(i, j int) bool {
+		return len(h.fileServers[i].prefix) > len(h.fileServers[j].prefix)
+	})
+}
+
+// matchFileServer returns the mount with the longest prefix matching urlPath
+func (h *Handler) matchFileServer(urlPath string) *fileServerConfig {
+	for _, fs := range h.fileServers {
+		if fs.prefix == "/" || urlPath == fs.prefix || strings.HasPrefix(urlPath, fs.prefix+"/") {
+			return fs
+		}
+	}
 	return nil
 }
+
+// pattern returns the route pattern describing the mount
+func (f *fileServerConfig) pattern() string {
+	if f.prefix == "/" {
+		return "/*"
+	}
+	return f.prefix + "/*"
+}
+
+// ServeHTTP serves a request relative to the mount's prefix
+func (f *fileServerConfig) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rel := "/" + strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, f.prefix), "/")
+
+	fullPath, ok := containedPath(f.distPath, rel)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	fi, err := os.Stat(fullPath)
+	switch {
+	case err == nil && fi.IsDir():
+		if index, ok := f.findIndex(fullPath); ok {
+			http.ServeFile(w, r, index)
+			return
+		}
+		if f.directoryListing {
+			f.serveRelative(w, r, rel)
+			return
+		}
+	case err == nil:
+		f.serveRelative(w, r, rel)
+		return
+	}
+
+	// Serve the index file for SPA routes
+	if f.serveSPA {
+		if index, ok := f.findIndex(f.distPath); ok {
+			http.ServeFile(w, r, index)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+// serveRelative passes the request to the file server with the path made
+// relative to the mount, as http.StripPrefix does
+func (f *fileServerConfig) serveRelative(w http.ResponseWriter, r *http.Request, rel string) {
+	r2 := new(http.Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = rel
+	r2.URL.RawPath = ""
+	f.handler.ServeHTTP(w, r2)
+}
+
+// findIndex returns the first configured index file present in dir
+func (f *fileServerConfig) findIndex(dir string) (string, bool) {
+	for _, name := range f.indexFiles {
+		indexPath := filepath.Join(dir, name)
+		if fi, err := os.Stat(indexPath); err == nil && !fi.IsDir() {
+			return indexPath, true
+		}
+	}
+	return "", false
+}
+
+// containedPath joins name onto root and reports whether the result stays
+// within root, so ".." sequences cannot escape the served directory
+func containedPath(root, name string) (string, bool) {
+	fullPath := filepath.Join(root, filepath.FromSlash(name))
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return fullPath, true
+}
@@ -0,0 +1,196 @@
+package ags_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+// writeFiles creates a temporary directory containing the given files
+func writeFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("failed to write file: %v", err)
+		}
+	}
+	return dir
+}
+
+func TestRegisterFileServerAt_MultipleMounts(t *testing.T) {
+	assets := writeFiles(t, map[string]string{
+		"app.js":     "assets app.js",
+		"index.html": "assets index",
+	})
+	docs := writeFiles(t, map[string]string{
+		"guide.txt": "docs guide",
+	})
+
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	assert.NilError(t, h.RegisterFileServerAt("/assets", assets, ags.WithSPASupport(true)))
+	assert.NilError(t, h.RegisterFileServerAt("/docs", docs, ags.WithSPASupport(false)))
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "assets file", path: "/assets/app.js", wantStatus: http.StatusOK, wantBody: "assets app.js"},
+		{name: "docs file", path: "/docs/guide.txt", wantStatus: http.StatusOK, wantBody: "docs guide"},
+		{name: "SPA mount falls back to index", path: "/assets/some/route", wantStatus: http.StatusOK, wantBody: "assets index"},
+		{name: "non-SPA mount does not fall back", path: "/docs/missing", wantStatus: http.StatusNotFound},
+		{name: "docs file not served through assets", path: "/assets/guide.txt", wantStatus: http.StatusOK, wantBody: "assets index"},
+		{name: "assets file not served through docs", path: "/docs/app.js", wantStatus: http.StatusNotFound},
+		{name: "unmounted path", path: "/other/app.js", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, rec.Body.String())
+			}
+		})
+	}
+
+	patterns := make([]string, 0)
+	for _, route := range h.GetRegisteredRoutes() {
+		patterns = append(patterns, route.Pattern)
+	}
+	assert.Assert(t, containsAll(patterns, "/assets/*", "/docs/*"), "routes: %v", patterns)
+}
+
+func containsAll(list []string, values ...string) bool {
+	for _, v := range values {
+		found := false
+		for _, item := range list {
+			if item == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func TestFileServer_DirectoryTraversal(t *testing.T) {
+	parent := writeFiles(t, map[string]string{
+		"secret.txt":      "top secret",
+		"dist/index.html": "index",
+		"dist/app.js":     "app",
+	})
+	dist := filepath.Join(parent, "dist")
+
+	for _, spa := range []bool{true, false} {
+		h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+		assert.NilError(t, h.RegisterFileServer(dist, ags.WithSPASupport(spa)))
+		assert.NilError(t, h.RegisterFileServerAt("/assets", dist, ags.WithSPASupport(spa)))
+
+		for _, target := range []string{
+			"/../secret.txt",
+			"/../../etc/passwd",
+			"/assets/../../secret.txt",
+			"/%2e%2e/secret.txt",
+			"/assets/%2e%2e/%2e%2e/secret.txt",
+		} {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+
+			assert.Equal(t, http.StatusNotFound, rec.Code, "spa=%v target=%s", spa, target)
+			assert.Assert(t, !strings.Contains(rec.Body.String(), "top secret"), "spa=%v target=%s", spa, target)
+		}
+
+		// Paths that stay inside the directory keep working
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/assets/sub/../app.js", nil))
+		assert.Equal(t, "app", rec.Body.String(), "spa=%v", spa)
+	}
+}
+
+func TestFileServer_IndexFiles(t *testing.T) {
+	dist := writeFiles(t, map[string]string{
+		"only-htm/index.htm":  "htm index",
+		"both/index.html":     "html index",
+		"both/index.htm":      "htm index",
+		"no-index/readme.txt": "readme",
+	})
+
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	assert.NilError(t, h.RegisterFileServer(dist,
+		ags.WithSPASupport(false),
+		ags.WithIndexFiles("index.html", "index.htm"),
+	))
+
+	tests := []struct {
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{path: "/only-htm/", wantStatus: http.StatusOK, wantBody: "htm index"},
+		{path: "/both/", wantStatus: http.StatusOK, wantBody: "html index"},
+		{path: "/no-index/", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		assert.Equal(t, tt.wantStatus, rec.Code, tt.path)
+		if tt.wantBody != "" {
+			assert.Equal(t, tt.wantBody, rec.Body.String(), tt.path)
+		}
+	}
+
+	// The order of the list decides which index wins
+	h = ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	assert.NilError(t, h.RegisterFileServer(dist,
+		ags.WithSPASupport(false),
+		ags.WithIndexFiles("index.htm", "index.html"),
+	))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/both/", nil))
+	assert.Equal(t, "htm index", rec.Body.String())
+}
+
+func TestFileServer_DirectoryListing(t *testing.T) {
+	dist := writeFiles(t, map[string]string{
+		"files/readme.txt": "readme",
+	})
+
+	for _, listing := range []bool{false, true} {
+		h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+		opts := []ags.FileServerOption{ags.WithSPASupport(false)}
+		if listing {
+			opts = append(opts, ags.WithDirectoryListing(true))
+		}
+		assert.NilError(t, h.RegisterFileServer(dist, opts...))
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/files/", nil))
+
+		if listing {
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Assert(t, strings.Contains(rec.Body.String(), "readme.txt"), rec.Body.String())
+		} else {
+			assert.Equal(t, http.StatusNotFound, rec.Code)
+			assert.Assert(t, !strings.Contains(rec.Body.String(), "readme.txt"))
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package ags
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// DefaultStreamChunkSize is the largest chunk StreamBody passes to its
+// callback unless WithChunkSize is given
+const DefaultStreamChunkSize = 32 << 10
+
+// StreamOption configures StreamBody
+type StreamOption func(*streamOptions)
+
+type streamOptions struct {
+	chunkSize int
+}
+
+// WithChunkSize bounds the chunks StreamBody passes to its callback to n
+// bytes
+func WithChunkSize(n int) StreamOption {
+	return func(o *streamOptions) {
+		if n > 0 {
+			o.chunkSize = n
+		}
+	}
+}
+
+// StreamBody reads the request body in chunks of at most
+// DefaultStreamChunkSize bytes and passes each to fn, so large uploads are
+// processed without holding the whole body in memory. The chunk is reused
+// between calls and must not be retained. The request context is checked
+// before every read: once it is done, StreamBody stops and returns the
+// error of CheckContext. An error from fn stops reading and is returned
+// as is. Failing reads return a 400 Bad Request AppError, or a 413 Request
+// Entity Too Large when the body exceeds an http.MaxBytesReader limit.
+func StreamBody(r *http.Request, fn func(chunk []byte) error, opts ...StreamOption) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	o := streamOptions{chunkSize: DefaultStreamChunkSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	ctx := r.Context()
+	buf := make([]byte, o.chunkSize)
+	for {
+		if err := CheckContext(ctx); err != nil {
+			return err
+		}
+
+		n, err := io.ReadFull(r.Body, buf)
+		if n > 0 {
+			if err := fn(buf[:n]); err != nil {
+				return err
+			}
+		}
+		switch {
+		case err == nil:
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return nil
+		default:
+			// A body read failing because the client went away is reported
+			// as a cancellation
+			if err := CheckContext(ctx); err != nil {
+				return err
+			}
+			return bodyReadError(r, err)
+		}
+	}
+}
+
+// bodyReadError converts a failed request body read into an AppError
+func bodyReadError(r *http.Request, err error) *AppError {
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) {
+		appErr := NewError(ErrCodeBadRequest, "Request body too large").WithError(err).WithContext(r.Context())
+		appErr.StatusCode = http.StatusRequestEntityTooLarge
+		return appErr
+	}
+	return NewError(ErrCodeBadRequest, "Failed to read request body").WithError(err).WithContext(r.Context())
+}
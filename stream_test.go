@@ -0,0 +1,96 @@
+package ags_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestStreamBody(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("abcdefghij"))
+
+	var chunks []string
+	err := ags.StreamBody(req, func(chunk []byte) error {
+		chunks = append(chunks, string(chunk))
+		return nil
+	}, ags.WithChunkSize(4))
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, chunks, []string{"abcd", "efgh", "ij"})
+}
+
+func TestStreamBody_Errors(t *testing.T) {
+	errStop := errors.New("stop")
+
+	tests := []struct {
+		name       string
+		request    func() (*http.Request, context.CancelFunc)
+		fnErr      error
+		cancelIn   bool
+		wantCalls  int
+		wantErr    error
+		wantStatus int
+	}{
+		{
+			name: "context canceled between reads",
+			request: func() (*http.Request, context.CancelFunc) {
+				ctx, cancel := context.WithCancel(context.Background())
+				req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("abcdefghij"))
+				return req.WithContext(ctx), cancel
+			},
+			cancelIn:   true,
+			wantCalls:  1,
+			wantStatus: ags.StatusClientClosedRequest,
+		},
+		{
+			name: "callback error",
+			request: func() (*http.Request, context.CancelFunc) {
+				return httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("abcdefghij")), func() {}
+			},
+			fnErr:     errStop,
+			wantCalls: 1,
+			wantErr:   errStop,
+		},
+		{
+			name: "body over limit",
+			request: func() (*http.Request, context.CancelFunc) {
+				req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("abcdefghij"))
+				req.Body = http.MaxBytesReader(httptest.NewRecorder(), req.Body, 6)
+				return req, func() {}
+			},
+			wantCalls:  2,
+			wantStatus: http.StatusRequestEntityTooLarge,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, cancel := tt.request()
+			defer cancel()
+
+			calls := 0
+			err := ags.StreamBody(req, func(chunk []byte) error {
+				calls++
+				if tt.cancelIn {
+					cancel()
+				}
+				return tt.fnErr
+			}, ags.WithChunkSize(4))
+
+			assert.Equal(t, calls, tt.wantCalls)
+			if tt.wantErr != nil {
+				assert.Assert(t, errors.Is(err, tt.wantErr), "got %v", err)
+				return
+			}
+			var appErr *ags.AppError
+			assert.Assert(t, errors.As(err, &appErr), "got %v", err)
+			assert.Equal(t, appErr.StatusCode, tt.wantStatus)
+		})
+	}
+}
@@ -0,0 +1,196 @@
+package ags
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Timeout returns a middleware that bounds the handling of a request to d.
+// The handler's context carries the deadline. Its response is buffered and
+// sent once it returns; if it is still running when d elapses, the client
+// receives a 504 Gateway Timeout through Handler.Error and anything the
+// handler writes afterwards is discarded; a panic it raises afterwards is
+// logged. Handlers should stop work when
+// their context is done. Streaming responses and hijacking are not
+// supported behind it.
+func (h *Handler) Timeout(d time.Duration) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+
+			tw := &timeoutWriter{w: w, ctx: ctx, header: make(http.Header)}
+			done := make(chan struct{})
+			go func() {
+				defer func() {
+					p := recover()
+					tw.mu.Lock()
+					timedOut := tw.timedOut
+					if !timedOut {
+						tw.finished = true
+						tw.panicked, tw.panicVal = p != nil, p
+					}
+					tw.mu.Unlock()
+					if timedOut && p != nil {
+						// Nobody is waiting for the handler any more
+						h.Log(ctx).Error("panic after request timed out",
+							"panic", fmt.Sprint(p),
+							"stack", string(debug.Stack()),
+							"route", RoutePattern(ctx))
+					}
+					close(done)
+				}()
+				next.ServeHTTP(tw, r)
+			}()
+
+			select {
+			case <-done:
+			case <-ctx.Done():
+			}
+
+			// Whichever of the handler and the deadline got here first
+			// decides the response; timedOut stops any further writes
+			tw.mu.Lock()
+			if !tw.finished {
+				tw.timedOut = true
+				tw.mu.Unlock()
+				if err := CheckContext(ctx); err != nil {
+					h.ErrorCtx(w, r, err)
+				}
+				return
+			}
+			defer tw.mu.Unlock()
+			if tw.panicked {
+				panic(tw.panicVal)
+			}
+
+			// Declared trailers are set after the body, as the handler
+			// did, so they are not sent as headers
+			trailers := declaredTrailers(tw.header)
+			dst := w.Header()
+			for k, v := range tw.header {
+				if !trailers[k] {
+					dst[k] = v
+				}
+			}
+			if tw.status == 0 {
+				tw.status = http.StatusOK
+			}
+			w.WriteHeader(tw.status)
+			if _, err := w.Write(tw.body.Bytes()); err != nil {
+				h.Log(ctx).Error("failed to write response", "error", err)
+			}
+			for k := range trailers {
+				if v, ok := tw.header[k]; ok {
+					dst[k] = v
+				}
+			}
+		})
+	}
+}
+
+// RouteTimeout registers a route whose handler is bounded by timeout, see
+// Handler.Timeout
+func (h *Handler) RouteTimeout(pattern string, handler http.HandlerFunc, timeout time.Duration, methods ...string) {
+	h.route(pattern, h.Timeout(timeout)(handler).ServeHTTP, funcName(handler), methods)
+}
+
+// GetTimeout registers a GET route whose handler is bounded by timeout
+func (h *Handler) GetTimeout(pattern string, handler http.HandlerFunc, timeout time.Duration) {
+	h.RouteTimeout(pattern, handler, timeout, MethodGet)
+}
+
+// PostTimeout registers a POST route whose handler is bounded by timeout
+func (h *Handler) PostTimeout(pattern string, handler http.HandlerFunc, timeout time.Duration) {
+	h.RouteTimeout(pattern, handler, timeout, MethodPost)
+}
+
+// declaredTrailers returns the canonical names listed in the Trailer header
+func declaredTrailers(header http.Header) map[string]bool {
+	trailers := make(map[string]bool)
+	for _, value := range header.Values("Trailer") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				trailers[http.CanonicalHeaderKey(name)] = true
+			}
+		}
+	}
+	return trailers
+}
+
+// timeoutWriter buffers a response until the handler returns, so a timeout
+// response can still be sent in its place
+type timeoutWriter struct {
+	w        http.ResponseWriter
+	ctx      context.Context
+	mu       sync.Mutex
+	header   http.Header
+	body     bytes.Buffer
+	status   int
+	timedOut bool // the deadline passed first; writes are discarded
+	finished bool // the handler returned or panicked first
+	panicked bool // the handler panicked with panicVal
+	panicVal interface{}
+}
+
+// expired reports whether writes must be discarded, marking the writer timed
+// out once the deadline has passed. tw.mu must be held.
+func (tw *timeoutWriter) expired() bool {
+	if !tw.timedOut && !tw.finished && tw.ctx.Err() != nil {
+		tw.timedOut = true
+	}
+	return tw.timedOut
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) WriteHeader(status int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired() || tw.status != 0 {
+		return
+	}
+	tw.status = status
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	if tw.expired() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if tw.status == 0 {
+		tw.status = http.StatusOK
+	}
+	return tw.body.Write(b)
+}
+
+// Committed reports whether the handler has written a status, so
+// RespondJSON's double-response guard still applies
+func (tw *timeoutWriter) Committed() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return tw.status != 0 || tw.timedOut
+}
+
+// FlushError reports that flushing is not supported, so flushes through
+// http.ResponseController do not reach the wrapped writer ahead of the
+// buffered response
+func (tw *timeoutWriter) FlushError() error {
+	return http.ErrNotSupported
+}
+
+// Unwrap returns nil: the wrapped writer must not be reached around the
+// buffer, e.g. by http.ResponseController hijacking or flushing it
+func (tw *timeoutWriter) Unwrap() http.ResponseWriter {
+	return nil
+}
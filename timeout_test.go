@@ -0,0 +1,124 @@
+package ags_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+func TestHandler_GetTimeout(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+
+	h.GetTimeout("/fast", func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := r.Context().Deadline(); ok {
+			w.Header().Set("X-Has-Deadline", "yes")
+		}
+		_ = ags.RespondJSON(w, http.StatusCreated, "done", nil)
+	}, time.Second)
+
+	handlerDone := make(chan error, 1)
+	h.GetTimeout("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		handlerDone <- ags.RespondJSON(w, http.StatusOK, "too late", nil)
+	}, 10*time.Millisecond)
+
+	t.Run("finishes in time", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/fast", nil))
+
+		assert.Equal(t, http.StatusCreated, rec.Code)
+		assert.Equal(t, "yes", rec.Header().Get("X-Has-Deadline"))
+		assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+		assert.Equal(t, `{"ok":true,"message":"done"}`+"\n", rec.Body.String())
+	})
+
+	t.Run("exceeds the timeout", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow", nil))
+
+		assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+		var resp ags.StandardResponse
+		assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+		assert.Assert(t, !resp.OK)
+		assert.Equal(t, ags.ErrCodeTimeout, resp.Error.Code)
+
+		// The late response is discarded
+		select {
+		case err := <-handlerDone:
+			assert.Assert(t, err != nil, "late response was accepted")
+		case <-time.After(5 * time.Second):
+			t.Fatal("handler did not return")
+		}
+	})
+}
+
+func TestHandler_Timeout_PanicAfterTimeout(t *testing.T) {
+	logger := ags.NewCaptureLogger()
+	h := ags.NewHandler(&ags.ServerConfig{Log: logger})
+
+	release := make(chan struct{})
+	h.GetTimeout("/slow/:id", func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		panic("late failure")
+	}, 10*time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/slow/1", nil))
+	assert.Equal(t, http.StatusGatewayTimeout, rec.Code)
+	close(release)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		for _, e := range logger.Entries() {
+			if e.Message == "panic after request timed out" {
+				assert.Equal(t, "late failure", e.Fields["panic"])
+				assert.Equal(t, "/slow/:id", e.Fields["route"])
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("panic after the timeout was not logged")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// hijackRecorder is a ResponseRecorder whose connection can be hijacked
+type hijackRecorder struct {
+	*httptest.ResponseRecorder
+	hijacked bool
+}
+
+func (w *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.hijacked = true
+	return nil, nil, nil
+}
+
+func TestHandler_Timeout_ResponseController(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: ags.NopLogger{}})
+
+	var flushErr, hijackErr error
+	h.GetTimeout("/stream", func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		flushErr = rc.Flush()
+		_, _, hijackErr = rc.Hijack()
+		w.WriteHeader(http.StatusOK)
+	}, time.Second)
+
+	rec := &hijackRecorder{ResponseRecorder: httptest.NewRecorder()}
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Assert(t, errors.Is(flushErr, http.ErrNotSupported), "got %v", flushErr)
+	assert.Assert(t, errors.Is(hijackErr, http.ErrNotSupported), "got %v", hijackErr)
+	assert.Assert(t, !rec.hijacked)
+	assert.Assert(t, !rec.Flushed)
+}
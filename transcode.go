@@ -0,0 +1,89 @@
+package ags
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// TranscodeRule maps an HTTP method and path to a unary method of a gRPC
+// service, e.g. {Method: "POST", Pattern: "/v1/users", RPC: "CreateUser"}
+type TranscodeRule struct {
+	Method  string
+	Pattern string
+	RPC     string
+}
+
+// RegisterGRPCTranscoding exposes unary methods of a gRPC service as JSON
+// routes using explicit rules. The request body is decoded into the RPC's
+// request message and the response message is written as JSON; protobuf
+// messages use the protojson mapping. gRPC status errors are rendered through
+// Handler.Error. Server interceptors are not applied to transcoded calls.
+func (h *Handler) RegisterGRPCTranscoding(sd *grpc.ServiceDesc, ss interface{}, rules ...TranscodeRule) error {
+	methods := make(map[string]grpc.MethodDesc, len(sd.Methods))
+	for _, md := range sd.Methods {
+		methods[md.MethodName] = md
+	}
+
+	for _, rule := range rules {
+		md, ok := methods[rule.RPC]
+		if !ok {
+			return fmt.Errorf("ags: %s has no unary method %q", sd.ServiceName, rule.RPC)
+		}
+		method := rule.Method
+		if method == "" {
+			method = MethodPost
+		}
+		h.Route(rule.Pattern, h.transcodeHandler(md, ss), method)
+	}
+	return nil
+}
+
+// transcodeHandler calls a unary gRPC method with the JSON request body
+func (h *Handler) transcodeHandler(md grpc.MethodDesc, ss interface{}) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			h.ErrorCtx(w, r, NewError(ErrCodeBadRequest, "Failed to read request body").WithError(err))
+			return
+		}
+
+		dec := func(v interface{}) error {
+			if len(body) == 0 {
+				return nil
+			}
+			if m, ok := v.(proto.Message); ok {
+				return protojson.Unmarshal(body, m)
+			}
+			return json.Unmarshal(body, v)
+		}
+
+		resp, err := md.Handler(ss, r.Context(), dec, nil)
+		if err != nil {
+			h.ErrorCtx(w, r, FromGRPCError(err))
+			return
+		}
+
+		var out []byte
+		if m, ok := resp.(proto.Message); ok {
+			out, err = protojson.Marshal(m)
+		} else {
+			out, err = json.Marshal(resp)
+		}
+		if err != nil {
+			h.ErrorCtx(w, r, NewError(ErrCodeInternal, "Failed to encode response").WithError(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(out); err != nil {
+			h.Log(r.Context()).Error("failed to write transcoded response", "error", err)
+		}
+	}
+}
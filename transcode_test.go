@@ -0,0 +1,113 @@
+package ags_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/getangry/ags"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/structpb"
+	"gotest.tools/assert"
+)
+
+type userServer interface {
+	CreateUser(context.Context, *structpb.Struct) (*structpb.Struct, error)
+}
+
+type testUserServer struct{}
+
+func (testUserServer) CreateUser(ctx context.Context, req *structpb.Struct) (*structpb.Struct, error) {
+	name := req.GetFields()["name"].GetStringValue()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "name is required")
+	}
+	return structpb.NewStruct(map[string]interface{}{"id": "u1", "name": name})
+}
+
+// userServiceDesc mirrors what protoc-gen-go-grpc generates for a unary method
+var userServiceDesc = grpc.ServiceDesc{
+	ServiceName: "test.UserService",
+	HandlerType: (*userServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CreateUser",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(structpb.Struct)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				if interceptor == nil {
+					return srv.(userServer).CreateUser(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/test.UserService/CreateUser"}
+				handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(userServer).CreateUser(ctx, req.(*structpb.Struct))
+				}
+				return interceptor(ctx, in, info, handler)
+			},
+		},
+	},
+}
+
+func TestRegisterGRPCTranscoding(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	err := h.RegisterGRPCTranscoding(&userServiceDesc, testUserServer{},
+		ags.TranscodeRule{Method: ags.MethodPost, Pattern: "/v1/users", RPC: "CreateUser"},
+	)
+	assert.NilError(t, err)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantBody   map[string]interface{}
+	}{
+		{
+			name:       "success",
+			body:       `{"name":"ada"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   map[string]interface{}{"id": "u1", "name": "ada"},
+		},
+		{
+			name:       "grpc status error",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/v1/users", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			if tt.wantBody != nil {
+				var got map[string]interface{}
+				assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+				assert.DeepEqual(t, tt.wantBody, got)
+			}
+		})
+	}
+
+	// Only the mapped method is routed
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/users", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
+
+func TestRegisterGRPCTranscoding_UnknownRPC(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}})
+	err := h.RegisterGRPCTranscoding(&userServiceDesc, testUserServer{},
+		ags.TranscodeRule{Pattern: "/v1/users", RPC: "DeleteUser"},
+	)
+	assert.ErrorContains(t, err, "DeleteUser")
+}
@@ -0,0 +1,46 @@
+package ags
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// TypedResponse is StandardResponse with Results of a concrete type, for
+// clients decoding a response body directly, e.g.
+//
+//	var resp ags.TypedResponse[[]User]
+//	err := json.NewDecoder(body).Decode(&resp)
+type TypedResponse[T any] struct {
+	OK         bool          `json:"ok"`
+	Message    string        `json:"message"`
+	Results    T             `json:"results,omitempty"`
+	Pagination *Pagination   `json:"pagination,omitempty"`
+	Warnings   []ErrorDetail `json:"warnings,omitempty"`
+	Error      *ErrorInfo    `json:"error,omitempty"`
+}
+
+// DecodeResults returns the Results of resp as a T. Results already holding
+// a T are returned as is; raw JSON and generically decoded values such as
+// map[string]interface{} are converted through their JSON form. An error is
+// returned when resp has no results or they do not fit T.
+func DecodeResults[T any](resp *StandardResponse) (T, error) {
+	var v T
+	if resp == nil || resp.Results == nil {
+		return v, fmt.Errorf("ags: response has no results")
+	}
+	if typed, ok := resp.Results.(T); ok {
+		return typed, nil
+	}
+
+	data, ok := resp.Results.(json.RawMessage)
+	if !ok {
+		var err error
+		if data, err = json.Marshal(resp.Results); err != nil {
+			return v, fmt.Errorf("ags: encoding results: %w", err)
+		}
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("ags: decoding results as %T: %w", v, err)
+	}
+	return v, nil
+}
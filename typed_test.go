@@ -0,0 +1,67 @@
+package ags_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/getangry/ags"
+	"gotest.tools/assert"
+)
+
+type typedUser struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+func TestDecodeResults(t *testing.T) {
+	body := `{"ok":true,"message":"Success","results":[{"id":1,"name":"ada"},{"id":2,"name":"grace"}]}`
+	want := []typedUser{{ID: 1, Name: "ada"}, {ID: 2, Name: "grace"}}
+
+	var generic ags.StandardResponse
+	assert.NilError(t, json.Unmarshal([]byte(body), &generic))
+
+	tests := []struct {
+		name string
+		resp *ags.StandardResponse
+	}{
+		{name: "generically decoded", resp: &generic},
+		{name: "raw JSON", resp: &ags.StandardResponse{OK: true, Results: json.RawMessage(`[{"id":1,"name":"ada"},{"id":2,"name":"grace"}]`)}},
+		{name: "already typed", resp: &ags.StandardResponse{OK: true, Results: want}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := ags.DecodeResults[[]typedUser](tt.resp)
+			assert.NilError(t, err)
+			assert.DeepEqual(t, want, users)
+		})
+	}
+
+	var typed ags.TypedResponse[[]typedUser]
+	assert.NilError(t, json.Unmarshal([]byte(body), &typed))
+	assert.Assert(t, typed.OK)
+	assert.DeepEqual(t, want, typed.Results)
+}
+
+func TestDecodeResults_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		resp    *ags.StandardResponse
+		wantErr string
+	}{
+		{name: "nil response", resp: nil, wantErr: "response has no results"},
+		{name: "no results", resp: &ags.StandardResponse{OK: true}, wantErr: "response has no results"},
+		{
+			name:    "type mismatch",
+			resp:    &ags.StandardResponse{OK: true, Results: map[string]interface{}{"id": "one"}},
+			wantErr: "decoding results as []ags_test.typedUser",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ags.DecodeResults[[]typedUser](tt.resp)
+			assert.ErrorContains(t, err, tt.wantErr)
+		})
+	}
+}
@@ -2,7 +2,11 @@ package ags
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -12,11 +16,281 @@ import (
 type WSHandleFunc func(*websocket.Conn)
 type WSMiddlewareFunc func(WSHandleFunc) WSHandleFunc
 
-// WebSocket connection wrapper
+// WSConnHandleFunc handles a WebSocket connection through the concurrency-safe
+// WSConnection wrapper
+type WSConnHandleFunc func(*WSConnection)
+
+// WebSocket connection wrapper. Writes made through WSConnection are
+// serialized, so it may be written to from several goroutines, e.g. a
+// broadcast loop and a keepalive ticker. Writing to the embedded Conn
+// directly bypasses that guard.
 type WSConnection struct {
 	*websocket.Conn
-	ctx    context.Context
-	cancel context.CancelFunc
+	ctx       context.Context
+	cancel    context.CancelFunc
+	writeMu   sync.Mutex
+	closeOnce sync.Once
+	closeErr  error
+
+	authenticated atomic.Bool
+	userCtx       atomic.Pointer[context.Context] // ctx carrying the user set by Authenticate
+
+	metrics   *wsMetrics   // nil for connections created outside WebSocketHandler
+	closeCode atomic.Int32 // first close code sent or received
+
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+}
+
+// WSCloseUnauthorized is the application close code sent when a connection
+// fails in-band authentication, mirroring HTTP 401
+const WSCloseUnauthorized = 4401
+
+// WSCloseTimeout bounds how long CloseWith waits for the peer to acknowledge
+// the close handshake
+var WSCloseTimeout = time.Second
+
+// NewWSConnection wraps conn. The connection context is derived from ctx and
+// is canceled by Close.
+func NewWSConnection(ctx context.Context, conn *websocket.Conn) *WSConnection {
+	ctx, cancel := context.WithCancel(ctx)
+	return &WSConnection{
+		Conn:   conn,
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Context returns the connection context, canceled once the connection
+// closes. After Authenticate it also carries the authenticated user.
+func (c *WSConnection) Context() context.Context {
+	if ctx := c.userCtx.Load(); ctx != nil {
+		return *ctx
+	}
+	return c.ctx
+}
+
+// WriteMessage writes a message, serialized with other writes on c
+func (c *WSConnection) WriteMessage(messageType int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.armWriteDeadline()
+	err := c.Conn.WriteMessage(messageType, data)
+	c.observeWrite(err)
+	return err
+}
+
+// WriteJSON writes v as a JSON message, serialized with other writes on c
+func (c *WSConnection) WriteJSON(v interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.armWriteDeadline()
+	err := c.Conn.WriteJSON(v)
+	c.observeWrite(err)
+	return err
+}
+
+// WriteControl writes a control message, serialized with other writes on c
+func (c *WSConnection) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.Conn.WriteControl(messageType, data, deadline)
+}
+
+// ReadMessage reads the next message and counts it in the handler's stats
+func (c *WSConnection) ReadMessage() (messageType int, p []byte, err error) {
+	messageType, p, err = c.Conn.ReadMessage()
+	c.observeRead(err)
+	return messageType, p, err
+}
+
+// ReadJSON reads the next message as JSON into v and counts it in the
+// handler's stats
+func (c *WSConnection) ReadJSON(v interface{}) error {
+	err := c.Conn.ReadJSON(v)
+	c.observeRead(err)
+	return err
+}
+
+// armWriteDeadline bounds the next write by the connection's write timeout
+func (c *WSConnection) armWriteDeadline() {
+	if c.writeTimeout > 0 {
+		_ = c.Conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+}
+
+// armReadDeadline bounds the wait for the next message or pong by the
+// connection's read timeout
+func (c *WSConnection) armReadDeadline() {
+	if c.readTimeout > 0 {
+		_ = c.Conn.SetReadDeadline(time.Now().Add(c.readTimeout))
+	}
+}
+
+// observeRead counts a received message, or records the peer's close code
+func (c *WSConnection) observeRead(err error) {
+	if err == nil {
+		if c.metrics != nil {
+			c.metrics.messagesIn.Add(1)
+		}
+		c.armReadDeadline()
+		return
+	}
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) {
+		c.closeCode.CompareAndSwap(0, int32(closeErr.Code))
+	}
+}
+
+// observeWrite counts a successfully sent message
+func (c *WSConnection) observeWrite(err error) {
+	if err == nil && c.metrics != nil {
+		c.metrics.messagesOut.Add(1)
+	}
+}
+
+// ErrWSUnauthorized is returned by Authenticate when the client fails to
+// authenticate
+var ErrWSUnauthorized = errors.New("ags: websocket authentication failed")
+
+// Authenticated reports whether the connection has been authenticated
+func (c *WSConnection) Authenticated() bool {
+	return c.authenticated.Load()
+}
+
+// SetAuthenticated marks the connection as authenticated or not, for
+// handlers implementing their own authentication flow
+func (c *WSConnection) SetAuthenticated(ok bool) {
+	c.authenticated.Store(ok)
+}
+
+// Authenticate implements the common first-message authentication pattern.
+// It reads one message within timeout and passes it to verify, which returns
+// the authenticated user. On success the connection is marked authenticated
+// and the user is stored in its context for ContextUser. If no message
+// arrives in time or verify fails, the connection is closed with
+// WSCloseUnauthorized and an error wrapping ErrWSUnauthorized is returned.
+// Call it before starting other goroutines that use the connection.
+func (c *WSConnection) Authenticate(timeout time.Duration, verify func(msg []byte) (interface{}, error)) (interface{}, error) {
+	if err := c.SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return nil, err
+	}
+	_, msg, err := c.ReadMessage()
+	if err != nil {
+		_ = c.CloseWith(WSCloseUnauthorized, "authentication timeout")
+		return nil, fmt.Errorf("%w: %v", ErrWSUnauthorized, err)
+	}
+
+	user, err := verify(msg)
+	if err != nil {
+		_ = c.CloseWith(WSCloseUnauthorized, "unauthorized")
+		return nil, fmt.Errorf("%w: %v", ErrWSUnauthorized, err)
+	}
+
+	// Back to the connection's read timeout, if any
+	var deadline time.Time
+	if c.readTimeout > 0 {
+		deadline = time.Now().Add(c.readTimeout)
+	}
+	if err := c.SetReadDeadline(deadline); err != nil {
+		return nil, err
+	}
+	// c.ctx itself is left alone, the keepalive goroutine may be reading it
+	userCtx := WithUser(c.ctx, user)
+	c.userCtx.Store(&userCtx)
+	c.SetAuthenticated(true)
+	return user, nil
+}
+
+// Close cancels the connection context and closes the underlying connection.
+// Only the first call has an effect.
+func (c *WSConnection) Close() error {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.closeErr = c.Conn.Close()
+		if c.metrics != nil {
+			c.metrics.disconnected(int(c.closeCode.Load()))
+		}
+	})
+	return c.closeErr
+}
+
+// CloseWith performs the close handshake: it sends a close message with the
+// given code and reason, waits up to WSCloseTimeout for the peer's close
+// message and then closes the connection. It reads from the connection, so it
+// must not be called while another goroutine is reading.
+func (c *WSConnection) CloseWith(code int, reason string) error {
+	c.closeCode.CompareAndSwap(0, int32(code))
+	msg := websocket.FormatCloseMessage(code, reason)
+	deadline := time.Now().Add(WSCloseTimeout)
+	if err := c.WriteControl(websocket.CloseMessage, msg, deadline); err != nil {
+		_ = c.Close()
+		return err
+	}
+
+	// Drain until the peer's close frame arrives or the deadline passes
+	if err := c.SetReadDeadline(deadline); err == nil {
+		for {
+			if _, _, err := c.NextReader(); err != nil {
+				break
+			}
+		}
+	}
+
+	return c.Close()
+}
+
+// WSStats is a snapshot of the WebSocket connection metrics of a handler.
+// Messages are counted when read or written through WSConnection; handlers
+// registered with RegisterWSRoute use the raw connection and only contribute
+// to the connection counts.
+type WSStats struct {
+	ActiveConnections int64
+	TotalConnections  int64
+	MessagesIn        int64
+	MessagesOut       int64
+	// CloseCodes counts closed connections by the first close code sent or
+	// received through WSConnection; connections where none was observed are
+	// counted under websocket.CloseAbnormalClosure (1006).
+	CloseCodes map[int]int64
+}
+
+// wsMetrics holds the counters behind WSStats
+type wsMetrics struct {
+	active      atomic.Int64
+	total       atomic.Int64
+	messagesIn  atomic.Int64
+	messagesOut atomic.Int64
+	closeCodes  sync.Map // int -> *atomic.Int64
+}
+
+func (m *wsMetrics) connected() {
+	m.active.Add(1)
+	m.total.Add(1)
+}
+
+func (m *wsMetrics) disconnected(code int) {
+	if code == 0 {
+		code = websocket.CloseAbnormalClosure
+	}
+	counter, _ := m.closeCodes.LoadOrStore(code, new(atomic.Int64))
+	counter.(*atomic.Int64).Add(1)
+	m.active.Add(-1)
+}
+
+func (m *wsMetrics) snapshot() WSStats {
+	stats := WSStats{
+		ActiveConnections: m.active.Load(),
+		TotalConnections:  m.total.Load(),
+		MessagesIn:        m.messagesIn.Load(),
+		MessagesOut:       m.messagesOut.Load(),
+		CloseCodes:        make(map[int]int64),
+	}
+	m.closeCodes.Range(func(code, counter interface{}) bool {
+		stats.CloseCodes[code.(int)] = counter.(*atomic.Int64).Load()
+		return true
+	})
+	return stats
 }
 
 // WebSocket configuration
@@ -25,13 +299,90 @@ type WSConfig struct {
 	WriteBufferSize   int
 	HandshakeTimeout  time.Duration
 	EnableCompression bool
+	// ReadTimeout closes connections on which no message or pong arrives for
+	// this long. The deadline is extended when a pong arrives and after each
+	// message read through WSConnection; handlers reading the raw connection
+	// of RegisterWSRoute should rely on PingInterval to keep it alive.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds each write made through WSConnection and each ping
+	WriteTimeout time.Duration
+	// PingInterval sends a ping at this interval for the lifetime of the
+	// connection, so live peers answer with pongs within ReadTimeout. It
+	// should be shorter than ReadTimeout.
+	PingInterval time.Duration
+	// MaxMessageSize is the largest message accepted from the peer, in
+	// bytes; larger messages close the connection with 1009 (message too
+	// big). Zero means no limit.
+	MaxMessageSize int64
+	// Error writes the response for a failed upgrade, e.g. a request missing
+	// the WebSocket headers (400) or from a rejected origin (403). Handlers
+	// created by NewHandler default to an AppError rendered like any other
+	// API error; NewWebSocketHandler defaults to a plain-text response.
+	Error func(w http.ResponseWriter, r *http.Request, status int, reason error)
+	// Upgrader, when set, is used verbatim for upgrades instead of one built
+	// from the buffer, handshake, compression and Error fields above, e.g. to
+	// set CheckOrigin, Subprotocols or a WriteBufferPool. Its Error and
+	// CheckOrigin are not defaulted, so a nil CheckOrigin applies gorilla's
+	// same-origin check. The deadlines and limits still apply.
+	Upgrader *websocket.Upgrader
+}
+
+// wsLimits are the deadlines and limits applied to a WebSocket connection
+type wsLimits struct {
+	readTimeout    time.Duration
+	writeTimeout   time.Duration
+	pingInterval   time.Duration
+	maxMessageSize int64
+}
+
+// WSRouteOption overrides the WSConfig deadlines and limits for the
+// connections of a single WebSocket route
+type WSRouteOption func(*wsLimits)
+
+// WithWSReadTimeout overrides WSConfig.ReadTimeout for a route
+func WithWSReadTimeout(d time.Duration) WSRouteOption {
+	return func(l *wsLimits) {
+		l.readTimeout = d
+	}
+}
+
+// WithWSWriteTimeout overrides WSConfig.WriteTimeout for a route
+func WithWSWriteTimeout(d time.Duration) WSRouteOption {
+	return func(l *wsLimits) {
+		l.writeTimeout = d
+	}
+}
+
+// WithWSPingInterval overrides WSConfig.PingInterval for a route
+func WithWSPingInterval(d time.Duration) WSRouteOption {
+	return func(l *wsLimits) {
+		l.pingInterval = d
+	}
+}
+
+// WithWSMaxMessageSize overrides WSConfig.MaxMessageSize for a route
+func WithWSMaxMessageSize(n int64) WSRouteOption {
+	return func(l *wsLimits) {
+		l.maxMessageSize = n
+	}
 }
 
 // WebSocket Handler implementation
 type WebSocketHandler struct {
-	upgrader   websocket.Upgrader
-	routes     map[string]WSHandleFunc
-	middleware []WSMiddlewareFunc
+	upgrader    *websocket.Upgrader
+	routes      map[string]WSHandleFunc
+	connRoutes  map[string]WSConnHandleFunc
+	limits      wsLimits
+	routeLimits map[string]wsLimits
+	middleware  []WSMiddlewareFunc
+	logger      Logger
+	metrics     wsMetrics
+}
+
+// Stats returns a snapshot of the handler's connection and message counters,
+// e.g. to feed a /metrics endpoint
+func (h *WebSocketHandler) Stats() WSStats {
+	return h.metrics.snapshot()
 }
 
 // Getter for WebSocketHandler routes
@@ -40,8 +391,9 @@ func (h *WebSocketHandler) GetRoutes() map[string]WSHandleFunc {
 }
 
 func NewWebSocketHandler(config WSConfig) *WebSocketHandler {
-	return &WebSocketHandler{
-		upgrader: websocket.Upgrader{
+	upgrader := config.Upgrader
+	if upgrader == nil {
+		upgrader = &websocket.Upgrader{
 			ReadBufferSize:    config.ReadBufferSize,
 			WriteBufferSize:   config.WriteBufferSize,
 			HandshakeTimeout:  config.HandshakeTimeout,
@@ -49,9 +401,73 @@ func NewWebSocketHandler(config WSConfig) *WebSocketHandler {
 			CheckOrigin: func(r *http.Request) bool {
 				return true // Override this in production
 			},
-		},
+			Error: config.Error,
+		}
+	}
+
+	return &WebSocketHandler{
+		upgrader:   upgrader,
 		routes:     make(map[string]WSHandleFunc),
-		middleware: make([]WSMiddlewareFunc, 0),
+		connRoutes: make(map[string]WSConnHandleFunc),
+		limits: wsLimits{
+			readTimeout:    config.ReadTimeout,
+			writeTimeout:   config.WriteTimeout,
+			pingInterval:   config.PingInterval,
+			maxMessageSize: config.MaxMessageSize,
+		},
+		routeLimits: make(map[string]wsLimits),
+		middleware:  make([]WSMiddlewareFunc, 0),
+	}
+}
+
+// setRouteLimits records the limits of a route, starting from the handler's
+// defaults
+func (h *WebSocketHandler) setRouteLimits(pattern string, opts []WSRouteOption) {
+	limits := h.limits
+	for _, opt := range opts {
+		opt(&limits)
+	}
+	h.routeLimits[pattern] = limits
+}
+
+// applyLimits sets up the deadlines, read limit and keepalive pings of a new
+// connection
+func (c *WSConnection) applyLimits(l wsLimits) {
+	c.readTimeout = l.readTimeout
+	c.writeTimeout = l.writeTimeout
+	if l.maxMessageSize > 0 {
+		c.SetReadLimit(l.maxMessageSize)
+	}
+	if l.readTimeout > 0 {
+		c.armReadDeadline()
+		c.SetPongHandler(func(string) error {
+			c.armReadDeadline()
+			return nil
+		})
+	}
+	if l.pingInterval > 0 {
+		go c.keepalive(l.pingInterval)
+	}
+}
+
+// keepalive pings the peer every interval until the connection closes.
+// WriteControl may run concurrently with other writes, including raw ones.
+func (c *WSConnection) keepalive(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			timeout := c.writeTimeout
+			if timeout <= 0 {
+				timeout = interval
+			}
+			if err := c.WriteControl(websocket.PingMessage, nil, time.Now().Add(timeout)); err != nil {
+				return
+			}
+		}
 	}
 }
 
@@ -61,43 +477,77 @@ func (h *WebSocketHandler) DetectProtocol(r *http.Request) bool {
 
 func (h *WebSocketHandler) Handle(w http.ResponseWriter, r *http.Request) {
 	handler, ok := h.routes[r.URL.Path]
-	if !ok {
+	connHandler, connOK := h.connRoutes[r.URL.Path]
+	if !ok && !connOK {
 		http.Error(w, "WebSocket route not found", http.StatusNotFound)
 		return
 	}
 
+	// On failure Upgrade has already written an HTTP error response, unless
+	// the connection was hijacked, in which case nothing more can be sent
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
+		if h.logger != nil {
+			h.logger.WithContext(r.Context()).Warn("websocket upgrade failed",
+				"error", err,
+				"remote_addr", r.RemoteAddr,
+				"path", r.URL.Path)
+		}
 		return
 	}
 
+	// The connection outlives Handle, whose return cancels the request
+	// context, so only its values are kept
+	wsConn := NewWSConnection(context.WithoutCancel(r.Context()), conn)
+	wsConn.metrics = &h.metrics
+	h.metrics.connected()
+	wsConn.applyLimits(h.routeLimits[r.URL.Path])
+
+	if connOK {
+		handler = func(*websocket.Conn) { connHandler(wsConn) }
+	}
+
 	// Apply middleware chain
 	handleFunc := handler
 	for i := len(h.middleware) - 1; i >= 0; i-- {
 		handleFunc = h.middleware[i](handleFunc)
 	}
 
-	// Create context for the connection
-	ctx, cancel := context.WithCancel(r.Context())
-	wsConn := &WSConnection{
-		Conn:   conn,
-		ctx:    ctx,
-		cancel: cancel,
-	}
-
 	// Handle the WebSocket connection
 	go func() {
-		if wsConn != nil {
-			defer wsConn.Conn.Close()
-		}
-		defer cancel()
+		defer wsConn.Close()
 		handleFunc(conn)
 	}()
 }
 
-// RegisterWSRoute registers a WebSocket route with the handler
-func (h *Handler) RegisterWSRoute(pattern string, handler WSHandleFunc) {
+// wsUpgradeError renders a failed WebSocket upgrade as an AppError carrying
+// the upgrader's status. The failure is logged by WebSocketHandler.Handle.
+func (h *Handler) wsUpgradeError(w http.ResponseWriter, r *http.Request, status int, reason error) {
+	appErr := NewError(ErrCodeBadRequest, reason.Error())
+	appErr.StatusCode = status
+	h.errorRendererFor(w)(w, r, appErr)
+}
+
+// RegisterWSRoute registers a WebSocket route with the handler. Options
+// override the WSConfig deadlines and limits for this route's connections.
+func (h *Handler) RegisterWSRoute(pattern string, handler WSHandleFunc, opts ...WSRouteOption) {
+	delete(h.wsHandler.connRoutes, pattern)
 	h.wsHandler.routes[pattern] = handler
+	h.wsHandler.setRouteLimits(pattern, opts)
+}
+
+// RegisterWSConnRoute registers a WebSocket route whose handler receives a
+// WSConnection, which is safe for concurrent writers. Options override the
+// WSConfig deadlines and limits for this route's connections.
+func (h *Handler) RegisterWSConnRoute(pattern string, handler WSConnHandleFunc, opts ...WSRouteOption) {
+	delete(h.wsHandler.routes, pattern)
+	h.wsHandler.connRoutes[pattern] = handler
+	h.wsHandler.setRouteLimits(pattern, opts)
+}
+
+// WSStats returns a snapshot of the WebSocket connection metrics
+func (h *Handler) WSStats() WSStats {
+	return h.wsHandler.Stats()
 }
 
 // Getter for Handler WebSocketHandler
@@ -124,3 +574,67 @@ func (h *Handler) GetWSConnection(key string) (*WSConnection, bool) {
 func (h *Handler) DeleteWSConnection(key string) {
 	h.wsConnections.Delete(key)
 }
+
+// BroadcastWSClose sends message as JSON to every connection stored with
+// StoreWSConnection, followed by a close frame with code 1012 (service
+// restart). Connections are then given WSCloseTimeout to complete the close
+// handshake through their handler's read loop before being closed. It
+// returns once all stored connections are closed. Shutdown closes them the
+// same way, sending ServerConfig.WSShutdownMessage when it is set.
+func (h *Handler) BroadcastWSClose(message interface{}) {
+	h.closeWSConnections(context.Background(), message)
+}
+
+// closeWSConnections closes every stored connection concurrently, sending
+// message first unless it is nil. Closing is cut short once ctx is done.
+func (h *Handler) closeWSConnections(ctx context.Context, message interface{}) {
+	var wg sync.WaitGroup
+	h.wsConnections.Range(func(_, value interface{}) bool {
+		conn := value.(*WSConnection)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.closeWSConnection(ctx, conn, message)
+		}()
+		return true
+	})
+	wg.Wait()
+}
+
+// closeWSConnection sends the shutdown message, if any, and close frame to
+// conn and waits for its handler to finish the close handshake
+func (h *Handler) closeWSConnection(ctx context.Context, conn *WSConnection, message interface{}) {
+	defer conn.Close()
+
+	// Writes are bounded by the close timeout rather than the connection's
+	// write timeout, so a stalled client cannot hold up the shutdown
+	deadline := time.Now().Add(WSCloseTimeout)
+	writeDeadline := deadline
+	if d, ok := ctx.Deadline(); ok && d.Before(writeDeadline) {
+		writeDeadline = d
+	}
+	if message != nil {
+		conn.writeMu.Lock()
+		_ = conn.Conn.SetWriteDeadline(writeDeadline)
+		err := conn.Conn.WriteJSON(message)
+		conn.observeWrite(err)
+		conn.writeMu.Unlock()
+		if err != nil {
+			h.logger.Warn("failed to send websocket shutdown message", "error", err)
+			return
+		}
+	}
+	msg := websocket.FormatCloseMessage(websocket.CloseServiceRestart, "server restarting")
+	if err := conn.WriteControl(websocket.CloseMessage, msg, writeDeadline); err != nil {
+		return
+	}
+	conn.closeCode.CompareAndSwap(0, websocket.CloseServiceRestart)
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+	select {
+	case <-conn.Context().Done():
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,569 @@
+package ags_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getangry/ags"
+	"github.com/gorilla/websocket"
+	"gotest.tools/assert"
+)
+
+func TestWSConnection_ConcurrentWrites(t *testing.T) {
+	const writers, perWriter = 32, 50
+
+	h := newTestHandler()
+	h.RegisterWSConnRoute("/ws", func(conn *ags.WSConnection) {
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				for j := 0; j < perWriter; j++ {
+					var err error
+					switch j % 3 {
+					case 0:
+						err = conn.WriteJSON(map[string]int{"writer": i, "seq": j})
+					case 1:
+						err = conn.WriteMessage(websocket.TextMessage, []byte(`{"ping":true}`))
+					default:
+						err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(time.Second))
+					}
+					if err != nil {
+						return
+					}
+				}
+			}(i)
+		}
+		wg.Wait()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("done"))
+	})
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NilError(t, err)
+	defer client.Close()
+
+	pings := 0
+	client.SetPingHandler(func(string) error {
+		pings++
+		return nil
+	})
+
+	messages := 0
+	for {
+		assert.NilError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+		_, msg, err := client.ReadMessage()
+		assert.NilError(t, err)
+		if string(msg) == "done" {
+			break
+		}
+		messages++
+	}
+
+	wantPings := writers * (perWriter / 3)
+	assert.Equal(t, writers*perWriter-wantPings, messages)
+	assert.Equal(t, wantPings, pings)
+}
+
+func TestWSConnection_CloseWith(t *testing.T) {
+	closed := make(chan error, 1)
+
+	h := newTestHandler()
+	h.RegisterWSConnRoute("/ws", func(conn *ags.WSConnection) {
+		closed <- conn.CloseWith(websocket.ClosePolicyViolation, "token expired")
+	})
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+	client, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NilError(t, err)
+	defer client.Close()
+
+	// The default close handler replies with a close frame, completing the handshake
+	assert.NilError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err = client.ReadMessage()
+
+	var closeErr *websocket.CloseError
+	assert.Assert(t, errors.As(err, &closeErr), "got %v", err)
+	assert.Equal(t, websocket.ClosePolicyViolation, closeErr.Code)
+	assert.Equal(t, "token expired", closeErr.Text)
+
+	select {
+	case err := <-closed:
+		assert.NilError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("CloseWith did not return")
+	}
+}
+
+func TestWebSocketHandler_UpgradeFailureLogged(t *testing.T) {
+	mockLog := &mockLogger{}
+	h := ags.NewHandler(&ags.ServerConfig{Log: mockLog})
+	h.RegisterWSRoute("/ws", func(conn *websocket.Conn) {
+		t.Error("handler must not run when the upgrade fails")
+	})
+
+	// An upgrade request without Sec-WebSocket-Key is rejected by the upgrader
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.RemoteAddr = "192.0.2.1:1234"
+	rec := httptest.NewRecorder()
+
+	h.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "websocket upgrade failed", mockLog.lastWarn)
+	assert.DeepEqual(t, []interface{}{"remote_addr", "192.0.2.1:1234", "path", "/ws"}, mockLog.lastWarnFields[2:])
+}
+
+func TestWSConnection_Authenticate(t *testing.T) {
+	verify := func(msg []byte) (interface{}, error) {
+		if string(msg) != "token-ok" {
+			return nil, errors.New("invalid token")
+		}
+		return "ada", nil
+	}
+
+	tests := []struct {
+		name      string
+		firstMsg  string
+		wantClose bool
+	}{
+		{name: "valid token", firstMsg: "token-ok"},
+		{name: "bad token", firstMsg: "token-bad", wantClose: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type result struct {
+				user   interface{}
+				err    error
+				authed bool
+			}
+			results := make(chan result, 1)
+
+			h := newTestHandler()
+			h.RegisterWSConnRoute("/ws", func(conn *ags.WSConnection) {
+				user, err := conn.Authenticate(time.Second, verify)
+				ctxUser, _ := ags.ContextUser(conn.Context())
+				if err == nil {
+					assert.Equal(t, user, ctxUser)
+					_ = conn.WriteMessage(websocket.TextMessage, []byte("welcome"))
+				}
+				results <- result{user: user, err: err, authed: conn.Authenticated()}
+			}, ags.WithWSPingInterval(time.Millisecond))
+
+			s := httptest.NewServer(h)
+			defer s.Close()
+
+			url := "ws" + strings.TrimPrefix(s.URL, "http") + "/ws"
+			client, _, err := websocket.DefaultDialer.Dial(url, nil)
+			assert.NilError(t, err)
+			defer client.Close()
+
+			assert.NilError(t, client.WriteMessage(websocket.TextMessage, []byte(tt.firstMsg)))
+			assert.NilError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+			_, msg, err := client.ReadMessage()
+
+			res := <-results
+			if tt.wantClose {
+				var closeErr *websocket.CloseError
+				assert.Assert(t, errors.As(err, &closeErr), "got %v", err)
+				assert.Equal(t, ags.WSCloseUnauthorized, closeErr.Code)
+				assert.Assert(t, errors.Is(res.err, ags.ErrWSUnauthorized))
+				assert.Assert(t, !res.authed)
+				return
+			}
+
+			assert.NilError(t, err)
+			assert.Equal(t, "welcome", string(msg))
+			assert.NilError(t, res.err)
+			assert.Equal(t, "ada", res.user)
+			assert.Assert(t, res.authed)
+		})
+	}
+}
+
+func TestWebSocketHandler_Stats(t *testing.T) {
+	h := newTestHandler()
+	h.RegisterWSConnRoute("/echo", func(conn *ags.WSConnection) {
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(mt, msg); err != nil {
+				return
+			}
+		}
+	})
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	waitForStats := func(cond func(ags.WSStats) bool) ags.WSStats {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			stats := h.WSStats()
+			if cond(stats) {
+				return stats
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("stats not reached, got %+v", stats)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	url := "ws" + strings.TrimPrefix(s.URL, "http") + "/echo"
+	var clients []*websocket.Conn
+	for i := 0; i < 3; i++ {
+		client, _, err := websocket.DefaultDialer.Dial(url, nil)
+		assert.NilError(t, err)
+		clients = append(clients, client)
+	}
+	waitForStats(func(s ags.WSStats) bool { return s.ActiveConnections == 3 })
+
+	for _, client := range clients {
+		assert.NilError(t, client.WriteMessage(websocket.TextMessage, []byte("hi")))
+		_, _, err := client.ReadMessage()
+		assert.NilError(t, err)
+	}
+
+	// One client closes cleanly, one drops the connection
+	msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "bye")
+	assert.NilError(t, clients[0].WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)))
+	assert.NilError(t, clients[1].Close())
+	stats := waitForStats(func(s ags.WSStats) bool { return s.ActiveConnections == 1 })
+
+	assert.Equal(t, int64(3), stats.TotalConnections)
+	assert.Equal(t, int64(3), stats.MessagesIn)
+	assert.Equal(t, int64(3), stats.MessagesOut)
+	assert.Equal(t, int64(1), stats.CloseCodes[websocket.CloseNormalClosure])
+	assert.Equal(t, int64(1), stats.CloseCodes[websocket.CloseAbnormalClosure])
+
+	_ = clients[0].Close()
+	_ = clients[2].Close()
+	waitForStats(func(s ags.WSStats) bool { return s.ActiveConnections == 0 })
+}
+
+func TestRegisterWSRoute_Limits(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:       &mockLogger{},
+		WebSocket: &ags.WSConfig{ReadTimeout: 5 * time.Second},
+	})
+
+	readErrs := map[string]chan error{
+		"/chat":  make(chan error, 1),
+		"/feed":  make(chan error, 1),
+		"/small": make(chan error, 1),
+	}
+	reader := func(path string) ags.WSConnHandleFunc {
+		return func(conn *ags.WSConnection) {
+			_, _, err := conn.ReadMessage()
+			readErrs[path] <- err
+		}
+	}
+	h.RegisterWSConnRoute("/chat", reader("/chat"), ags.WithWSReadTimeout(50*time.Millisecond))
+	h.RegisterWSConnRoute("/feed", reader("/feed"))
+	h.RegisterWSConnRoute("/small", reader("/small"), ags.WithWSMaxMessageSize(8))
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	dial := func(path string) *websocket.Conn {
+		client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+path, nil)
+		assert.NilError(t, err)
+		return client
+	}
+	chat, feed, small := dial("/chat"), dial("/feed"), dial("/small")
+	defer chat.Close()
+	defer feed.Close()
+	defer small.Close()
+
+	// The chat route's short deadline expires while the client stays silent
+	select {
+	case err := <-readErrs["/chat"]:
+		var netErr interface{ Timeout() bool }
+		assert.Assert(t, errors.As(err, &netErr) && netErr.Timeout(), "got %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("chat read deadline not enforced")
+	}
+
+	// The feed route keeps the global deadline and is still waiting
+	select {
+	case err := <-readErrs["/feed"]:
+		t.Fatalf("feed read returned early: %v", err)
+	default:
+	}
+	assert.NilError(t, feed.WriteMessage(websocket.TextMessage, []byte("tick")))
+	assert.NilError(t, <-readErrs["/feed"])
+
+	assert.NilError(t, small.WriteMessage(websocket.TextMessage, []byte("longer than eight bytes")))
+	assert.ErrorContains(t, <-readErrs["/small"], "read limit exceeded")
+}
+
+func TestHandler_BroadcastWSClose(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:               &mockLogger{},
+		WSShutdownMessage: map[string]string{"type": "server_restarting"},
+	})
+	handlerDone := make(chan struct{})
+	h.RegisterWSConnRoute("/ws", func(conn *ags.WSConnection) {
+		defer close(handlerDone)
+		h.StoreWSConnection("client-1", conn)
+		defer h.DeleteWSConnection("client-1")
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/ws", nil)
+	assert.NilError(t, err)
+	defer client.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := h.GetWSConnection("client-1"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection not stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- h.Shutdown(context.Background()) }()
+
+	assert.NilError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	var msg map[string]string
+	assert.NilError(t, client.ReadJSON(&msg))
+	assert.DeepEqual(t, map[string]string{"type": "server_restarting"}, msg)
+
+	// The default close handler replies, completing the handshake
+	_, _, err = client.ReadMessage()
+	var closeErr *websocket.CloseError
+	assert.Assert(t, errors.As(err, &closeErr), "got %v", err)
+	assert.Equal(t, websocket.CloseServiceRestart, closeErr.Code)
+
+	select {
+	case err := <-shutdownDone:
+		assert.NilError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+	<-handlerDone
+	assert.Equal(t, int64(1), h.WSStats().CloseCodes[websocket.CloseServiceRestart])
+}
+
+func TestHandler_Shutdown_ClosesWSConnections(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: ags.NopLogger{}})
+	handlerDone := make(chan struct{})
+	h.RegisterWSConnRoute("/ws", func(conn *ags.WSConnection) {
+		defer close(handlerDone)
+		h.StoreWSConnection("client-1", conn)
+		defer h.DeleteWSConnection("client-1")
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	})
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/ws", nil)
+	assert.NilError(t, err)
+	defer client.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := h.GetWSConnection("client-1"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection not stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- h.Shutdown(context.Background()) }()
+
+	// Without a WSShutdownMessage the close frame comes first
+	assert.NilError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, _, err = client.ReadMessage()
+	var closeErr *websocket.CloseError
+	assert.Assert(t, errors.As(err, &closeErr), "got %v", err)
+	assert.Equal(t, websocket.CloseServiceRestart, closeErr.Code)
+
+	select {
+	case err := <-shutdownDone:
+		assert.NilError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+	<-handlerDone
+}
+
+func TestHandler_Shutdown_WSBoundedByContext(t *testing.T) {
+	h := ags.NewHandler(&ags.ServerConfig{Log: ags.NopLogger{}})
+	release := make(chan struct{})
+	h.RegisterWSConnRoute("/ws", func(conn *ags.WSConnection) {
+		h.StoreWSConnection("client-1", conn)
+		defer h.DeleteWSConnection("client-1")
+		// Never reads, so the close handshake cannot complete
+		<-release
+	})
+	defer close(release)
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/ws", nil)
+	assert.NilError(t, err)
+	defer client.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, ok := h.GetWSConnection("client-1"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection not stored")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err = h.Shutdown(ctx)
+	assert.Assert(t, errors.Is(err, context.DeadlineExceeded), "got %v", err)
+	assert.Assert(t, time.Since(start) < ags.WSCloseTimeout, "Shutdown took %v", time.Since(start))
+}
+
+func TestWebSocketHandler_UpgradeError(t *testing.T) {
+	custom := func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUpgradeRequired)
+		_ = json.NewEncoder(w).Encode(ags.StandardResponse{
+			Message: "upgrade required",
+			Error:   &ags.ErrorInfo{Code: "WS_UPGRADE_FAILED", Message: reason.Error()},
+		})
+	}
+
+	tests := []struct {
+		name       string
+		config     *ags.WSConfig
+		wantStatus int
+		wantCode   ags.ErrorCode
+	}{
+		{name: "default", wantStatus: http.StatusBadRequest, wantCode: ags.ErrCodeBadRequest},
+		{name: "custom", config: &ags.WSConfig{Error: custom}, wantStatus: http.StatusUpgradeRequired, wantCode: "WS_UPGRADE_FAILED"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := ags.NewHandler(&ags.ServerConfig{Log: &mockLogger{}, WebSocket: tt.config})
+			h.RegisterWSRoute("/ws", func(conn *websocket.Conn) {
+				t.Error("handler must not run when the upgrade fails")
+			})
+
+			// Without Sec-WebSocket-Key the upgrade is rejected
+			req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+			req.Header.Set("Connection", "Upgrade")
+			req.Header.Set("Upgrade", "websocket")
+			req.Header.Set("Sec-WebSocket-Version", "13")
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantStatus, rec.Code)
+			assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+			var resp ags.StandardResponse
+			assert.NilError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			assert.Assert(t, !resp.OK)
+			assert.Equal(t, tt.wantCode, resp.Error.Code)
+			assert.Assert(t, strings.Contains(resp.Error.Message, "Sec-WebSocket-Key"), resp.Error.Message)
+		})
+	}
+}
+
+// countingPool is a websocket.BufferPool recording how often it is used
+type countingPool struct {
+	mu   sync.Mutex
+	gets int
+	pool sync.Pool
+}
+
+func (p *countingPool) Get() interface{} {
+	p.mu.Lock()
+	p.gets++
+	p.mu.Unlock()
+	return p.pool.Get()
+}
+
+func (p *countingPool) Put(v interface{}) {
+	p.pool.Put(v)
+}
+
+func (p *countingPool) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.gets
+}
+
+func TestWSConfig_Upgrader(t *testing.T) {
+	pool := &countingPool{}
+	upgrader := &websocket.Upgrader{
+		WriteBufferPool: pool,
+		Subprotocols:    []string{"orders.v1"},
+		CheckOrigin:     func(r *http.Request) bool { return true },
+	}
+
+	h := ags.NewHandler(&ags.ServerConfig{
+		Log:       &mockLogger{},
+		WebSocket: &ags.WSConfig{Upgrader: upgrader},
+	})
+	h.RegisterWSConnRoute("/ws", func(conn *ags.WSConnection) {
+		_ = conn.WriteMessage(websocket.TextMessage, []byte("hello"))
+	})
+
+	s := httptest.NewServer(h)
+	defer s.Close()
+
+	dialer := websocket.Dialer{Subprotocols: []string{"orders.v1"}}
+	client, resp, err := dialer.Dial("ws"+strings.TrimPrefix(s.URL, "http")+"/ws", nil)
+	assert.NilError(t, err)
+	defer client.Close()
+	assert.Equal(t, "orders.v1", resp.Header.Get("Sec-WebSocket-Protocol"))
+
+	assert.NilError(t, client.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, msg, err := client.ReadMessage()
+	assert.NilError(t, err)
+	assert.Equal(t, "hello", string(msg))
+	assert.Assert(t, pool.count() > 0, "the injected write buffer pool was not used")
+}